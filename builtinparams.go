@@ -0,0 +1,333 @@
+package main
+
+import (
+	"encoding/json"
+	"sort"
+	"sync"
+
+	"github.com/crossplane/crossplane-runtime/v2/pkg/errors"
+	"github.com/crossplane/crossplane-runtime/v2/pkg/fieldpath"
+	fnv1 "github.com/crossplane/function-sdk-go/proto/v1"
+	"github.com/crossplane/function-sdk-go/response"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/structpb"
+	"k8s.io/apimachinery/pkg/runtime"
+
+	fkcl "github.com/crossplane-contrib/function-kcl/input/v1beta1"
+)
+
+// The params the function sets itself are most of what a render is fed: the
+// observed and desired state of every composed resource. They used to be read
+// into unstructured objects through the function-sdk request helpers, marshalled
+// to JSON one by one, and then marshalled again as part of the whole input by
+// both the render cache key and the KCL arguments.
+//
+// Instead, builtinParams.encode writes option("params") once, as a single JSON object,
+// straight from the protobufs of the request. That buffer is what the cache key
+// hashes and what KCL is given, and the params of the input are sub-slices of
+// it, so a large request is encoded exactly once.
+//
+// spec.builtinParams lets the input drop whole params and prune sub-trees, e.g.
+// ocds.*.Resource.status. Pruning works on a tree of maps and slices whose
+// leaves are still protobufs; only the structs along a prune path are expanded.
+
+// paramsPool recycles the buffers params are encoded into between calls.
+var paramsPool = sync.Pool{New: func() any { return new([]byte) }}
+
+// builtinParams populates the params the function sets itself.
+type builtinParams struct {
+	spec  *fkcl.BuiltinParams
+	prune map[string][]fieldpath.Segments
+}
+
+// newBuiltinParams parses the prune paths of spec, grouped by param.
+func newBuiltinParams(spec *fkcl.BuiltinParams) (*builtinParams, error) {
+	p := &builtinParams{spec: spec, prune: make(map[string][]fieldpath.Segments)}
+	if spec == nil {
+		return p, nil
+	}
+	for _, path := range spec.Prune {
+		segs, err := fieldpath.Parse(path)
+		if err != nil {
+			return nil, errors.Wrapf(err, "cannot parse prune path %q", path)
+		}
+		if len(segs) < 2 {
+			return nil, errors.Errorf("prune path %q must name a field within a param", path)
+		}
+		p.prune[segs[0].Field] = append(p.prune[segs[0].Field], segs[1:])
+	}
+	return p, nil
+}
+
+// encode appends option("params") to b as a single JSON object: the included
+// built-in params, read from req, and the params of in, which are already JSON.
+// Built-in params take precedence, though a valid input never sets a param of
+// the same name. It then points the params of in at their values within the
+// returned slice.
+func (p *builtinParams) encode(b []byte, req *fnv1.RunFunctionRequest, in *fkcl.KCLInput) ([]byte, error) {
+	values := make(map[string]any, len(fkcl.BuiltinParamNames))
+	for _, name := range fkcl.BuiltinParamNames {
+		if !p.spec.Includes(name) {
+			continue
+		}
+		v := p.builtinParam(req, in, name)
+		for _, segs := range p.prune[name] {
+			v = prune(v, segs)
+		}
+		values[name] = v
+	}
+	keys := make([]string, 0, len(values)+len(in.Spec.Params))
+	for k := range values {
+		keys = append(keys, k)
+	}
+	for k := range in.Spec.Params {
+		if _, ok := values[k]; !ok {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	type span struct{ start, end int }
+	spans := make(map[string]span, len(keys))
+	var err error
+	b = append(b, '{')
+	for i, k := range keys {
+		if i > 0 {
+			b = append(b, ',')
+		}
+		if b, err = appendJSON(b, k); err != nil {
+			return nil, err
+		}
+		b = append(b, ':')
+		start := len(b)
+		if v, ok := values[k]; ok {
+			if b, err = appendJSON(b, v); err != nil {
+				return nil, errors.Wrapf(err, "cannot encode param %q", k)
+			}
+		} else if raw := in.Spec.Params[k].Raw; len(raw) > 0 {
+			b = append(b, raw...)
+		} else {
+			b = append(b, "{}"...)
+		}
+		spans[k] = span{start: start, end: len(b)}
+	}
+	b = append(b, '}')
+
+	in.Spec.Params = make(map[string]runtime.RawExtension, len(keys))
+	for k, s := range spans {
+		in.Spec.Params[k] = runtime.RawExtension{Raw: b[s.start:s.end:s.end]}
+	}
+	return b, nil
+}
+
+// builtinParam returns the named built-in param as a tree of maps and slices
+// whose leaves are the protobufs of req. Its JSON has the shape the function
+// always set: composed resources are keyed by name, with their Resource and
+// either their ConnectionDetails or whether they are Ready. Connection details
+// are base64 encoded.
+func (p *builtinParams) builtinParam(req *fnv1.RunFunctionRequest, in *fkcl.KCLInput, name string) any {
+	switch name {
+	case "meta":
+		// Crossplane does not send the name of the pipeline step, so the input
+		// names it.
+		meta := map[string]any{
+			"tag":  req.GetMeta().GetTag(),
+			"ttl":  int64(response.DefaultTTL.Seconds()),
+			"step": in.StepName(),
+		}
+		if p.spec.Includes("meta.oxrConnectionDetails") {
+			meta["oxrConnectionDetails"] = connectionDetails(req.GetObserved().GetComposite().GetConnectionDetails())
+		}
+		if p.spec.Includes("meta.dxrConnectionDetails") {
+			meta["dxrConnectionDetails"] = connectionDetails(req.GetDesired().GetComposite().GetConnectionDetails())
+		}
+		return meta
+	case "oxr":
+		return req.GetObserved().GetComposite().GetResource()
+	case "dxr":
+		// The desired XR always has the apiVersion and kind of the observed one.
+		oxr := req.GetObserved().GetComposite().GetResource().GetFields()
+		dxr := structFields(req.GetDesired().GetComposite().GetResource())
+		dxr["apiVersion"] = structpb.NewStringValue(oxr["apiVersion"].GetStringValue())
+		dxr["kind"] = structpb.NewStringValue(oxr["kind"].GetStringValue())
+		return dxr
+	case "ocds":
+		out := make(map[string]any, len(req.GetObserved().GetResources()))
+		for n, r := range req.GetObserved().GetResources() {
+			out[n] = map[string]any{"Resource": r.GetResource(), "ConnectionDetails": connectionDetails(r.GetConnectionDetails())}
+		}
+		return out
+	case "dcds":
+		out := make(map[string]any, len(req.GetDesired().GetResources()))
+		for n, r := range req.GetDesired().GetResources() {
+			out[n] = map[string]any{"Resource": r.GetResource(), "Ready": readyString(r.GetReady())}
+		}
+		return out
+	case "ctx":
+		return req.GetContext()
+	case "extraResources":
+		return requiredParam(req.GetExtraResources())
+	case "requiredResources":
+		return requiredParam(req.GetRequiredResources())
+	}
+	return nil
+}
+
+// connectionDetails returns cd as a map that prune can walk. The values are
+// encoded as base64 strings.
+// connectionDetails returns cd as a map, or nil, which is encoded as null, when
+// there are none, as the request helpers decode them.
+func connectionDetails(cd map[string][]byte) any {
+	if cd == nil {
+		return nil
+	}
+	out := make(map[string]any, len(cd))
+	for k, v := range cd {
+		out[k] = v
+	}
+	return out
+}
+
+func requiredParam(in map[string]*fnv1.Resources) map[string]any {
+	out := make(map[string]any, len(in))
+	for n, rs := range in {
+		items := make([]any, 0, len(rs.GetItems()))
+		for _, r := range rs.GetItems() {
+			items = append(items, map[string]any{"Resource": r.GetResource()})
+		}
+		out[n] = items
+	}
+	return out
+}
+
+// readyString mirrors how the function-sdk request helpers read readiness.
+func readyString(r fnv1.Ready) string {
+	switch r {
+	case fnv1.Ready_READY_UNSPECIFIED:
+		return "Unspecified"
+	case fnv1.Ready_READY_TRUE:
+		return "True"
+	case fnv1.Ready_READY_FALSE:
+		return "False"
+	}
+	return ""
+}
+
+// structFields returns the fields of s as a new map, without copying them.
+func structFields(s *structpb.Struct) map[string]any {
+	out := make(map[string]any, len(s.GetFields()))
+	for k, v := range s.GetFields() {
+		out[k] = v
+	}
+	return out
+}
+
+// appendJSON appends v to b as JSON. Map keys are sorted, as protojson sorts
+// the fields of a struct, so the result is deterministic.
+func appendJSON(b []byte, v any) ([]byte, error) {
+	switch t := v.(type) {
+	case map[string]any:
+		keys := make([]string, 0, len(t))
+		for k := range t {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		b = append(b, '{')
+		for i, k := range keys {
+			if i > 0 {
+				b = append(b, ',')
+			}
+			kb, err := json.Marshal(k)
+			if err != nil {
+				return nil, err
+			}
+			b = append(append(b, kb...), ':')
+			if b, err = appendJSON(b, t[k]); err != nil {
+				return nil, err
+			}
+		}
+		return append(b, '}'), nil
+	case []any:
+		b = append(b, '[')
+		for i, item := range t {
+			if i > 0 {
+				b = append(b, ',')
+			}
+			var err error
+			if b, err = appendJSON(b, item); err != nil {
+				return nil, err
+			}
+		}
+		return append(b, ']'), nil
+	case proto.Message:
+		return protojson.MarshalOptions{}.MarshalAppend(b, t)
+	default:
+		vb, err := json.Marshal(t)
+		if err != nil {
+			return nil, err
+		}
+		return append(b, vb...), nil
+	}
+}
+
+// prune returns v without the values at segs, where a * field matches any
+// key or index. Maps and slices along the path are copied rather than
+// modified, and protobuf structs along it are expanded into maps, so v is
+// left as it is.
+func prune(v any, segs fieldpath.Segments) any {
+	if len(segs) == 0 {
+		return v
+	}
+	switch t := v.(type) {
+	case *structpb.Struct:
+		return prune(structFields(t), segs)
+	case *structpb.Value:
+		switch k := t.GetKind().(type) {
+		case *structpb.Value_StructValue:
+			return prune(structFields(k.StructValue), segs)
+		case *structpb.Value_ListValue:
+			items := make([]any, 0, len(k.ListValue.GetValues()))
+			for _, item := range k.ListValue.GetValues() {
+				items = append(items, item)
+			}
+			return prune(items, segs)
+		}
+		return v
+	}
+
+	seg, last := segs[0], len(segs) == 1
+	wildcard := seg.Type == fieldpath.SegmentField && seg.Field == "*"
+	switch t := v.(type) {
+	case map[string]any:
+		if seg.Type != fieldpath.SegmentField {
+			return v
+		}
+		out := make(map[string]any, len(t))
+		for k, child := range t {
+			switch {
+			case !wildcard && k != seg.Field:
+				out[k] = child
+			case !last:
+				out[k] = prune(child, segs[1:])
+			}
+		}
+		return out
+	case []any:
+		if !wildcard && seg.Type != fieldpath.SegmentIndex {
+			return v
+		}
+		out := make([]any, 0, len(t))
+		for i, child := range t {
+			switch {
+			case !wildcard && uint(i) != seg.Index:
+				out = append(out, child)
+			case !last:
+				out = append(out, prune(child, segs[1:]))
+			}
+		}
+		return out
+	default:
+		return v
+	}
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	fnv1 "github.com/crossplane/function-sdk-go/proto/v1"
+	"github.com/crossplane/function-sdk-go/resource"
+	"github.com/google/go-cmp/cmp"
+	"k8s.io/apimachinery/pkg/runtime"
+
+	fkcl "github.com/crossplane-contrib/function-kcl/input/v1beta1"
+)
+
+func TestBuiltinParams(t *testing.T) {
+	req := &fnv1.RunFunctionRequest{
+		Meta: &fnv1.RequestMeta{Tag: "hello"},
+		Observed: &fnv1.State{
+			Composite: &fnv1.Resource{
+				Resource:          resource.MustStructJSON(`{"apiVersion":"example.org/v1","kind":"XR","metadata":{"name":"xr"},"spec":{"tags":["a","b","c"]}}`),
+				ConnectionDetails: map[string][]byte{"username": []byte("admin")},
+			},
+			Resources: map[string]*fnv1.Resource{
+				"bucket": {
+					Resource:          resource.MustStructJSON(`{"metadata":{"name":"a","managedFields":[{"manager":"crossplane"}]},"spec":{"region":"eu-west-1"},"status":{"arn":"arn:a"}}`),
+					ConnectionDetails: map[string][]byte{"password": []byte("secret")},
+				},
+			},
+		},
+		Desired: &fnv1.State{
+			Composite: &fnv1.Resource{
+				Resource:          resource.MustStructJSON(`{"status":{"ready":true}}`),
+				ConnectionDetails: map[string][]byte{"url": []byte("https://a")},
+			},
+			Resources: map[string]*fnv1.Resource{
+				"bucket": {Resource: resource.MustStructJSON(`{"spec":{"region":"eu-west-1"}}`), Ready: fnv1.Ready_READY_TRUE},
+			},
+		},
+		Context: resource.MustStructJSON(`{"apiextensions.crossplane.io/environment":{"tier":"prod"}}`),
+		RequiredResources: map[string]*fnv1.Resources{
+			"config": {Items: []*fnv1.Resource{{Resource: resource.MustStructJSON(`{"data":{"a":"b"}}`)}}},
+		},
+	}
+	cases := map[string]struct {
+		reason string
+		spec   *fkcl.BuiltinParams
+		params map[string]runtime.RawExtension
+		want   string
+	}{
+		"Default": {
+			reason: "Without builtinParams every param but meta should be set in full, in the shape of the request helpers.",
+			params: map[string]runtime.RawExtension{"custom": {Raw: []byte(`"value"`)}},
+			want: `{
+				"custom": "value",
+				"ctx": {"apiextensions.crossplane.io/environment": {"tier": "prod"}},
+				"dcds": {"bucket": {"Resource": {"spec": {"region": "eu-west-1"}}, "Ready": "True"}},
+				"dxr": {"apiVersion": "example.org/v1", "kind": "XR", "status": {"ready": true}},
+				"extraResources": {},
+				"ocds": {"bucket": {"Resource": {"metadata": {"name": "a", "managedFields": [{"manager": "crossplane"}]}, "spec": {"region": "eu-west-1"}, "status": {"arn": "arn:a"}}, "ConnectionDetails": {"password": "c2VjcmV0"}}},
+				"oxr": {"apiVersion": "example.org/v1", "kind": "XR", "metadata": {"name": "xr"}, "spec": {"tags": ["a", "b", "c"]}},
+				"requiredResources": {"config": [{"Resource": {"data": {"a": "b"}}}]}
+			}`,
+		},
+		"Include": {
+			reason: "Params that are not included should not be set.",
+			spec:   &fkcl.BuiltinParams{Include: []string{"oxr"}},
+			want:   `{"oxr": {"apiVersion": "example.org/v1", "kind": "XR", "metadata": {"name": "xr"}, "spec": {"tags": ["a", "b", "c"]}}}`,
+		},
+		"Meta": {
+			reason: "Including meta should add it to the default params, without the connection details within it unless they are included too.",
+			spec:   &fkcl.BuiltinParams{Include: []string{"meta"}},
+			want: `{
+				"ctx": {"apiextensions.crossplane.io/environment": {"tier": "prod"}},
+				"dcds": {"bucket": {"Resource": {"spec": {"region": "eu-west-1"}}, "Ready": "True"}},
+				"dxr": {"apiVersion": "example.org/v1", "kind": "XR", "status": {"ready": true}},
+				"extraResources": {},
+				"meta": {"step": "test", "tag": "hello", "ttl": 60},
+				"ocds": {"bucket": {"Resource": {"metadata": {"name": "a", "managedFields": [{"manager": "crossplane"}]}, "spec": {"region": "eu-west-1"}, "status": {"arn": "arn:a"}}, "ConnectionDetails": {"password": "c2VjcmV0"}}},
+				"oxr": {"apiVersion": "example.org/v1", "kind": "XR", "metadata": {"name": "xr"}, "spec": {"tags": ["a", "b", "c"]}},
+				"requiredResources": {"config": [{"Resource": {"data": {"a": "b"}}}]}
+			}`,
+		},
+		"MetaConnectionDetails": {
+			reason: "Including a field of meta should include meta with that field, alongside the default params listed.",
+			spec:   &fkcl.BuiltinParams{Include: []string{"oxr", "meta.oxrConnectionDetails", "meta.dxrConnectionDetails"}},
+			want:   `{"meta": {"step": "test", "tag": "hello", "ttl": 60, "oxrConnectionDetails": {"username": "YWRtaW4="}, "dxrConnectionDetails": {"url": "aHR0cHM6Ly9h"}}, "oxr": {"apiVersion": "example.org/v1", "kind": "XR", "metadata": {"name": "xr"}, "spec": {"tags": ["a", "b", "c"]}}}`,
+		},
+		"Prune": {
+			reason: "Prune paths should remove sub-trees, with * matching any key or index.",
+			spec: &fkcl.BuiltinParams{
+				Include: []string{"oxr", "ocds"},
+				Prune:   []string{"ocds.*.Resource.status", "ocds.*.Resource.metadata.managedFields", "ocds.*.ConnectionDetails", "oxr.spec.tags[1]"},
+			},
+			want: `{
+				"ocds": {"bucket": {"Resource": {"metadata": {"name": "a"}, "spec": {"region": "eu-west-1"}}}},
+				"oxr": {"apiVersion": "example.org/v1", "kind": "XR", "metadata": {"name": "xr"}, "spec": {"tags": ["a", "c"]}}
+			}`,
+		},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			p, err := newBuiltinParams(tc.spec)
+			if err != nil {
+				t.Fatalf("%s\nnewBuiltinParams(...): unexpected error %v", tc.reason, err)
+			}
+			in := &fkcl.KCLInput{Spec: fkcl.RunSpec{Params: tc.params}}
+			in.Name = "test"
+			b, err := p.encode(nil, req, in)
+			if err != nil {
+				t.Fatalf("%s\nencode(...): unexpected error %v", tc.reason, err)
+			}
+			// protojson does not promise stable whitespace, so compare values.
+			var got, want any
+			if err := json.Unmarshal(b, &got); err != nil {
+				t.Fatalf("%s\nencode(...): invalid JSON %s: %v", tc.reason, b, err)
+			}
+			if err := json.Unmarshal([]byte(tc.want), &want); err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(want, got); diff != "" {
+				t.Errorf("%s\nencode(...): -want, +got:\n%s", tc.reason, diff)
+			}
+			for k, raw := range in.Spec.Params {
+				var v any
+				if err := json.Unmarshal(raw.Raw, &v); err != nil {
+					t.Fatalf("%s\nencode(...): param %q is not JSON: %v", tc.reason, k, err)
+				}
+				if diff := cmp.Diff(got.(map[string]any)[k], v); diff != "" {
+					t.Errorf("%s\nencode(...): param %q: -want, +got:\n%s", tc.reason, k, diff)
+				}
+			}
+		})
+	}
+}
+
+func TestBuiltinParamsNoConnectionDetails(t *testing.T) {
+	req := &fnv1.RunFunctionRequest{
+		Observed: &fnv1.State{
+			Composite: &fnv1.Resource{Resource: resource.MustStructJSON(`{"apiVersion":"example.org/v1","kind":"XR"}`)},
+			Resources: map[string]*fnv1.Resource{"bucket": {Resource: resource.MustStructJSON(`{"spec":{}}`)}},
+		},
+	}
+	p, err := newBuiltinParams(&fkcl.BuiltinParams{Include: []string{"ocds", "meta.oxrConnectionDetails"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := p.encode(nil, req, &fkcl.KCLInput{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got map[string]any
+	if err := json.Unmarshal(b, &got); err != nil {
+		t.Fatal(err)
+	}
+	// Missing connection details are null, as they always were, not {}.
+	if cd, found := got["ocds"].(map[string]any)["bucket"].(map[string]any)["ConnectionDetails"]; !found || cd != nil {
+		t.Errorf("encode(...): want null ocds.bucket.ConnectionDetails, got %v (found %t)", cd, found)
+	}
+	if cd, found := got["meta"].(map[string]any)["oxrConnectionDetails"]; !found || cd != nil {
+		t.Errorf("encode(...): want null meta.oxrConnectionDetails, got %v (found %t)", cd, found)
+	}
+}
+
+func TestPruneLeavesInputUnmodified(t *testing.T) {
+	s := resource.MustStructJSON(`{"spec":{"a":1},"status":{"ready":true}}`)
+	req := &fnv1.RunFunctionRequest{Observed: &fnv1.State{Composite: &fnv1.Resource{Resource: s}}}
+	p, err := newBuiltinParams(&fkcl.BuiltinParams{Include: []string{"oxr"}, Prune: []string{"oxr.status"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := p.encode(nil, req, &fkcl.KCLInput{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := s.GetFields()["status"]; !ok {
+		t.Errorf("encode(...): pruned the request rather than a copy of it")
+	}
+}
+
+func TestFunctionConfig(t *testing.T) {
+	in := &fkcl.KCLInput{Spec: fkcl.RunSpec{Source: "a = 1", Params: map[string]runtime.RawExtension{"a": {Raw: []byte(`1`)}}}}
+	in.Name = "test"
+	params := []byte(`{"a":1}`)
+	got, err := functionConfig(in, params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, err := json.Marshal(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var g, w any
+	if err := json.Unmarshal(got, &g); err != nil {
+		t.Fatalf("functionConfig(...): invalid JSON %s: %v", got, err)
+	}
+	_ = json.Unmarshal(want, &w)
+	if diff := cmp.Diff(w, g); diff != "" {
+		t.Errorf("functionConfig(...): -want, +got:\n%s", diff)
+	}
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"github.com/crossplane/crossplane-runtime/v2/pkg/errors"
+	"github.com/crossplane/crossplane-runtime/v2/pkg/fieldpath"
+	"github.com/crossplane/function-sdk-go/request"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	"k8s.io/utils/ptr"
+
+	fnv1 "github.com/crossplane/function-sdk-go/proto/v1"
+
+	fkcl "github.com/crossplane-contrib/function-kcl/input/v1beta1"
+)
+
+// A ConfigMap sourceRef is requested from Crossplane as a required resource
+// under this name. The function returns without rendering until Crossplane
+// supplies it, and keeps requesting it on every pass so that the requirements
+// stay stable and Crossplane stops calling the function again.
+const configMapSourceRequirement = "krm.kcl.dev/source"
+
+// configMapSourceSelector returns the selector for the ConfigMap sourceRef of
+// in, or nil if in does not have one.
+func configMapSourceSelector(in *fkcl.KCLInput) (*fnv1.ResourceSelector, error) {
+	ref := in.Spec.SourceRef
+	if ref == nil || ref.Kind != fkcl.SourceKindConfigMap {
+		return nil, nil
+	}
+	if err := ref.Validate(field.NewPath("spec.sourceRef")); err != nil {
+		return nil, err
+	}
+	return &fnv1.ResourceSelector{
+		ApiVersion: "v1",
+		Kind:       "ConfigMap",
+		Match:      &fnv1.ResourceSelector_MatchName{MatchName: ref.ConfigMap.Name},
+		Namespace:  ptr.To(ref.ConfigMap.Namespace),
+	}, nil
+}
+
+// configMapSourceData returns the data of the ConfigMap sourceRef supplied
+// with req. It returns false if Crossplane has not supplied it yet.
+func configMapSourceData(req *fnv1.RunFunctionRequest) (map[string]string, bool, error) {
+	required, err := request.GetRequiredResources(req)
+	if err != nil {
+		return nil, false, errors.Wrapf(err, "cannot get required resources from %T", req)
+	}
+	cms, ok := required[configMapSourceRequirement]
+	if !ok {
+		return nil, false, nil
+	}
+	if len(cms) == 0 {
+		return nil, true, errors.New("source ConfigMap not found")
+	}
+	data := map[string]string{}
+	if err := fieldpath.Pave(cms[0].Resource.Object).GetValueInto("data", &data); err != nil && !fieldpath.IsNotFound(err) {
+		return nil, true, errors.Wrap(err, "cannot read source ConfigMap data")
+	}
+	return data, true, nil
+}
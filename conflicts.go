@@ -0,0 +1,100 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/crossplane/crossplane-runtime/v2/pkg/errors"
+	fnv1 "github.com/crossplane/function-sdk-go/proto/v1"
+	"github.com/crossplane/function-sdk-go/request"
+	"github.com/crossplane/function-sdk-go/resource"
+
+	fkcl "github.com/crossplane-contrib/function-kcl/input/v1beta1"
+	pkgresource "github.com/crossplane-contrib/function-kcl/pkg/resource"
+)
+
+// Every target but PatchDesired replaces or merges into desired resources
+// without regard for which step set their fields, so a step could silently
+// clobber the work of the steps before it. checkConflicts compares the desired
+// state the step leaves with the one it was sent, once the output is
+// processed, so that every target and meta kind is covered alike.
+//
+// The output is still merged with overwrite: a conflict is only known once
+// the output is processed, since replacing targets, Patches and defaults all
+// change fields outside of the merge, so Skip restores the earlier values
+// after the fact rather than keeping the merge from changing them.
+
+// checkConflicts returns a message for each field of the desired XR and
+// composed resources of req that dxr and desired change or delete, restoring
+// them with the Skip conflict policy. With spec.fieldOwners, it also records
+// the fields each desired composed resource it changes owes to this step.
+func checkConflicts(req *fnv1.RunFunctionRequest, in *fkcl.KCLInput, dxr *resource.Composite, desired map[resource.Name]*resource.DesiredComposed) ([]string, error) {
+	policy := in.Spec.ConflictPolicy
+	if policy == "" {
+		policy = pkgresource.ConflictOverwrite
+	}
+	if policy == pkgresource.ConflictOverwrite && !in.Spec.FieldOwners {
+		return nil, nil
+	}
+	// The request is decoded again, since processing the output modified the
+	// copies decoded before.
+	beforeXR, err := request.GetDesiredCompositeResource(req)
+	if err != nil {
+		return nil, errors.Wrap(err, "cannot get desired composite resource")
+	}
+	before, err := request.GetDesiredComposedResources(req)
+	if err != nil {
+		return nil, errors.Wrap(err, "cannot get desired composed resources")
+	}
+
+	var msgs []string
+	report := func(what string, conflicts []pkgresource.Conflict) {
+		for _, c := range conflicts {
+			msg := fmt.Sprintf("%s: %s", what, c.Path)
+			if c.Owner != "" {
+				msg += fmt.Sprintf(" set by step %q", c.Owner)
+			}
+			msgs = append(msgs, msg)
+		}
+	}
+	if policy != pkgresource.ConflictOverwrite {
+		conflicts, err := pkgresource.ResolveConflicts(beforeXR.Resource.Object, dxr.Resource.Object, policy)
+		if err != nil {
+			return nil, errors.Wrap(err, "desired composite resource")
+		}
+		report("desired composite resource", conflicts)
+	}
+
+	names := make([]string, 0, len(before))
+	for name := range before {
+		names = append(names, string(name))
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		b, a := before[resource.Name(name)], desired[resource.Name(name)]
+		if a == nil {
+			// Deleted by a DeleteResources meta resource.
+			continue
+		}
+		if policy != pkgresource.ConflictOverwrite {
+			conflicts, err := pkgresource.ResolveConflicts(b.Resource.Object, a.Resource.Object, policy)
+			if err != nil {
+				return nil, errors.Wrapf(err, "desired composed resource %q", name)
+			}
+			report(fmt.Sprintf("desired composed resource %q", name), conflicts)
+		}
+	}
+	if in.Spec.FieldOwners {
+		// Only resources the step adds or changes are annotated.
+		for name, a := range desired {
+			var b map[string]interface{}
+			if cd, ok := before[name]; ok {
+				b = cd.Resource.Object
+			}
+			if err := pkgresource.RecordFieldOwners(b, a.Resource.Object, in.StepName(), in.Spec.FieldOwnersDepth); err != nil {
+				return nil, errors.Wrapf(err, "desired composed resource %q", name)
+			}
+		}
+	}
+	return msgs, nil
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"testing"
+
+	fnv1 "github.com/crossplane/function-sdk-go/proto/v1"
+	"github.com/crossplane/function-sdk-go/request"
+	"github.com/crossplane/function-sdk-go/resource"
+	"github.com/google/go-cmp/cmp"
+
+	fkcl "github.com/crossplane-contrib/function-kcl/input/v1beta1"
+	pkgresource "github.com/crossplane-contrib/function-kcl/pkg/resource"
+)
+
+func TestCheckConflicts(t *testing.T) {
+	req := &fnv1.RunFunctionRequest{
+		Desired: &fnv1.State{
+			Composite: &fnv1.Resource{Resource: resource.MustStructJSON(`{"status":{"phase":"Ready"}}`)},
+			Resources: map[string]*fnv1.Resource{
+				"bucket": {Resource: resource.MustStructJSON(`{"apiVersion":"s3.aws.upbound.io/v1beta1","kind":"Bucket","spec":{"region":"eu-west-1"}}`)},
+				"queue":  {Resource: resource.MustStructJSON(`{"apiVersion":"sqs.aws.upbound.io/v1beta1","kind":"Queue"}`)},
+			},
+		},
+	}
+	cases := map[string]struct {
+		reason string
+		policy pkgresource.ConflictPolicy
+		want   []string
+		region string
+	}{
+		"Overwrite": {
+			reason: "Nothing should be checked with the Overwrite policy.",
+			policy: pkgresource.ConflictOverwrite,
+			region: "us-east-1",
+		},
+		"Fail": {
+			reason: "Every changed field should be reported with the Fail policy.",
+			policy: pkgresource.ConflictFail,
+			want:   []string{"desired composite resource: status.phase", `desired composed resource "bucket": spec.region`},
+			region: "us-east-1",
+		},
+		"Skip": {
+			reason: "Changed fields should be restored with the Skip policy.",
+			policy: pkgresource.ConflictSkip,
+			want:   []string{"desired composite resource: status.phase", `desired composed resource "bucket": spec.region`},
+			region: "eu-west-1",
+		},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			dxr, _ := request.GetDesiredCompositeResource(req)
+			desired, _ := request.GetDesiredComposedResources(req)
+			_ = dxr.Resource.SetValue("status.phase", "Creating")
+			_ = desired["bucket"].Resource.SetValue("spec.region", "us-east-1")
+			delete(desired, "queue")
+
+			in := &fkcl.KCLInput{Spec: fkcl.RunSpec{ConflictPolicy: tc.policy}}
+			got, err := checkConflicts(req, in, dxr, desired)
+			if err != nil {
+				t.Fatalf("%s\ncheckConflicts(...): unexpected error %v", tc.reason, err)
+			}
+			if diff := cmp.Diff(tc.want, got); diff != "" {
+				t.Errorf("%s\ncheckConflicts(...): -want, +got:\n%s", tc.reason, diff)
+			}
+			if region, _ := desired["bucket"].Resource.GetString("spec.region"); region != tc.region {
+				t.Errorf("%s\ncheckConflicts(...): want spec.region %q, got %q", tc.reason, tc.region, region)
+			}
+		})
+	}
+}
+
+func TestCheckConflictsFieldOwners(t *testing.T) {
+	req := &fnv1.RunFunctionRequest{
+		Desired: &fnv1.State{
+			Composite: &fnv1.Resource{Resource: resource.MustStructJSON(`{}`)},
+			Resources: map[string]*fnv1.Resource{
+				"bucket": {Resource: resource.MustStructJSON(`{"apiVersion":"s3.aws.upbound.io/v1beta1","kind":"Bucket","metadata":{"annotations":{"krm.kcl.dev/field-owners":"{\"spec\":\"base\",\"metadata.name\":\"base\"}"},"name":"bucket"},"spec":{"region":"eu-west-1"}}`)},
+				"queue":  {Resource: resource.MustStructJSON(`{"apiVersion":"sqs.aws.upbound.io/v1beta1","kind":"Queue","metadata":{"name":"queue"},"spec":{"region":"eu-west-1"}}`)},
+			},
+		},
+	}
+	dxr, _ := request.GetDesiredCompositeResource(req)
+	desired, _ := request.GetDesiredComposedResources(req)
+	_ = desired["bucket"].Resource.SetValue("spec.region", "us-east-1")
+
+	// The step is named by spec.step rather than by the name of the input.
+	in := &fkcl.KCLInput{Spec: fkcl.RunSpec{FieldOwners: true, Step: "networking"}}
+	in.SetName("basic")
+	if _, err := checkConflicts(req, in, dxr, desired); err != nil {
+		t.Fatalf("checkConflicts(...): unexpected error %v", err)
+	}
+	got, err := pkgresource.FieldOwners(desired["bucket"].Resource.Object)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{"metadata.name": "base", "spec.region": "networking"}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("checkConflicts(...): -want owners, +got owners:\n%s", diff)
+	}
+	// A resource the step did not change is not annotated.
+	if _, ok := desired["queue"].Resource.GetAnnotations()[pkgresource.AnnotationKeyFieldOwners]; ok {
+		t.Errorf("checkConflicts(...): want no field owners on the unchanged queue, got %v", desired["queue"].Resource.GetAnnotations())
+	}
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/crossplane/crossplane-runtime/v2/pkg/errors"
+	fnv1 "github.com/crossplane/function-sdk-go/proto/v1"
+	"kcl-lang.io/kpm/pkg/client"
+
+	fkcl "github.com/crossplane-contrib/function-kcl/input/v1beta1"
+)
+
+// Registry credentials used to be copied into spec.credentials, from where they
+// were marshalled into the functionConfig that any KCL program can read, hashed
+// into the render cache key and written to debug logs. They are now taken out
+// of the input before anything serializes it, and only used to log kpm in to
+// the registry for the fetches of the request.
+
+const (
+	envSourceURL      = "KCL_SRC_URL"
+	envSourceUsername = "KCL_SRC_USERNAME"
+	envSourcePassword = "KCL_SRC_PASSWORD"
+)
+
+// registryCredentials authenticate against the OCI registry KCL sources and
+// dependencies are fetched from.
+type registryCredentials struct {
+	url      string
+	username string
+	password string
+}
+
+// takeCredentials returns the registry credentials for in and clears them from
+// its spec. The function credentials named name take precedence over the
+// spec.credentials of a v1alpha1 input, which take precedence over the
+// KCL_SRC_* environment variables. A v1beta1 spec.credentials only names the
+// registry, for credentials that do not. ok is false when there is no
+// password.
+func takeCredentials(req *fnv1.RunFunctionRequest, in *fkcl.KCLInput, name string) (creds registryCredentials, ok bool) {
+	spec := in.Spec.Credentials
+	in.Spec.Credentials = fkcl.CredSpec{}
+
+	defer func() {
+		if creds.url == "" {
+			creds.url = spec.Url
+		}
+	}()
+	if data := req.GetCredentials()[name].GetCredentialData().GetData(); data != nil {
+		if password, ok := data["password"]; ok {
+			return registryCredentials{url: string(data["url"]), username: string(data["username"]), password: string(password)}, true
+		}
+	}
+	if c := legacyCredentials(req); c.password != "" {
+		return c, true
+	}
+	if password := os.Getenv(envSourcePassword); password != "" {
+		return registryCredentials{url: os.Getenv(envSourceURL), username: os.Getenv(envSourceUsername), password: password}, true
+	}
+	return registryCredentials{}, false
+}
+
+// legacyCredentials returns the spec.credentials of a v1alpha1 input, read
+// from the input of req since v1beta1 has no username and password to convert
+// them to.
+func legacyCredentials(req *fnv1.RunFunctionRequest) registryCredentials {
+	if req.GetInput().GetFields()["apiVersion"].GetStringValue() == fkcl.GroupVersion {
+		return registryCredentials{}
+	}
+	c := req.GetInput().GetFields()["spec"].GetStructValue().GetFields()["credentials"].GetStructValue().GetFields()
+	return registryCredentials{url: c["url"].GetStringValue(), username: c["username"].GetStringValue(), password: c["password"].GetStringValue()}
+}
+
+// host returns the registry to log in to: the host of the credentials url, or
+// else of an oci:// source.
+func (c registryCredentials) host(source string) string {
+	u := c.url
+	if u == "" && strings.HasPrefix(source, "oci://") {
+		u = source
+	}
+	for _, scheme := range []string{"oci://", "https://", "http://"} {
+		u = strings.TrimPrefix(u, scheme)
+	}
+	host, _, _ := strings.Cut(u, "/")
+	return host
+}
+
+// registryAuth is a kpm credentials file holding the login of a single
+// request, in a temporary directory of its own. kpm's shared credential store
+// would keep the login on disk for any later KCL program to read with
+// file.read; this file is removed as soon as the request has fetched what it
+// needs, before its KCL program runs.
+type registryAuth struct {
+	dir string
+}
+
+// newRegistryAuth logs in to host with creds, into a new credentials file.
+func newRegistryAuth(host string, creds registryCredentials) (*registryAuth, error) {
+	dir, err := os.MkdirTemp("", "kcl-registry-auth")
+	if err != nil {
+		return nil, errors.Wrap(err, "cannot create registry credentials directory")
+	}
+	a := &registryAuth{dir: dir}
+	cli, err := a.client()
+	if err != nil {
+		_ = a.Close()
+		return nil, err
+	}
+	if err := cli.LoginOci(host, creds.username, creds.password); err != nil {
+		_ = a.Close()
+		return nil, errors.Wrapf(err, "cannot log in to registry %s", host)
+	}
+	return a, nil
+}
+
+// client returns a kpm client that authenticates with the credentials file of
+// a, or with kpm's own credential store when a is nil.
+func (a *registryAuth) client() (*client.KpmClient, error) {
+	cli, err := client.NewKpmClient()
+	if err != nil {
+		return nil, errors.Wrap(err, "cannot create kpm client")
+	}
+	if a != nil {
+		cli.GetSettings().CredentialsFile = filepath.Join(a.dir, "config.json")
+	}
+	return cli, nil
+}
+
+// pull fetches the module of an oci:// source into dir and returns its path.
+func (a *registryAuth) pull(source, dir string) (string, error) {
+	cli, err := a.client()
+	if err != nil {
+		return "", err
+	}
+	p, err := cli.Pull(client.WithPullSourceUrl(source), client.WithLocalPath(dir))
+	if err != nil {
+		return "", errors.Wrapf(err, "cannot pull %s", source)
+	}
+	return p.HomePath, nil
+}
+
+// Close removes the credentials file. It may be called more than once, and on
+// a nil registryAuth.
+func (a *registryAuth) Close() error {
+	if a == nil {
+		return nil
+	}
+	return os.RemoveAll(a.dir)
+}
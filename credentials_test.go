@@ -0,0 +1,115 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	fnv1 "github.com/crossplane/function-sdk-go/proto/v1"
+	"github.com/crossplane/function-sdk-go/resource"
+	"github.com/google/go-cmp/cmp"
+	"google.golang.org/protobuf/types/known/structpb"
+
+	fkcl "github.com/crossplane-contrib/function-kcl/input/v1beta1"
+)
+
+func TestTakeCredentials(t *testing.T) {
+	secret := func(data map[string][]byte) map[string]*fnv1.Credentials {
+		return map[string]*fnv1.Credentials{"kcl-registry": {Source: &fnv1.Credentials_CredentialData{CredentialData: &fnv1.CredentialData{Data: data}}}}
+	}
+	type want struct {
+		creds registryCredentials
+		ok    bool
+	}
+	v1alpha1 := func(creds string) *structpb.Struct {
+		return resource.MustStructJSON(`{"apiVersion":"krm.kcl.dev/v1alpha1","kind":"KCLInput","spec":{"credentials":` + creds + `}}`)
+	}
+	cases := map[string]struct {
+		reason string
+		creds  map[string]*fnv1.Credentials
+		input  *structpb.Struct
+		spec   fkcl.CredSpec
+		env    map[string]string
+		want   want
+	}{
+		"Request": {
+			reason: "Function credentials should take precedence over the input.",
+			creds:  secret(map[string][]byte{"url": []byte("ghcr.io"), "username": []byte("a"), "password": []byte("b")}),
+			input:  v1alpha1(`{"url":"example.com","username":"c","password":"d"}`),
+			want:   want{creds: registryCredentials{url: "ghcr.io", username: "a", password: "b"}, ok: true},
+		},
+		"RequestWithoutPassword": {
+			reason: "Function credentials without a password should be ignored.",
+			creds:  secret(map[string][]byte{"username": []byte("a")}),
+			input:  v1alpha1(`{"url":"example.com","username":"c","password":"d"}`),
+			want:   want{creds: registryCredentials{url: "example.com", username: "c", password: "d"}, ok: true},
+		},
+		"V1beta1Password": {
+			reason: "A password in a v1beta1 input should be ignored, and its url used for credentials that name no registry.",
+			input:  resource.MustStructJSON(`{"apiVersion":"krm.kcl.dev/v1beta1","kind":"KCLInput","spec":{"credentials":{"username":"c","password":"d"}}}`),
+			spec:   fkcl.CredSpec{Url: "example.com"},
+			env:    map[string]string{envSourceUsername: "e", envSourcePassword: "f"},
+			want:   want{creds: registryCredentials{url: "example.com", username: "e", password: "f"}, ok: true},
+		},
+		"Environment": {
+			reason: "The KCL_SRC_* environment variables should be used when nothing else is set.",
+			env:    map[string]string{envSourceURL: "ghcr.io", envSourceUsername: "e", envSourcePassword: "f"},
+			want:   want{creds: registryCredentials{url: "ghcr.io", username: "e", password: "f"}, ok: true},
+		},
+		"None": {
+			reason: "No password anywhere should mean no credentials.",
+		},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			for _, k := range []string{envSourceURL, envSourceUsername, envSourcePassword} {
+				t.Setenv(k, tc.env[k])
+			}
+			in := &fkcl.KCLInput{Spec: fkcl.RunSpec{Credentials: tc.spec}}
+			creds, ok := takeCredentials(&fnv1.RunFunctionRequest{Credentials: tc.creds, Input: tc.input}, in, "kcl-registry")
+			if diff := cmp.Diff(tc.want, want{creds: creds, ok: ok}, cmp.AllowUnexported(want{}, registryCredentials{})); diff != "" {
+				t.Errorf("%s\ntakeCredentials(...): -want, +got:\n%s", tc.reason, diff)
+			}
+			if in.Spec.Credentials != (fkcl.CredSpec{}) {
+				t.Errorf("%s\ntakeCredentials(...): left %+v in the input", tc.reason, in.Spec.Credentials)
+			}
+		})
+	}
+}
+
+func TestRegistryCredentialsHost(t *testing.T) {
+	cases := map[string]struct {
+		url, source, want string
+	}{
+		"URL":       {url: "https://ghcr.io/", source: "oci://example.com/a", want: "ghcr.io"},
+		"OCISource": {source: "oci://ghcr.io/kcl-lang/app?tag=1", want: "ghcr.io"},
+		"Inline":    {source: "a = 1"},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			if got := (registryCredentials{url: tc.url}).host(tc.source); got != tc.want {
+				t.Errorf("host(%q) = %q, want %q", tc.source, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestRegistryAuthClose(t *testing.T) {
+	a := &registryAuth{dir: t.TempDir()}
+	cli, err := a.client()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := cli.GetSettings().CredentialsFile; filepath.Dir(got) != a.dir {
+		t.Errorf("client(): credentials file %q is not in %q", got, a.dir)
+	}
+	if err := a.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(a.dir); !os.IsNotExist(err) {
+		t.Errorf("Close(): want %q removed, got %v", a.dir, err)
+	}
+	if err := (*registryAuth)(nil).Close(); err != nil {
+		t.Errorf("Close() on nil: %v", err)
+	}
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"k8s.io/utils/ptr"
+
+	fnv1 "github.com/crossplane/function-sdk-go/proto/v1"
+)
+
+// KCL reports compile and runtime errors as rustc-style diagnostics, several
+// of them concatenated into one error string:
+//
+//	error[E2G22]: TypeError
+//	 --> prog.k:1:10
+//	  |
+//	1 | a: int = "1"
+//	  |          ^ expected int, got str(1)
+//	  |
+//
+// Wrapped into a single Fatal result that is one long line in the XR's status
+// and events. parseDiagnostics splits it back into its parts so each error can
+// be reported on its own.
+
+// conditionTypeRendered is the XR condition that summarises KCL diagnostics.
+const conditionTypeRendered = "KCLRendered"
+
+// diagnostic is a single KCL compile or runtime error.
+type diagnostic struct {
+	// Code is the KCL error code, e.g. E2G22.
+	Code string
+	// Kind is the KCL error kind, e.g. TypeError.
+	Kind string
+	// File, Line and Column locate the error, when KCL reports a location.
+	File   string
+	Line   int
+	Column int
+	// Message describes the error.
+	Message string
+	// Snippet is the source the error points at.
+	Snippet string
+}
+
+var (
+	diagnosticLocation = regexp.MustCompile(`^\s*-->\s*(.+?):(\d+)(?::(\d+))?\s*$`)
+	diagnosticGutter   = regexp.MustCompile(`^\s*(\d*)\s*\|(.*)$`)
+)
+
+// parseDiagnostics returns the KCL diagnostics in msg, in order. It returns
+// nil if msg does not contain any.
+func parseDiagnostics(msg string) []diagnostic {
+	headers := kclErrorCode.FindAllStringSubmatchIndex(msg, -1)
+	diags := make([]diagnostic, 0, len(headers))
+	for i, h := range headers {
+		end := len(msg)
+		if i+1 < len(headers) {
+			end = headers[i+1][0]
+		}
+		d := diagnostic{
+			Code: "E" + msg[h[2]:h[3]] + msg[h[4]:h[5]],
+			Kind: msg[h[6]:h[7]],
+		}
+		var message, snippet []string
+		for _, line := range strings.Split(msg[h[1]:end], "\n") {
+			if m := diagnosticLocation.FindStringSubmatch(line); m != nil {
+				d.File = m[1]
+				d.Line, _ = strconv.Atoi(m[2])
+				d.Column, _ = strconv.Atoi(m[3])
+				continue
+			}
+			if m := diagnosticGutter.FindStringSubmatch(line); m != nil {
+				if m[1] != "" {
+					snippet = append(snippet, m[1]+" |"+m[2])
+					continue
+				}
+				// An unnumbered gutter line carries the markers pointing at the
+				// error, followed by the message.
+				if text := strings.TrimSpace(strings.TrimLeft(m[2], " ^~-")); text != "" {
+					message = append(message, text)
+				}
+				continue
+			}
+			if text := strings.TrimSpace(line); text != "" {
+				message = append(message, text)
+			}
+		}
+		d.Message = strings.Join(message, " ")
+		if d.Message == "" {
+			d.Message = d.Kind
+		}
+		d.Snippet = strings.Join(snippet, "\n")
+		diags = append(diags, d)
+	}
+	if len(diags) == 0 {
+		return nil
+	}
+	return diags
+}
+
+// reason classifies the diagnostic. The first digit of the code is the phase:
+// 1 parse, 2 compile, 3 runtime.
+func (d diagnostic) reason() failureReason {
+	switch {
+	case strings.HasPrefix(d.Code, "E1"):
+		return reasonSyntaxError
+	case strings.HasPrefix(d.Code, "E2") && d.Kind == "TypeError":
+		return reasonTypeError
+	case strings.HasPrefix(d.Code, "E2"):
+		return reasonCompileError
+	default:
+		return reasonRuntimeError
+	}
+}
+
+// location returns file:line:column, omitting whatever KCL did not report.
+func (d diagnostic) location() string {
+	switch {
+	case d.File == "":
+		return ""
+	case d.Line == 0:
+		return d.File
+	case d.Column == 0:
+		return fmt.Sprintf("%s:%d", d.File, d.Line)
+	default:
+		return fmt.Sprintf("%s:%d:%d", d.File, d.Line, d.Column)
+	}
+}
+
+// String returns a one line summary of the diagnostic.
+func (d diagnostic) String() string {
+	if loc := d.location(); loc != "" {
+		return fmt.Sprintf("%s: %s[%s]: %s", loc, d.Kind, d.Code, d.Message)
+	}
+	return fmt.Sprintf("%s[%s]: %s", d.Kind, d.Code, d.Message)
+}
+
+// setDiagnostics adds a Fatal result for each diagnostic and a False
+// KCLRendered condition summarising them to rsp.
+func setDiagnostics(rsp *fnv1.RunFunctionResponse, diags []diagnostic) {
+	for _, d := range diags {
+		msg := d.String()
+		if d.Snippet != "" {
+			msg += "\n" + d.Snippet
+		}
+		rsp.Results = append(rsp.GetResults(), &fnv1.Result{
+			Severity: fnv1.Severity_SEVERITY_FATAL,
+			Message:  msg,
+			Reason:   ptr.To(string(d.reason())),
+			Target:   fnv1.Target_TARGET_COMPOSITE.Enum(),
+		})
+	}
+
+	msg := diags[0].String()
+	if n := len(diags) - 1; n > 0 {
+		msg = fmt.Sprintf("%s (and %d more KCL error(s))", msg, n)
+	}
+	rsp.Conditions = append(rsp.GetConditions(), &fnv1.Condition{
+		Type:    conditionTypeRendered,
+		Status:  fnv1.Status_STATUS_CONDITION_FALSE,
+		Reason:  string(diags[0].reason()),
+		Message: ptr.To(msg),
+		Target:  fnv1.Target_TARGET_COMPOSITE.Enum(),
+	})
+}
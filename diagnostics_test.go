@@ -0,0 +1,131 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/crossplane/crossplane-runtime/v2/pkg/errors"
+	"github.com/google/go-cmp/cmp"
+	"google.golang.org/protobuf/testing/protocmp"
+	"k8s.io/utils/ptr"
+
+	fnv1 "github.com/crossplane/function-sdk-go/proto/v1"
+)
+
+const kclTwoErrors = `error[E2L23]: CompileError
+ --> /tmp/kcl-sandbox/prog.k:2:5
+  |
+2 | b = c
+  |     ^ name 'c' is not defined
+  |
+
+error[E2G22]: TypeError
+ --> /tmp/kcl-sandbox/prog.k:3:10
+  |
+3 | d: int = "1"
+  |          ^ expected int, got str(1)
+  |
+`
+
+func TestParseDiagnostics(t *testing.T) {
+	cases := map[string]struct {
+		reason string
+		msg    string
+		want   []diagnostic
+	}{
+		"NoDiagnostics": {
+			reason: "Errors that are not KCL diagnostics should not be parsed.",
+			msg:    "cannot pull oci://ghcr.io/x: not found",
+			want:   nil,
+		},
+		"Single": {
+			reason: "A single diagnostic should be parsed into all of its parts.",
+			msg:    kclTypeError,
+			want: []diagnostic{{
+				Code: "E2G22", Kind: "TypeError", File: "prog.k", Line: 1, Column: 1,
+				Message: "expected int, got str(1)",
+				Snippet: `1 | a: int = "1"`,
+			}},
+		},
+		"Multiple": {
+			reason: "Concatenated diagnostics should be reported separately and in order.",
+			msg:    "failed to compile: " + kclTwoErrors,
+			want: []diagnostic{
+				{
+					Code: "E2L23", Kind: "CompileError", File: "/tmp/kcl-sandbox/prog.k", Line: 2, Column: 5,
+					Message: "name 'c' is not defined",
+					Snippet: "2 | b = c",
+				},
+				{
+					Code: "E2G22", Kind: "TypeError", File: "/tmp/kcl-sandbox/prog.k", Line: 3, Column: 10,
+					Message: "expected int, got str(1)",
+					Snippet: `3 | d: int = "1"`,
+				},
+			},
+		},
+		"NoLocation": {
+			reason: "A diagnostic without a location should keep its message.",
+			msg:    "error[E3M38]: EvaluationError\nboom",
+			want:   []diagnostic{{Code: "E3M38", Kind: "EvaluationError", Message: "boom"}},
+		},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			got := parseDiagnostics(tc.msg)
+			if diff := cmp.Diff(tc.want, got); diff != "" {
+				t.Errorf("%s\nparseDiagnostics(...): -want, +got:\n%s", tc.reason, diff)
+			}
+		})
+	}
+}
+
+func TestDiagnosticReason(t *testing.T) {
+	cases := map[string]struct {
+		d    diagnostic
+		want failureReason
+	}{
+		"Syntax":  {d: diagnostic{Code: "E1001", Kind: "InvalidSyntax"}, want: reasonSyntaxError},
+		"Type":    {d: diagnostic{Code: "E2G22", Kind: "TypeError"}, want: reasonTypeError},
+		"Compile": {d: diagnostic{Code: "E2L23", Kind: "CompileError"}, want: reasonCompileError},
+		"Runtime": {d: diagnostic{Code: "E3M38", Kind: "EvaluationError"}, want: reasonRuntimeError},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			if got := tc.d.reason(); got != tc.want {
+				t.Errorf("reason() = %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestFailRender(t *testing.T) {
+	rsp, err := failRender(&fnv1.RunFunctionResponse{}, errors.New(kclTwoErrors))
+	if err != nil {
+		t.Fatalf("failRender(...): unexpected error %v", err)
+	}
+	want := &fnv1.RunFunctionResponse{
+		Results: []*fnv1.Result{
+			{
+				Severity: fnv1.Severity_SEVERITY_FATAL,
+				Message:  "/tmp/kcl-sandbox/prog.k:2:5: CompileError[E2L23]: name 'c' is not defined\n2 | b = c",
+				Reason:   ptr.To("CompileError"),
+				Target:   fnv1.Target_TARGET_COMPOSITE.Enum(),
+			},
+			{
+				Severity: fnv1.Severity_SEVERITY_FATAL,
+				Message:  "/tmp/kcl-sandbox/prog.k:3:10: TypeError[E2G22]: expected int, got str(1)\n3 | d: int = \"1\"",
+				Reason:   ptr.To("TypeError"),
+				Target:   fnv1.Target_TARGET_COMPOSITE.Enum(),
+			},
+		},
+		Conditions: []*fnv1.Condition{{
+			Type:    conditionTypeRendered,
+			Status:  fnv1.Status_STATUS_CONDITION_FALSE,
+			Reason:  "CompileError",
+			Message: ptr.To("/tmp/kcl-sandbox/prog.k:2:5: CompileError[E2L23]: name 'c' is not defined (and 1 more KCL error(s))"),
+			Target:  fnv1.Target_TARGET_COMPOSITE.Enum(),
+		}},
+	}
+	if diff := cmp.Diff(want, rsp, protocmp.Transform()); diff != "" {
+		t.Errorf("failRender(...): -want rsp, +got rsp:\n%s", diff)
+	}
+}
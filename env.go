@@ -0,0 +1,52 @@
+package main
+
+import (
+	"os"
+	"path"
+	"strings"
+
+	fkcl "github.com/crossplane-contrib/function-kcl/input/v1beta1"
+)
+
+// KCL code reads the environment of the function as option("env"), and its
+// PATH as option("PATH"). Exposing the whole environment would hand every
+// secret injected into the pod to every composition, so only the variables an
+// envPolicy allows are exposed, along with the static env of the input.
+
+// envPolicy decides which environment variables of the function are exposed
+// to KCL code. Both lists hold shell patterns, such as KCL_*. The zero policy
+// exposes nothing.
+type envPolicy struct {
+	allow []string
+	deny  []string
+}
+
+// allowed reports whether the variable name is exposed. deny takes precedence
+// over allow.
+func (p envPolicy) allowed(name string) bool {
+	return !matchAny(p.deny, name) && matchAny(p.allow, name)
+}
+
+func matchAny(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// environ returns the environment exposed to the KCL code of in: the allowed
+// variables of the function, overridden by spec.env.
+func (p envPolicy) environ(in *fkcl.KCLInput) map[string]string {
+	env := make(map[string]string, len(in.Spec.Env))
+	for _, e := range os.Environ() {
+		if k, v, ok := strings.Cut(e, "="); ok && p.allowed(k) {
+			env[k] = v
+		}
+	}
+	for k, v := range in.Spec.Env {
+		env[k] = v
+	}
+	return env
+}
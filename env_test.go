@@ -0,0 +1,62 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	fkcl "github.com/crossplane-contrib/function-kcl/input/v1beta1"
+)
+
+func TestEnvPolicy(t *testing.T) {
+	t.Setenv("PATH", "/bin")
+	t.Setenv("KCL_SRC_PASSWORD", "secret")
+	t.Setenv("KCL_FAST_EVAL", "1")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+
+	cases := map[string]struct {
+		reason string
+		policy envPolicy
+		env    map[string]string
+		want   map[string]string
+	}{
+		"Zero": {
+			reason: "The zero policy should expose nothing.",
+			want:   map[string]string{},
+		},
+		"Allow": {
+			reason: "Only allowed variables should be exposed.",
+			policy: envPolicy{allow: []string{"PATH", "KCL_*"}},
+			want:   map[string]string{"PATH": "/bin", "KCL_SRC_PASSWORD": "secret", "KCL_FAST_EVAL": "1"},
+		},
+		"Deny": {
+			reason: "Denied variables should not be exposed, even if allowed.",
+			policy: envPolicy{allow: []string{"PATH", "KCL_*"}, deny: []string{"KCL_SRC_PASSWORD"}},
+			want:   map[string]string{"PATH": "/bin", "KCL_FAST_EVAL": "1"},
+		},
+		"Static": {
+			reason: "The static env of the input should be exposed, overriding the environment.",
+			policy: envPolicy{allow: []string{"PATH"}},
+			env:    map[string]string{"PATH": "/usr/bin", "TIER": "prod"},
+			want:   map[string]string{"PATH": "/usr/bin", "TIER": "prod"},
+		},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			got := tc.policy.environ(&fkcl.KCLInput{Spec: fkcl.RunSpec{Env: tc.env}})
+			for k := range got {
+				// Ignore whatever else the test process runs with.
+				if _, ok := tc.want[k]; !ok && tc.env[k] == "" && !tc.policy.allowed(k) {
+					t.Errorf("%s\nenviron(...): exposed %s", tc.reason, k)
+				}
+			}
+			filtered := make(map[string]string, len(tc.want))
+			for k := range tc.want {
+				filtered[k] = got[k]
+			}
+			if diff := cmp.Diff(tc.want, filtered); diff != "" {
+				t.Errorf("%s\nenviron(...): -want, +got:\n%s", tc.reason, diff)
+			}
+		})
+	}
+}
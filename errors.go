@@ -0,0 +1,157 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"regexp"
+	"syscall"
+
+	"github.com/crossplane/crossplane-runtime/v2/pkg/errors"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	"k8s.io/utils/ptr"
+	"oras.land/oras-go/v2/registry/remote/errcode"
+
+	fnv1 "github.com/crossplane/function-sdk-go/proto/v1"
+)
+
+// Not every failure is the composition author's fault. A registry that times
+// out or answers 503 will most likely answer on the next attempt, whereas a KCL
+// syntax error will fail the same way until somebody edits the composition.
+// Reporting both as a Fatal result hides that difference: Crossplane surfaces
+// the transient one as a broken composition and the permanent one carries no
+// hint of what to fix.
+//
+// fail classifies the error. Transient failures are returned as a retryable
+// gRPC status so Crossplane backs off and retries the reconcile. Permanent
+// failures become a Fatal result whose reason names the category.
+
+// failureReason identifies the category of a permanent failure. It is set as
+// the reason of the Fatal result, and therefore of the event on the XR.
+type failureReason string
+
+const (
+	// reasonInvalidInput is a KCLInput that fails validation or cannot be read.
+	reasonInvalidInput failureReason = "InvalidInput"
+	// reasonInvalidRequest is a RunFunctionRequest whose state cannot be read.
+	reasonInvalidRequest failureReason = "InvalidRequest"
+	// reasonSourceError is a KCL source that cannot be fetched or resolved.
+	reasonSourceError failureReason = "SourceError"
+	// reasonSyntaxError is KCL code that does not parse.
+	reasonSyntaxError failureReason = "SyntaxError"
+	// reasonTypeError is KCL code that fails type checking.
+	reasonTypeError failureReason = "TypeError"
+	// reasonCompileError is any other KCL compile time failure, e.g. an
+	// unresolved name or import.
+	reasonCompileError failureReason = "CompileError"
+	// reasonRuntimeError is a KCL program that fails while executing, e.g. a
+	// failed assertion or schema check.
+	reasonRuntimeError failureReason = "RuntimeError"
+	// reasonInvalidParams is a param that does not match its schema.
+	reasonInvalidParams failureReason = "InvalidParams"
+	// reasonInvalidOutput is KCL output that cannot be applied, e.g. an
+	// unknown meta kind or duplicate resource names.
+	reasonInvalidOutput failureReason = "InvalidOutput"
+	// reasonConflict is output that changes fields earlier steps of the
+	// pipeline set, with the Fail conflict policy.
+	reasonConflict failureReason = "Conflict"
+	// reasonInternal is a failure within the function itself.
+	reasonInternal failureReason = "InternalError"
+)
+
+// fail reports err on rsp. Transient errors are returned as codes.Unavailable
+// and rsp is dropped; anything else is added to rsp as a Fatal result with the
+// supplied reason.
+func fail(rsp *fnv1.RunFunctionResponse, reason failureReason, err error) (*fnv1.RunFunctionResponse, error) {
+	if isTransient(err) {
+		return nil, status.Error(codes.Unavailable, err.Error())
+	}
+	rsp.Results = append(rsp.GetResults(), &fnv1.Result{
+		Severity: fnv1.Severity_SEVERITY_FATAL,
+		Message:  err.Error(),
+		Reason:   ptr.To(string(reason)),
+		Target:   fnv1.Target_TARGET_COMPOSITE.Enum(),
+	})
+	return rsp, nil
+}
+
+// isTransient reports whether err is likely to succeed if retried. Only typed
+// network errors count: an error that merely reads like one, e.g. a KCL
+// program that fails with "internal server error", would otherwise be retried
+// forever. Of those, only timeouts and temporary DNS failures are retried,
+// since *url.Error is a net.Error too, and an unknown host or a certificate
+// that does not verify will fail the same way next time.
+func isTransient(err error) bool {
+	if err == nil {
+		return false
+	}
+	if s, ok := status.FromError(err); ok && s.Code() == codes.Unavailable {
+		return true
+	}
+	if errors.Is(err, context.DeadlineExceeded) ||
+		errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, syscall.ECONNREFUSED) ||
+		errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, syscall.EHOSTUNREACH) ||
+		errors.Is(err, syscall.ENETUNREACH) ||
+		errors.Is(err, syscall.ETIMEDOUT) {
+		return true
+	}
+	var derr *net.DNSError
+	if errors.As(err, &derr) {
+		return derr.IsTimeout || derr.IsTemporary
+	}
+	var nerr net.Error
+	if errors.As(err, &nerr) && nerr.Timeout() {
+		return true
+	}
+	var rerr *errcode.ErrorResponse
+	if errors.As(err, &rerr) {
+		return rerr.StatusCode >= http.StatusInternalServerError || rerr.StatusCode == http.StatusTooManyRequests
+	}
+	return false
+}
+
+// kclErrorCode matches the header KCL starts every diagnostic with, e.g.
+// error[E2G22]: TypeError. See diagnostics.go.
+var kclErrorCode = regexp.MustCompile(`error\[E(\d)(\w+)\]:[ \t]*(\w+)`)
+
+// failRender reports an error returned while rendering the KCL program. KCL
+// diagnostics are reported one Fatal result each; anything else means the
+// program never got as far as the compiler.
+func failRender(rsp *fnv1.RunFunctionResponse, err error) (*fnv1.RunFunctionResponse, error) {
+	if diags := parseDiagnostics(err.Error()); len(diags) > 0 {
+		setDiagnostics(rsp, diags)
+		return rsp, nil
+	}
+	return fail(rsp, reasonSourceError, errors.Wrap(err, "failed to run kcl function pipelines"))
+}
+
+// failParams reports params that do not match their schemas, one Fatal result
+// per violation, and a False KCLRendered condition summarising them.
+func failParams(rsp *fnv1.RunFunctionResponse, errs field.ErrorList) (*fnv1.RunFunctionResponse, error) {
+	for _, err := range errs {
+		rsp.Results = append(rsp.GetResults(), &fnv1.Result{
+			Severity: fnv1.Severity_SEVERITY_FATAL,
+			Message:  err.Error(),
+			Reason:   ptr.To(string(reasonInvalidParams)),
+			Target:   fnv1.Target_TARGET_COMPOSITE.Enum(),
+		})
+	}
+	msg := errs[0].Error()
+	if n := len(errs) - 1; n > 0 {
+		msg = fmt.Sprintf("%s (and %d more invalid param(s))", msg, n)
+	}
+	rsp.Conditions = append(rsp.GetConditions(), &fnv1.Condition{
+		Type:    conditionTypeRendered,
+		Status:  fnv1.Status_STATUS_CONDITION_FALSE,
+		Reason:  string(reasonInvalidParams),
+		Message: ptr.To(msg),
+		Target:  fnv1.Target_TARGET_COMPOSITE.Enum(),
+	})
+	return rsp, nil
+}
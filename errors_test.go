@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"syscall"
+	"testing"
+
+	"github.com/crossplane/crossplane-runtime/v2/pkg/errors"
+	"github.com/google/go-cmp/cmp"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/testing/protocmp"
+	"k8s.io/utils/ptr"
+	"oras.land/oras-go/v2/registry/remote/errcode"
+
+	fnv1 "github.com/crossplane/function-sdk-go/proto/v1"
+)
+
+const kclTypeError = `error[E2G22]: TypeError
+ --> prog.k:1:1
+  |
+1 | a: int = "1"
+  | ^ expected int, got str(1)
+  |
+`
+
+func TestIsTransient(t *testing.T) {
+	cases := map[string]struct {
+		err  error
+		want bool
+	}{
+		"Nil":              {err: nil, want: false},
+		"DeadlineExceeded": {err: errors.Wrap(context.DeadlineExceeded, "cannot pull"), want: true},
+		"ConnRefused":      {err: fmt.Errorf("dial: %w", syscall.ECONNREFUSED), want: true},
+		"Draining":         {err: status.Error(codes.Unavailable, "recycling"), want: true},
+		"Registry503":      {err: &errcode.ErrorResponse{StatusCode: http.StatusServiceUnavailable}, want: true},
+		"Registry404":      {err: &errcode.ErrorResponse{StatusCode: http.StatusNotFound}, want: false},
+		"NetError":         {err: errors.Wrap(&net.OpError{Op: "dial", Net: "tcp", Err: syscall.EHOSTUNREACH}, "cannot pull"), want: true},
+		"NetTimeout":       {err: &url.Error{Op: "Get", URL: "https://ghcr.io/v2/", Err: &net.OpError{Op: "read", Net: "tcp", Err: os.ErrDeadlineExceeded}}, want: true},
+		"DNSTemporary":     {err: &url.Error{Op: "Get", URL: "https://ghcr.io/v2/", Err: &net.DNSError{Err: "server misbehaving", Name: "ghcr.io", IsTemporary: true}}, want: true},
+		"DNSNotFound":      {err: &url.Error{Op: "Get", URL: "https://ghcr.example/v2/", Err: &net.DNSError{Err: "no such host", Name: "ghcr.example", IsNotFound: true}}, want: false},
+		"X509":             {err: &url.Error{Op: "Get", URL: "https://ghcr.io/v2/", Err: x509.UnknownAuthorityError{}}, want: false},
+		"MalformedURL":     {err: &url.Error{Op: "parse", URL: "https://ghcr.io:port", Err: errors.New("invalid port")}, want: false},
+		"FlattenedTimeout": {err: errors.New("failed to pull oci://ghcr.io/x: dial tcp 1.2.3.4:443: i/o timeout"), want: false},
+		"ProgramError":     {err: errors.New("assertion failed: internal server error"), want: false},
+		"KCLError":         {err: errors.New(kclTypeError), want: false},
+		"InvalidInput":     {err: errors.New("spec.source: Required value"), want: false},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			if got := isTransient(tc.err); got != tc.want {
+				t.Errorf("isTransient(%v) = %v, want %v", tc.err, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestFail(t *testing.T) {
+	t.Run("TransientIsUnavailable", func(t *testing.T) {
+		rsp, err := fail(&fnv1.RunFunctionResponse{}, reasonSourceError, syscall.ECONNRESET)
+		if rsp != nil {
+			t.Errorf("fail(...): want nil response, got %v", rsp)
+		}
+		if status.Code(err) != codes.Unavailable {
+			t.Errorf("fail(...): want %v, got %v", codes.Unavailable, err)
+		}
+	})
+	t.Run("PermanentIsFatal", func(t *testing.T) {
+		rsp, err := fail(&fnv1.RunFunctionResponse{}, reasonTypeError, errors.New(kclTypeError))
+		if err != nil {
+			t.Fatalf("fail(...): unexpected error %v", err)
+		}
+		want := &fnv1.RunFunctionResponse{Results: []*fnv1.Result{{
+			Severity: fnv1.Severity_SEVERITY_FATAL,
+			Message:  kclTypeError,
+			Reason:   ptr.To("TypeError"),
+			Target:   fnv1.Target_TARGET_COMPOSITE.Enum(),
+		}}}
+		if diff := cmp.Diff(want, rsp, protocmp.Transform()); diff != "" {
+			t.Errorf("fail(...): -want rsp, +got rsp:\n%s", diff)
+		}
+	})
+}
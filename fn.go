@@ -1,7 +1,6 @@
 package main
 
 import (
-	"bytes"
 	"context"
 	"fmt"
 	"os"
@@ -15,18 +14,18 @@ import (
 	"google.golang.org/grpc/status"
 	"google.golang.org/protobuf/types/known/structpb"
 	"k8s.io/apimachinery/pkg/runtime"
+	utiljson "k8s.io/apimachinery/pkg/util/json"
 	"kcl-lang.io/krm-kcl/pkg/api"
 	"kcl-lang.io/krm-kcl/pkg/api/v1alpha1"
-	"kcl-lang.io/krm-kcl/pkg/kio"
 	remoteauth "oras.land/oras-go/v2/registry/remote/auth"
 
 	fnv1 "github.com/crossplane/function-sdk-go/proto/v1"
 	"github.com/crossplane/function-sdk-go/request"
 	"github.com/crossplane/function-sdk-go/response"
 
-	fkcl "github.com/crossplane-contrib/function-kcl/input/v1alpha1"
+	fkclv1alpha1 "github.com/crossplane-contrib/function-kcl/input/v1alpha1"
+	fkcl "github.com/crossplane-contrib/function-kcl/input/v1beta1"
 	pkgresource "github.com/crossplane-contrib/function-kcl/pkg/resource"
-	"sigs.k8s.io/yaml"
 )
 
 var defaultSource = os.Getenv("FUNCTION_KCL_DEFAULT_SOURCE")
@@ -46,6 +45,8 @@ type Function struct {
 	dependencies string
 	recycler     *recycler
 	cache        *renderCache
+	schemas      *schemaCache
+	env          envPolicy
 }
 
 // RunFunction runs the Function.
@@ -62,13 +63,39 @@ func (f *Function) RunFunction(_ context.Context, req *fnv1.RunFunctionRequest)
 	log.Debug("Running Function")
 
 	rsp := response.To(req, response.DefaultTTL)
-	in := &fkcl.KCLInput{}
-	if err := request.GetInput(req, in); err != nil {
-		response.Fatal(rsp, errors.Wrapf(err, "cannot get Function input from %T", req))
-		return rsp, nil
+	in, err := getInput(req)
+	if err != nil {
+		return fail(rsp, reasonInvalidInput, errors.Wrapf(err, "cannot get Function input from %T", req))
+	}
+	// Load the source from a ConfigMap, requesting it from Crossplane first
+	sourceSelector, err := configMapSourceSelector(in)
+	if err != nil {
+		return fail(rsp, reasonInvalidInput, errors.Wrap(err, "invalid function input"))
+	}
+	if sourceSelector != nil {
+		data, ok, err := configMapSourceData(req)
+		if err != nil {
+			return fail(rsp, reasonSourceError, err)
+		}
+		if !ok {
+			log.Debug(fmt.Sprintf("Requesting source ConfigMap %s", sourceSelector.String()))
+			rsp.Requirements = &fnv1.Requirements{Resources: map[string]*fnv1.ResourceSelector{configMapSourceRequirement: sourceSelector}}
+			return rsp, nil
+		}
+		if err := in.ResolveConfigMap(data); err != nil {
+			return fail(rsp, reasonInvalidInput, errors.Wrap(err, "invalid function input"))
+		}
+	}
+	// Resolve the typed source reference
+	credentialsName := "kcl-registry"
+	if ref := in.Spec.SourceRef; ref != nil && ref.CredentialsName != "" {
+		credentialsName = ref.CredentialsName
+	}
+	if err := in.ResolveSourceRef(); err != nil {
+		return fail(rsp, reasonInvalidInput, errors.Wrap(err, "invalid function input"))
 	}
 	// Set default source
-	if in.Spec.Source == "" {
+	if in.Spec.Source == "" && len(in.Spec.Files) == 0 {
 		in.Spec.Source = defaultSource
 	}
 	if strings.HasPrefix(in.Spec.Source, "oci://") {
@@ -86,38 +113,31 @@ func (f *Function) RunFunction(_ context.Context, req *fnv1.RunFunctionRequest)
 	if f.dependencies != "" {
 		in.Spec.Dependencies = f.dependencies + "\n" + in.Spec.Dependencies
 	}
-	// Add credentials
-	if creds, ok := req.Credentials["kcl-registry"]; ok {
-		data := creds.GetCredentialData()
-		if data != nil {
-			if password, ok := data.Data["password"]; ok {
-				in.Spec.Credentials.Password = string(password)
-				if username, ok := data.Data["username"]; ok {
-					in.Spec.Credentials.Username = string(username)
-				}
-				if url, ok := data.Data["url"]; ok {
-					in.Spec.Credentials.Url = string(url)
-				}
-			} else {
-				log.Info("Warning: required password not found in the credentials")
-			}
+	// Take the registry credentials out of the input, so that they are never
+	// serialized with it. See credentials.go.
+	if data := req.GetCredentials()[credentialsName].GetCredentialData().GetData(); data != nil {
+		if _, ok := data["password"]; !ok {
+			log.Info("Warning: required password not found in the credentials")
 		}
 	}
+	creds, hasCreds := takeCredentials(req, in, credentialsName)
 	if err := in.Validate(); err != nil {
-		response.Fatal(rsp, errors.Wrap(err, "invalid function input"))
-		return rsp, nil
+		return fail(rsp, reasonInvalidInput, errors.Wrap(err, "invalid function input"))
 	}
-	// The composite resource that actually exists.
-	oxr, err := request.GetObservedCompositeResource(req)
+	if err := checkModLock(in.Spec.KclMod, in.Spec.KclModLock); err != nil {
+		return fail(rsp, reasonInvalidInput, errors.Wrap(err, "invalid function input"))
+	}
+	if err := checkModLockSource(in); err != nil {
+		return fail(rsp, reasonInvalidInput, errors.Wrap(err, "invalid function input"))
+	}
+	builtins, err := newBuiltinParams(in.Spec.BuiltinParams)
 	if err != nil {
-		response.Fatal(rsp, errors.Wrap(err, "cannot get observed composite resource"))
-		return rsp, nil
+		return fail(rsp, reasonInvalidInput, errors.Wrap(err, "invalid function input"))
 	}
-	// Set option("params").oxr
-	in.Spec.Params["oxr"], err = pkgresource.UnstructuredToRawExtension(&oxr.Resource.Unstructured)
+	// The composite resource that actually exists.
+	oxr, err := request.GetObservedCompositeResource(req)
 	if err != nil {
-		response.Fatal(rsp, err)
-		return rsp, nil
+		return fail(rsp, reasonInvalidRequest, errors.Wrap(err, "cannot get observed composite resource"))
 	}
 	log = log.WithValues(
 		"xr-version", oxr.Resource.GetAPIVersion(),
@@ -129,77 +149,48 @@ func (f *Function) RunFunction(_ context.Context, req *fnv1.RunFunctionRequest)
 	// The composite resource desired by previous functions in the pipeline.
 	dxr, err := request.GetDesiredCompositeResource(req)
 	if err != nil {
-		response.Fatal(rsp, errors.Wrap(err, "cannot get desired composite resource"))
-		return rsp, nil
+		return fail(rsp, reasonInvalidRequest, errors.Wrap(err, "cannot get desired composite resource"))
 	}
-	// Set option("params").dxr
 	dxr.Resource.SetAPIVersion(oxr.Resource.GetAPIVersion())
 	dxr.Resource.SetKind(oxr.Resource.GetKind())
-	in.Spec.Params["dxr"], err = pkgresource.UnstructuredToRawExtension(&dxr.Resource.Unstructured)
-	if err != nil {
-		response.Fatal(rsp, err)
-		return rsp, nil
-	}
 	// The composed resources desired by any previous Functions in the pipeline.
 	desired, err := request.GetDesiredComposedResources(req)
 	if err != nil {
-		response.Fatal(rsp, errors.Wrapf(err, "cannot get desired composed resources from %T", req))
-		return rsp, nil
+		return fail(rsp, reasonInvalidRequest, errors.Wrapf(err, "cannot get desired composed resources from %T", req))
 	}
 	log.Debug(fmt.Sprintf("DesiredComposed resources: %d", len(desired)))
-	in.Spec.Params["dcds"], err = pkgresource.ObjToRawExtension(desired)
-	if err != nil {
-		response.Fatal(rsp, err)
-		return rsp, nil
-	}
-
 	// The composed resources desired by any previous Functions in the pipeline.
 	observed, err := request.GetObservedComposedResources(req)
 	if err != nil {
-		response.Fatal(rsp, errors.Wrapf(err, "cannot get observed composed resources from %T", req))
-		return rsp, nil
+		return fail(rsp, reasonInvalidRequest, errors.Wrapf(err, "cannot get observed composed resources from %T", req))
 	}
 	log.Debug(fmt.Sprintf("ObservedComposed resources: %d", len(observed)))
-	in.Spec.Params["ocds"], err = pkgresource.ObjToRawExtension(observed)
-	if err != nil {
-		response.Fatal(rsp, err)
-		return rsp, nil
-	}
-	// Set function context
-	ctxByte, err := req.Context.MarshalJSON()
+	log.Debug(fmt.Sprintf("Extra resources: %d", len(req.GetExtraResources())))
+	log.Debug(fmt.Sprintf("Required resources: %d", len(req.GetRequiredResources())))
+	// Populate params, overrides and arguments from field paths
+	if err := resolveParamsFrom(in, paramSources{
+		oxr: oxr.Resource.Object,
+		dxr: dxr.Resource.Object,
+		ctx: req.GetContext().AsMap(),
+	}); err != nil {
+		return fail(rsp, reasonInvalidParams, err)
+	}
+	// Encode option("params") once, straight from the request. See
+	// builtinparams.go.
+	buf := paramsPool.Get().(*[]byte)
+	defer paramsPool.Put(buf)
+	params, err := builtins.encode((*buf)[:0], req, in)
 	if err != nil {
-		response.Fatal(rsp, err)
-		return rsp, nil
+		return fail(rsp, reasonInvalidRequest, err)
 	}
-	ctxObj, err := pkgresource.JsonByteToRawExtension(ctxByte)
+	*buf = params
+	// Validate params against their schemas before running the KCL code
+	violations, err := validateParams(f.schemas, in)
 	if err != nil {
-		response.Fatal(rsp, err)
-		return rsp, nil
+		return fail(rsp, reasonInvalidInput, errors.Wrap(err, "cannot validate params"))
 	}
-	in.Spec.Params["ctx"] = ctxObj
-	// The extra resources by myself or any previous Functions in the pipeline.
-	extras, err := request.GetExtraResources(req)
-	if err != nil {
-		response.Fatal(rsp, errors.Wrapf(err, "cannot get extra resources from %T", req))
-		return rsp, nil
-	}
-	log.Debug(fmt.Sprintf("Extra resources: %d", len(extras)))
-	in.Spec.Params["extraResources"], err = pkgresource.ObjToRawExtension(extras)
-	if err != nil {
-		response.Fatal(rsp, err)
-		return rsp, nil
-	}
-	// The required resources by myself or any previous Functions in the pipeline.
-	required, err := request.GetRequiredResources(req)
-	if err != nil {
-		response.Fatal(rsp, errors.Wrapf(err, "cannot get required resources from %T", req))
-		return rsp, nil
-	}
-	log.Debug(fmt.Sprintf("Required resources: %d", len(required)))
-	in.Spec.Params["requiredResources"], err = pkgresource.ObjToRawExtension(required)
-	if err != nil {
-		response.Fatal(rsp, err)
-		return rsp, nil
+	if len(violations) > 0 {
+		return failParams(rsp, violations)
 	}
 	// Convert the function-kcl KCLInput to the KRM-KCL spec and run function pipelines.
 	// Input Example: https://github.com/kcl-lang/krm-kcl/blob/main/examples/mutation/set-annotations/suite/good.yaml
@@ -210,11 +201,11 @@ func (f *Function) RunFunction(_ context.Context, req *fnv1.RunFunctionRequest)
 	// for byte-identical inputs to skip recompiling the module — this avoids both
 	// the CPU cost and a native memory-leak increment on no-op re-syncs. See
 	// rendercache.go.
+	env := f.env.environ(in)
 	var key []byte
 	if f.cache.enabled() {
-		if key, err = renderKey(in); err != nil {
-			response.Fatal(rsp, errors.Wrap(err, "cannot derive render cache key"))
-			return rsp, nil
+		if key, err = renderKey(in, params, env); err != nil {
+			return fail(rsp, reasonInternal, errors.Wrap(err, "cannot derive render cache key"))
 		}
 	}
 
@@ -224,38 +215,34 @@ func (f *Function) RunFunction(_ context.Context, req *fnv1.RunFunctionRequest)
 		hits, misses := f.cache.stats()
 		log.Debug("render cache hit", "hits", hits, "misses", misses)
 	} else {
+		// Log in for this request only, see credentials.go. Both render paths
+		// fetch with the login and remove it before the KCL program runs.
+		var auth *registryAuth
+		if host := creds.host(in.Spec.Source); hasCreds && host != "" {
+			if auth, err = newRegistryAuth(host, creds); err != nil {
+				return fail(rsp, reasonSourceError, err)
+			}
+			defer auth.Close()
+		}
 		// Fast path: feed the KCL runtime the JSON we already hold, skipping the
-		// JSON -> YAML -> RNode -> JSON round trip. Falls back to the krm-kcl
-		// pipeline for non-inline sources (oci://, git, http, local path).
-		out, ok, err := renderInline(in)
+		// JSON -> YAML -> RNode -> JSON round trip. Other sources (oci://, git,
+		// http, local path) are fetched first and then run the same way.
+		out, ok, err := renderInline(in, params, env, auth)
 		if err != nil {
-			response.Fatal(rsp, errors.Wrap(err, "failed to run kcl function pipelines"))
-			return rsp, nil
+			return failRender(rsp, err)
 		}
 		if !ok {
-			// Note use "sigs.k8s.io/yaml" here.
-			kclRunBytes, err := yaml.Marshal(in)
-			if err != nil {
-				response.Fatal(rsp, errors.Wrap(err, "cannot marshal input to yaml"))
-				return rsp, nil
+			if out, err = renderRemote(in, params, env, auth); err != nil {
+				return failRender(rsp, err)
 			}
-			inputBytes, outputBytes := bytes.NewBuffer(kclRunBytes), bytes.NewBuffer([]byte{})
-			// Run pipeline to get the result mutated or validated by the KCL source.
-			pipeline := kio.NewPipeline(inputBytes, outputBytes, false)
-			if err := pipeline.Execute(); err != nil {
-				response.Fatal(rsp, errors.Wrap(err, "failed to run kcl function pipelines"))
-				return rsp, nil
-			}
-			out = outputBytes.Bytes()
 		}
 		outputData = out
 		f.cache.store(key, outputData)
 	}
 	log.Debug(fmt.Sprintf("Pipeline output: %v", string(outputData)))
-	data, err := pkgresource.DataResourcesFromYaml(outputData)
+	data, err := pkgresource.DataResourcesFromJSON(outputData)
 	if err != nil {
-		response.Fatal(rsp, errors.Wrapf(err, "cannot parse data resources from the pipeline output in %T", rsp))
-		return rsp, nil
+		return fail(rsp, reasonInvalidOutput, errors.Wrapf(err, "cannot parse data resources from the pipeline output in %T", rsp))
 	}
 	log.Debug(fmt.Sprintf("Pipeline data: %v", data))
 
@@ -263,8 +250,7 @@ func (f *Function) RunFunction(_ context.Context, req *fnv1.RunFunctionRequest)
 	for _, r := range in.Spec.Resources {
 		base, err := pkgresource.JsonByteToUnstructured(r.Base.Raw)
 		if err != nil {
-			response.Fatal(rsp, errors.Wrapf(err, "cannot parse data resources from the pipeline output in %T", rsp))
-			return rsp, nil
+			return fail(rsp, reasonInvalidInput, errors.Wrapf(err, "cannot parse data resources from the pipeline output in %T", rsp))
 		}
 		resources = append(resources, pkgresource.Resource{
 			Name: r.Name,
@@ -272,19 +258,80 @@ func (f *Function) RunFunction(_ context.Context, req *fnv1.RunFunctionRequest)
 		})
 	}
 	log.Debug(fmt.Sprintf("Input resources: %v", resources))
+	var defaults []pkgresource.ResourceDefaults
+	for _, d := range in.Spec.Defaults {
+		values := make(map[string]interface{})
+		if err := utiljson.Unmarshal(d.Values.Raw, &values); err != nil {
+			return fail(rsp, reasonInvalidInput, errors.Wrap(err, "cannot parse resource defaults values"))
+		}
+		defaults = append(defaults, pkgresource.ResourceDefaults{
+			APIVersion:  d.APIVersion,
+			Kind:        d.Kind,
+			Name:        d.Name,
+			MatchLabels: d.MatchLabels,
+			Values:      values,
+		})
+	}
+	var propagation *pkgresource.PropagationPolicy
+	if p := in.Spec.Propagation; p != nil {
+		propagation = &pkgresource.PropagationPolicy{
+			Labels:       p.Labels,
+			Annotations:  p.Annotations,
+			KeepExisting: p.KeepExisting,
+		}
+	}
+	var kubernetesObjects *pkgresource.KubernetesObjects
+	if k := in.Spec.KubernetesObjects; k != nil {
+		kubernetesObjects = &pkgresource.KubernetesObjects{
+			APIVersion:         k.APIVersion,
+			ManagementPolicies: k.ManagementPolicies,
+			ReadinessPolicy:    k.ReadinessPolicy,
+		}
+		for _, s := range k.Selectors {
+			kubernetesObjects.Selectors = append(kubernetesObjects.Selectors, pkgresource.ObjectSelector{
+				APIVersion:  s.APIVersion,
+				Kind:        s.Kind,
+				Name:        s.Name,
+				MatchLabels: s.MatchLabels,
+			})
+		}
+		if k.ProviderConfigRef != nil {
+			kubernetesObjects.ProviderConfigName = k.ProviderConfigRef.Name
+		}
+	}
 	extraResources := map[string]*fnv1.ResourceSelector{}
 	requiredResources := map[string]*fnv1.ResourceSelector{}
+	if sourceSelector != nil {
+		requiredResources[configMapSourceRequirement] = sourceSelector
+	}
 	var conditions pkgresource.ConditionResources
 	var events pkgresource.EventResources
 	contextData := make(map[string]interface{})
 	result, err := pkgresource.ProcessResources(dxr, oxr, desired, observed, extraResources, requiredResources, &conditions, &events, &contextData, in.Spec.Target, resources, &pkgresource.AddResourcesOptions{
-		Basename:  in.Name,
-		Data:      data,
-		Overwrite: true,
+		Basename:          in.Name,
+		Data:              data,
+		Overwrite:         true,
+		MergeKeys:         in.Spec.ListMergeKeys,
+		Naming:            in.Spec.NamingStrategy,
+		Defaults:          defaults,
+		Propagation:       propagation,
+		KubernetesObjects: kubernetesObjects,
 	})
 	if err != nil {
-		response.Fatal(rsp, errors.Wrapf(err, "cannot process xr and state with the pipeline output in %T", rsp))
-		return rsp, nil
+		return fail(rsp, reasonInvalidOutput, errors.Wrapf(err, "cannot process xr and state with the pipeline output in %T", rsp))
+	}
+	// Report or undo changes to fields that earlier steps set. See conflicts.go.
+	conflicts, err := checkConflicts(req, in, dxr, desired)
+	if err != nil {
+		return fail(rsp, reasonInvalidRequest, errors.Wrap(err, "cannot check for conflicts"))
+	}
+	if len(conflicts) > 0 {
+		if in.Spec.ConflictPolicy == pkgresource.ConflictFail {
+			return fail(rsp, reasonConflict, errors.Errorf("output changes fields set by earlier steps: %s", strings.Join(conflicts, "; ")))
+		}
+		for _, c := range conflicts {
+			response.Warning(rsp, errors.Errorf("kept field set by an earlier step: %s", c)).TargetComposite().WithReason(string(reasonConflict))
+		}
 	}
 	if len(extraResources) > 0 || len(requiredResources) > 0 {
 		for n, d := range extraResources {
@@ -313,14 +360,12 @@ func (f *Function) RunFunction(_ context.Context, req *fnv1.RunFunctionRequest)
 	if len(contextData) > 0 {
 		mergedCtx, err := pkgresource.MergeContext(req, contextData)
 		if err != nil {
-			response.Fatal(rsp, errors.Wrapf(err, "cannot merge Context"))
-			return rsp, nil
+			return fail(rsp, reasonInvalidOutput, errors.Wrapf(err, "cannot merge Context"))
 		}
 		for key, v := range mergedCtx {
 			vv, err := structpb.NewValue(v)
 			if err != nil {
-				response.Fatal(rsp, errors.Wrap(err, "cannot convert value to structpb.Value"))
-				return rsp, nil
+				return fail(rsp, reasonInvalidOutput, errors.Wrap(err, "cannot convert value to structpb.Value"))
 			}
 			f.log.Debug("Updating Composition environment", "key", key, "data", v)
 			response.SetContextKey(rsp, key, vv)
@@ -332,15 +377,13 @@ func (f *Function) RunFunction(_ context.Context, req *fnv1.RunFunctionRequest)
 	// Set dxr and desired state
 	log.Debug(fmt.Sprintf("Setting desired XR state to %+v", dxr.Resource))
 	if err := response.SetDesiredCompositeResource(rsp, dxr); err != nil {
-		response.Fatal(rsp, errors.Wrapf(err, "cannot set desired composite resource in %T", rsp))
-		return rsp, nil
+		return fail(rsp, reasonInternal, errors.Wrapf(err, "cannot set desired composite resource in %T", rsp))
 	}
 	for n, d := range desired {
 		log.Debug(fmt.Sprintf("Setting DesiredComposed state to %+v named %s", d.Resource, n))
 	}
 	if err := response.SetDesiredComposedResources(rsp, desired); err != nil {
-		response.Fatal(rsp, errors.Wrapf(err, "cannot set desired composed resources in %T", rsp))
-		return rsp, nil
+		return fail(rsp, reasonInternal, errors.Wrapf(err, "cannot set desired composed resources in %T", rsp))
 	}
 	log.Debug("Successfully processed crossplane KCL function resources", "input", in.Name)
 	return rsp, nil
@@ -363,3 +406,16 @@ func resetOCITokenCacheIfNeeded(log logging.Logger) {
 	}
 }
 
+// getInput returns the function input of req as the v1beta1 hub version,
+// converting v1alpha1 input.
+func getInput(req *fnv1.RunFunctionRequest) (*fkcl.KCLInput, error) {
+	in := &fkcl.KCLInput{}
+	if req.GetInput().GetFields()["apiVersion"].GetStringValue() == fkcl.GroupVersion {
+		return in, request.GetInput(req, in)
+	}
+	old := &fkclv1alpha1.KCLInput{}
+	if err := request.GetInput(req, old); err != nil {
+		return nil, err
+	}
+	return in, old.ConvertTo(in)
+}
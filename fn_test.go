@@ -476,6 +476,7 @@ func TestRunFunctionSimple(t *testing.T) {
 							Severity: fnv1.Severity_SEVERITY_FATAL,
 							Target:   fnv1.Target_TARGET_COMPOSITE.Enum(),
 							Message:  "cannot process xr and state with the pipeline output in *v1.RunFunctionResponse: duplicate extra resource key \"cool-extra-resource\"",
+							Reason:   ptr.To("InvalidOutput"),
 						},
 					},
 					Desired: &fnv1.State{
@@ -663,6 +664,256 @@ func TestRunFunctionSimple(t *testing.T) {
 				},
 			},
 		},
+		"V1beta1UnknownTarget": {
+			reason: "The Function should return a fatal result for a v1beta1 input with an unknown target rather than defaulting it.",
+			args: args{
+				req: &fnv1.RunFunctionRequest{
+					Meta: &fnv1.RequestMeta{Tag: "v1beta1-unknown-target"},
+					Input: resource.MustStructJSON(`{
+						"apiVersion": "krm.kcl.dev/v1beta1",
+						"kind": "KCLInput",
+						"metadata": {"name": "basic"},
+						"spec": {
+							"target": "Everything",
+							"source": "items = []"
+						}
+					}`),
+					Observed: &fnv1.State{
+						Composite: &fnv1.Resource{Resource: resource.MustStructJSON(xr)},
+					},
+				},
+			},
+			want: want{
+				rsp: &fnv1.RunFunctionResponse{
+					Meta: &fnv1.ResponseMeta{Tag: "v1beta1-unknown-target", Ttl: durationpb.New(response.DefaultTTL)},
+					Results: []*fnv1.Result{
+						{
+							Severity: fnv1.Severity_SEVERITY_FATAL,
+							Message:  `invalid function input: spec.target: Unsupported value: "Everything": supported values: "Default", "PatchDesired", "PatchResources", "Resources", "XR"`,
+							Reason:   ptr.To("InvalidInput"),
+							Target:   fnv1.Target_TARGET_COMPOSITE.Enum(),
+						},
+					},
+				},
+			},
+		},
+		"ParamsSchemaViolation": {
+			reason: "The Function should report params that do not match their schema before running the KCL code.",
+			args: args{
+				req: &fnv1.RunFunctionRequest{
+					Meta: &fnv1.RequestMeta{Tag: "params-schema-violation"},
+					Input: resource.MustStructJSON(`{
+						"apiVersion": "krm.kcl.dev/v1beta1",
+						"kind": "KCLInput",
+						"metadata": {"name": "basic"},
+						"spec": {
+							"target": "Resources",
+							"source": "items = [{apiVersion = \"example.org/v1\", kind = \"Generated\"}]",
+							"schemas": {
+								"oxr": {"openAPIV3Schema": {"type": "object", "properties": {"spec": {"type": "object", "required": ["size"]}}}}
+							}
+						}
+					}`),
+					Observed: &fnv1.State{
+						Composite: &fnv1.Resource{Resource: resource.MustStructJSON(xr)},
+					},
+				},
+			},
+			want: want{
+				rsp: &fnv1.RunFunctionResponse{
+					Meta: &fnv1.ResponseMeta{Tag: "params-schema-violation", Ttl: durationpb.New(response.DefaultTTL)},
+					Results: []*fnv1.Result{
+						{
+							Severity: fnv1.Severity_SEVERITY_FATAL,
+							Message:  "oxr.spec.size: Required value",
+							Reason:   ptr.To("InvalidParams"),
+							Target:   fnv1.Target_TARGET_COMPOSITE.Enum(),
+						},
+					},
+					Conditions: []*fnv1.Condition{
+						{
+							Type:    "KCLRendered",
+							Status:  fnv1.Status_STATUS_CONDITION_FALSE,
+							Reason:  "InvalidParams",
+							Message: ptr.To("oxr.spec.size: Required value"),
+							Target:  fnv1.Target_TARGET_COMPOSITE.Enum(),
+						},
+					},
+				},
+			},
+		},
+		"ParamsFromArgument": {
+			reason: "The Function should pass a paramsFrom argument to an inline source as a top-level argument.",
+			args: args{
+				req: &fnv1.RunFunctionRequest{
+					Meta: &fnv1.RequestMeta{Tag: "params-from-argument"},
+					Input: resource.MustStructJSON(`{
+						"apiVersion": "krm.kcl.dev/v1beta1",
+						"kind": "KCLInput",
+						"metadata": {"name": "basic"},
+						"spec": {
+							"target": "Resources",
+							"source": "items = [{apiVersion = \"example.org/v1\", kind = \"Generated\", metadata.name = \"generated\", spec.count = option(\"count\")}]",
+							"paramsFrom": [{"from": "ObservedComposite", "fieldPath": "spec.count", "to": "Argument", "name": "count"}]
+						}
+					}`),
+					Observed: &fnv1.State{
+						Composite: &fnv1.Resource{Resource: resource.MustStructJSON(xr)},
+					},
+				},
+			},
+			want: want{
+				rsp: &fnv1.RunFunctionResponse{
+					Meta: &fnv1.ResponseMeta{Tag: "params-from-argument", Ttl: durationpb.New(response.DefaultTTL)},
+					Desired: &fnv1.State{
+						Composite: &fnv1.Resource{
+							Resource: resource.MustStructJSON(`{"apiVersion":"example.org/v1","kind":"XR"}`),
+						},
+						Resources: map[string]*fnv1.Resource{
+							"generated": {
+								Resource: resource.MustStructJSON(`{"apiVersion": "example.org/v1", "kind": "Generated", "metadata": {"name": "generated"}, "spec": {"count": 2}}`),
+							},
+						},
+					},
+				},
+			},
+		},
+		"ConfigMapSourceIsRequested": {
+			reason: "The Function should request a ConfigMap source and not render until it is supplied.",
+			args: args{
+				req: &fnv1.RunFunctionRequest{
+					Meta: &fnv1.RequestMeta{Tag: "configmap-source-requested"},
+					Input: resource.MustStructJSON(`{
+						"apiVersion": "krm.kcl.dev/v1alpha1",
+						"kind": "KCLInput",
+						"metadata": {"name": "basic"},
+						"spec": {
+							"target": "Default",
+							"sourceRef": {"kind": "ConfigMap", "configMap": {"name": "cool-source", "namespace": "cool-ns"}}
+						}
+					}`),
+					Observed: &fnv1.State{
+						Composite: &fnv1.Resource{Resource: resource.MustStructJSON(xr)},
+					},
+					Desired: &fnv1.State{
+						Composite: &fnv1.Resource{Resource: resource.MustStructJSON(xr)},
+					},
+				},
+			},
+			want: want{
+				rsp: &fnv1.RunFunctionResponse{
+					Meta: &fnv1.ResponseMeta{Tag: "configmap-source-requested", Ttl: durationpb.New(response.DefaultTTL)},
+					Requirements: &fnv1.Requirements{
+						Resources: map[string]*fnv1.ResourceSelector{
+							configMapSourceRequirement: {
+								ApiVersion: "v1",
+								Kind:       "ConfigMap",
+								Namespace:  ptr.To[string]("cool-ns"),
+								Match:      &fnv1.ResourceSelector_MatchName{MatchName: "cool-source"},
+							},
+						},
+					},
+					Desired: &fnv1.State{Composite: &fnv1.Resource{Resource: resource.MustStructJSON(xr)}},
+				},
+			},
+		},
+		"ConfigMapSourceIsRendered": {
+			reason: "The Function should render the module in a supplied ConfigMap source and keep requesting it.",
+			args: args{
+				req: &fnv1.RunFunctionRequest{
+					Meta: &fnv1.RequestMeta{Tag: "configmap-source-rendered"},
+					Input: resource.MustStructJSON(`{
+						"apiVersion": "krm.kcl.dev/v1alpha1",
+						"kind": "KCLInput",
+						"metadata": {"name": "basic"},
+						"spec": {
+							"target": "Default",
+							"sourceRef": {"kind": "ConfigMap", "configMap": {"name": "cool-source", "namespace": "cool-ns"}}
+						}
+					}`),
+					Observed: &fnv1.State{
+						Composite: &fnv1.Resource{Resource: resource.MustStructJSON(xr)},
+					},
+					Desired: &fnv1.State{
+						Composite: &fnv1.Resource{Resource: resource.MustStructJSON(xr)},
+					},
+					RequiredResources: map[string]*fnv1.Resources{
+						configMapSourceRequirement: {
+							Items: []*fnv1.Resource{{Resource: resource.MustStructJSON(`{
+								"apiVersion": "v1",
+								"kind": "ConfigMap",
+								"metadata": {"name": "cool-source", "namespace": "cool-ns"},
+								"data": {
+									"main.k": "import cd\n\nitems = [cd.cd]\n",
+									"cd.k": "cd = {apiVersion = \"example.org/v1\", kind = \"CD\", metadata = {name = \"cool-cd\", annotations = {\"krm.kcl.dev/composition-resource-name\" = \"cool-cd\"}}}\n"
+								}
+							}`)}},
+						},
+					},
+				},
+			},
+			want: want{
+				rsp: &fnv1.RunFunctionResponse{
+					Meta:    &fnv1.ResponseMeta{Tag: "configmap-source-rendered", Ttl: durationpb.New(response.DefaultTTL)},
+					Results: []*fnv1.Result{},
+					Requirements: &fnv1.Requirements{
+						ExtraResources: map[string]*fnv1.ResourceSelector{},
+						Resources: map[string]*fnv1.ResourceSelector{
+							configMapSourceRequirement: {
+								ApiVersion: "v1",
+								Kind:       "ConfigMap",
+								Namespace:  ptr.To[string]("cool-ns"),
+								Match:      &fnv1.ResourceSelector_MatchName{MatchName: "cool-source"},
+							},
+						},
+					},
+					Desired: &fnv1.State{
+						Composite: &fnv1.Resource{Resource: resource.MustStructJSON(xr)},
+						Resources: map[string]*fnv1.Resource{
+							"cool-cd": {
+								Resource: resource.MustStructJSON(`{"apiVersion":"example.org/v1","kind":"CD","metadata":{"annotations":{},"name":"cool-cd"}}`),
+							},
+						},
+					},
+				},
+			},
+		},
+		"ConfigMapSourceNotFound": {
+			reason: "The Function should return a fatal result if the ConfigMap source does not exist.",
+			args: args{
+				req: &fnv1.RunFunctionRequest{
+					Meta: &fnv1.RequestMeta{Tag: "configmap-source-not-found"},
+					Input: resource.MustStructJSON(`{
+						"apiVersion": "krm.kcl.dev/v1alpha1",
+						"kind": "KCLInput",
+						"metadata": {"name": "basic"},
+						"spec": {
+							"target": "Default",
+							"sourceRef": {"kind": "ConfigMap", "configMap": {"name": "cool-source", "namespace": "cool-ns"}}
+						}
+					}`),
+					Observed: &fnv1.State{
+						Composite: &fnv1.Resource{Resource: resource.MustStructJSON(xr)},
+					},
+					RequiredResources: map[string]*fnv1.Resources{
+						configMapSourceRequirement: {},
+					},
+				},
+			},
+			want: want{
+				rsp: &fnv1.RunFunctionResponse{
+					Meta: &fnv1.ResponseMeta{Tag: "configmap-source-not-found", Ttl: durationpb.New(response.DefaultTTL)},
+					Results: []*fnv1.Result{
+						{
+							Severity: fnv1.Severity_SEVERITY_FATAL,
+							Message:  "source ConfigMap not found",
+							Reason:   ptr.To("SourceError"),
+							Target:   fnv1.Target_TARGET_COMPOSITE.Enum(),
+						},
+					},
+				},
+			},
+		},
 		"DuplicateRequiredResourceKey": {
 			reason: "The Function should return a fatal result if the required resource key is duplicated.",
 			args: args{
@@ -704,6 +955,7 @@ func TestRunFunctionSimple(t *testing.T) {
 							Severity: fnv1.Severity_SEVERITY_FATAL,
 							Target:   fnv1.Target_TARGET_COMPOSITE.Enum(),
 							Message:  "cannot process xr and state with the pipeline output in *v1.RunFunctionResponse: duplicate required resource key \"cool-required-resource\"",
+							Reason:   ptr.To("InvalidOutput"),
 						},
 					},
 					Desired: &fnv1.State{
@@ -769,6 +1021,7 @@ func TestRunFunctionSimple(t *testing.T) {
 						Target:   fnv1.Target_TARGET_COMPOSITE.Enum(),
 						Severity: fnv1.Severity_SEVERITY_FATAL,
 						Message:  "invalid function input: spec.source: Required value: kcl source cannot be empty",
+						Reason:   ptr.To("InvalidInput"),
 					}},
 				},
 			},
@@ -1012,7 +1265,8 @@ func TestRunFunctionSimple(t *testing.T) {
 						{
 							Severity: fnv1.Severity_SEVERITY_FATAL,
 							Target:   fnv1.Target_TARGET_COMPOSITE.Enum(),
-							Message:  "cannot process xr and state with the pipeline output in *v1.RunFunctionResponse: multiple composed resources with name \"duplicate-resource-name\" returned: Generated/metadata-name and Generated/duplicate-resource-name. Set different metadata.name or metadata.annotations.\"krm.kcl.dev/composition-resource-name\" to distinguish them.",
+							Message:  "cannot process xr and state with the pipeline output in *v1.RunFunctionResponse: multiple composed resources with name \"duplicate-resource-name\" returned: Generated \"metadata-name\" (example.org/v1) and Generated \"duplicate-resource-name\" (example.org/v1). Set different metadata.name or metadata.annotations.\"krm.kcl.dev/composition-resource-name\" to distinguish them.",
+							Reason:   ptr.To("InvalidOutput"),
 						},
 					}},
 			},
@@ -1086,7 +1340,8 @@ func TestRunFunctionSimple(t *testing.T) {
 						{
 							Severity: fnv1.Severity_SEVERITY_FATAL,
 							Target:   fnv1.Target_TARGET_COMPOSITE.Enum(),
-							Message:  "cannot process xr and state with the pipeline output in *v1.RunFunctionResponse: multiple composed resources with name \"duplicate-resource-name\" returned: Generated/metadata-name and Generated/duplicate-resource-name. Set different metadata.name or metadata.annotations.\"krm.kcl.dev/composition-resource-name\" to distinguish them.",
+							Message:  "cannot process xr and state with the pipeline output in *v1.RunFunctionResponse: multiple composed resources with name \"duplicate-resource-name\" returned: Generated \"metadata-name\" (example.org/v1) and Generated \"duplicate-resource-name\" (example.org/v1). Set different metadata.name or metadata.annotations.\"krm.kcl.dev/composition-resource-name\" to distinguish them.",
+							Reason:   ptr.To("InvalidOutput"),
 						},
 					}},
 			},
@@ -0,0 +1,81 @@
+package v1alpha1
+
+import (
+	"slices"
+
+	"k8s.io/apimachinery/pkg/runtime"
+
+	"github.com/crossplane-contrib/function-kcl/input/v1beta1"
+	"github.com/crossplane-contrib/function-kcl/pkg/resource"
+)
+
+// ConvertTo converts the input to the v1beta1 hub version. Anything v1alpha1
+// tolerates but v1beta1 rejects is normalised the way v1alpha1 treats it: an
+// unknown target becomes Default, and params named like a built-in param are
+// dropped, since the built-in param replaced them. Resources are kept whatever
+// the target, since objects can be routed to PatchResources with the
+// krm.kcl.dev/target annotation.
+func (in *KCLInput) ConvertTo(dst *v1beta1.KCLInput) error {
+	dst.ObjectMeta = *in.ObjectMeta.DeepCopy()
+	dst.APIVersion = v1beta1.GroupVersion
+	dst.Kind = in.Kind
+
+	src := in.Spec.DeepCopy()
+	dst.Spec = v1beta1.RunSpec{
+		Source:       src.Source,
+		SourceRef:    src.SourceRef,
+		Files:        src.Files,
+		Entry:        src.Entry,
+		Config:       convertConfig(src.Config),
+		Credentials:  v1beta1.CredSpec{Url: src.Credentials.Url},
+		Dependencies: src.Dependencies,
+		KclMod:       src.KclMod,
+		KclModLock:   src.KclModLock,
+		Params:       convertParams(src.Params),
+		Target:       src.Target,
+	}
+
+	for _, r := range src.Resources {
+		dst.Spec.Resources = append(dst.Spec.Resources, v1beta1.Resource(r))
+	}
+
+	switch src.Target {
+	case "", resource.Default, resource.PatchDesired, resource.PatchResources, resource.Resources, resource.XR:
+	default:
+		dst.Spec.Target = resource.Default
+	}
+	return nil
+}
+
+// convertParams returns params without those named like a default built-in
+// param, which v1alpha1 lets the built-in param replace and v1beta1 rejects.
+// meta is only set when v1beta1 includes it, so a param of that name is kept.
+func convertParams(params map[string]runtime.RawExtension) map[string]runtime.RawExtension {
+	var out map[string]runtime.RawExtension
+	for name, v := range params {
+		if slices.Contains(v1beta1.BuiltinParamNames, name) && !slices.Contains(v1beta1.OptInBuiltinParams, name) {
+			continue
+		}
+		if out == nil {
+			out = make(map[string]runtime.RawExtension, len(params))
+		}
+		out[name] = v
+	}
+	return out
+}
+
+func convertConfig(in ConfigSpec) v1beta1.ConfigSpec {
+	return v1beta1.ConfigSpec{
+		Arguments:        in.Arguments,
+		Settings:         in.Settings,
+		InlineSettings:   in.InlineSettings,
+		Overrides:        in.Overrides,
+		PathSelectors:    in.PathSelectors,
+		Vendor:           in.Vendor,
+		SortKeys:         in.SortKeys,
+		ShowHidden:       in.ShowHidden,
+		DisableNone:      in.DisableNone,
+		Debug:            in.Debug,
+		StrictRangeCheck: in.StrictRangeCheck,
+	}
+}
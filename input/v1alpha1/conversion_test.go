@@ -0,0 +1,143 @@
+package v1alpha1
+
+import (
+	"encoding/json"
+	"testing"
+
+	fnv1 "github.com/crossplane/function-sdk-go/proto/v1"
+	res "github.com/crossplane/function-sdk-go/resource"
+	"github.com/google/go-cmp/cmp"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+
+	"github.com/crossplane-contrib/function-kcl/input/v1beta1"
+	"github.com/crossplane-contrib/function-kcl/pkg/resource"
+)
+
+func TestConvertTo(t *testing.T) {
+	base := &runtime.RawExtension{Raw: []byte(`{"apiVersion":"example.org/v1","kind":"CD"}`)}
+	cases := map[string]struct {
+		reason string
+		in     RunSpec
+		want   v1beta1.RunSpec
+	}{
+		"Fields": {
+			reason: "Every field should be carried over to v1beta1, but for the registry username and password, which it does not hold.",
+			in: RunSpec{
+				SourceRef:    &SourceRef{Kind: SourceKindOCI, OCI: &OCISource{Repo: "ghcr.io/x", Tag: "1"}, CredentialsName: "ghcr"},
+				Config:       ConfigSpec{Arguments: []string{"a=1"}, Vendor: true},
+				Credentials:  CredSpec{Url: "ghcr.io", Username: "u", Password: "p"},
+				Dependencies: `k8s = "1.28"`,
+				KclMod:       "[package]",
+				Params:       map[string]runtime.RawExtension{"a": {Raw: []byte(`1`)}},
+				Target:       resource.XR,
+			},
+			want: v1beta1.RunSpec{
+				SourceRef:    &v1beta1.SourceRef{Kind: v1beta1.SourceKindOCI, OCI: &v1beta1.OCISource{Repo: "ghcr.io/x", Tag: "1"}, CredentialsName: "ghcr"},
+				Config:       v1beta1.ConfigSpec{Arguments: []string{"a=1"}, Vendor: true},
+				Credentials:  v1beta1.CredSpec{Url: "ghcr.io"},
+				Dependencies: `k8s = "1.28"`,
+				KclMod:       "[package]",
+				Params:       map[string]runtime.RawExtension{"a": {Raw: []byte(`1`)}},
+				Target:       resource.XR,
+			},
+		},
+		"BuiltinParamNames": {
+			reason: "Params named like a default built-in param should be dropped, as the built-in param replaced them, but meta should be kept.",
+			in:     RunSpec{Source: "a = 1", Params: map[string]runtime.RawExtension{"oxr": {Raw: []byte(`{}`)}, "meta": {Raw: []byte(`1`)}}},
+			want:   v1beta1.RunSpec{Source: "a = 1", Params: map[string]runtime.RawExtension{"meta": {Raw: []byte(`1`)}}},
+		},
+		"UnknownTarget": {
+			reason: "An unknown target should become Default, as v1alpha1 treats it.",
+			in:     RunSpec{Source: "a = 1", Target: "Everything"},
+			want:   v1beta1.RunSpec{Source: "a = 1", Target: resource.Default},
+		},
+		"ResourcesWithOtherTarget": {
+			reason: "Resources should be kept whatever the target, since objects can be routed to PatchResources by annotation.",
+			in:     RunSpec{Source: "a = 1", Target: resource.Resources, Resources: ResourceList{{Name: "a", Base: base}}},
+			want:   v1beta1.RunSpec{Source: "a = 1", Target: resource.Resources, Resources: v1beta1.ResourceList{{Name: "a", Base: base}}},
+		},
+		"PatchResources": {
+			reason: "Resources should be kept for the PatchResources target.",
+			in:     RunSpec{Source: "a = 1", Target: resource.PatchResources, Resources: ResourceList{{Name: "a", Base: base}}},
+			want:   v1beta1.RunSpec{Source: "a = 1", Target: resource.PatchResources, Resources: v1beta1.ResourceList{{Name: "a", Base: base}}},
+		},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			in := &KCLInput{
+				TypeMeta:   metav1.TypeMeta{APIVersion: "krm.kcl.dev/v1alpha1", Kind: "KCLInput"},
+				ObjectMeta: metav1.ObjectMeta{Name: "basic"},
+				Spec:       tc.in,
+			}
+			got := &v1beta1.KCLInput{}
+			if err := in.ConvertTo(got); err != nil {
+				t.Fatalf("%s\nConvertTo(...): unexpected error %v", tc.reason, err)
+			}
+			want := &v1beta1.KCLInput{
+				TypeMeta:   metav1.TypeMeta{APIVersion: v1beta1.GroupVersion, Kind: "KCLInput"},
+				ObjectMeta: metav1.ObjectMeta{Name: "basic"},
+				Spec:       tc.want,
+			}
+			if diff := cmp.Diff(want, got); diff != "" {
+				t.Errorf("%s\nConvertTo(...): -want, +got:\n%s", tc.reason, diff)
+			}
+		})
+	}
+}
+
+func TestConvertToRoutedPatchResources(t *testing.T) {
+	in := &KCLInput{
+		TypeMeta:   metav1.TypeMeta{APIVersion: "krm.kcl.dev/v1alpha1", Kind: "KCLInput"},
+		ObjectMeta: metav1.ObjectMeta{Name: "basic"},
+		Spec: RunSpec{
+			Source:    "a = 1",
+			Target:    resource.Default,
+			Resources: ResourceList{{Name: "bucket", Base: &runtime.RawExtension{Raw: []byte(`{"apiVersion":"s3.aws.upbound.io/v1beta1","kind":"Bucket","metadata":{"name":"bucket"}}`)}}},
+		},
+	}
+	converted := &v1beta1.KCLInput{}
+	if err := in.ConvertTo(converted); err != nil {
+		t.Fatalf("ConvertTo(...): unexpected error %v", err)
+	}
+	if err := converted.Validate(); err != nil {
+		t.Fatalf("Validate(): unexpected error %v", err)
+	}
+
+	var resources resource.ResourceList
+	for _, r := range converted.Spec.Resources {
+		base := unstructured.Unstructured{}
+		if err := json.Unmarshal(r.Base.Raw, &base.Object); err != nil {
+			t.Fatalf("json.Unmarshal(...): %v", err)
+		}
+		resources = append(resources, resource.Resource{Name: r.Name, Base: base})
+	}
+	data := []unstructured.Unstructured{{Object: map[string]interface{}{
+		"apiVersion": "s3.aws.upbound.io/v1beta1",
+		"kind":       "Bucket",
+		"metadata": map[string]interface{}{
+			"name":        "bucket",
+			"annotations": map[string]interface{}{resource.AnnotationKeyTarget: string(resource.PatchResources)},
+		},
+		"spec": map[string]interface{}{"region": "eu-west-1"},
+	}}}
+	desired := map[res.Name]*res.DesiredComposed{}
+	if _, err := resource.ProcessResources(nil, nil, desired, nil, map[string]*fnv1.ResourceSelector{}, map[string]*fnv1.ResourceSelector{}, &resource.ConditionResources{}, &resource.EventResources{}, &map[string]interface{}{}, converted.Spec.Target, resources, &resource.AddResourcesOptions{Data: data, Overwrite: true}); err != nil {
+		t.Fatalf("ProcessResources(...): the converted resources should take the routed patch: %v", err)
+	}
+
+	want := map[string]interface{}{
+		"apiVersion": "s3.aws.upbound.io/v1beta1",
+		"kind":       "Bucket",
+		"metadata":   map[string]interface{}{"name": "bucket"},
+		"spec":       map[string]interface{}{"region": "eu-west-1"},
+	}
+	cd, ok := desired["bucket"]
+	if !ok {
+		t.Fatal("ProcessResources(...): want desired resource bucket")
+	}
+	if diff := cmp.Diff(want, cd.Resource.Object); diff != "" {
+		t.Errorf("ProcessResources(...): -want, +got:\n%s", diff)
+	}
+}
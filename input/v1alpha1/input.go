@@ -6,6 +6,9 @@ package v1alpha1
 
 import (
 	"fmt"
+	"path"
+	"sort"
+	"strings"
 
 	"github.com/crossplane-contrib/function-kcl/pkg/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
@@ -18,7 +21,6 @@ import (
 
 // KCLInput can be used to provide input to this Function.
 // +kubebuilder:object:root=true
-// +kubebuilder:storageversion
 // +kubebuilder:resource:categories=crossplane
 type KCLInput struct {
 	metav1.TypeMeta   `json:",inline"`
@@ -27,8 +29,19 @@ type KCLInput struct {
 	Spec RunSpec `json:"spec,omitempty" yaml:"spec,omitempty"`
 }
 
+// DefaultEntry is the file in spec.files that is run when spec.entry is not set.
+const DefaultEntry = "main.k"
+
 func (in *KCLInput) Validate() error {
-	if in.Spec.Source == "" {
+	if in.Spec.SourceRef != nil {
+		if err := in.validateSourceRef(); err != nil {
+			return err
+		}
+	} else if len(in.Spec.Files) > 0 {
+		if err := in.validateFiles(); err != nil {
+			return err
+		}
+	} else if in.Spec.Source == "" {
 		return field.Required(field.NewPath("spec.source"), "kcl source cannot be empty")
 	}
 
@@ -55,10 +68,93 @@ func (in *KCLInput) Validate() error {
 	return nil
 }
 
+func (in *KCLInput) validateFiles() error {
+	if in.Spec.Source != "" {
+		return field.Invalid(field.NewPath("spec.files"), "<files>", "spec.source and spec.files are mutually exclusive")
+	}
+	names := make([]string, 0, len(in.Spec.Files))
+	for name := range in.Spec.Files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if path.IsAbs(name) || path.Clean(name) != name || name == ".." || strings.HasPrefix(name, "../") {
+			return field.Invalid(field.NewPath("spec.files").Key(name), name, "file name must be a clean path relative to the module root")
+		}
+	}
+	if _, ok := in.Spec.Files["kcl.mod"]; ok && in.Spec.KclMod != "" {
+		return field.Invalid(field.NewPath("spec.kclMod"), "<kcl.mod>", "spec.kclMod and a kcl.mod in spec.files are mutually exclusive")
+	}
+	if _, ok := in.Spec.Files[in.EntryFile()]; !ok {
+		return field.NotFound(field.NewPath("spec.entry"), in.EntryFile())
+	}
+	return nil
+}
+
+func (in *KCLInput) validateSourceRef() error {
+	if in.Spec.Source != "" {
+		return field.Invalid(field.NewPath("spec.sourceRef"), "<sourceRef>", "spec.source and spec.sourceRef are mutually exclusive")
+	}
+	if len(in.Spec.Files) > 0 {
+		return field.Invalid(field.NewPath("spec.sourceRef"), "<sourceRef>", "spec.files and spec.sourceRef are mutually exclusive")
+	}
+	return in.Spec.SourceRef.Validate(field.NewPath("spec.sourceRef"))
+}
+
+// ResolveSourceRef replaces spec.sourceRef, if set, with the equivalent
+// spec.source. A ConfigMap sourceRef must be replaced by the files it holds
+// with ResolveConfigMap instead.
+func (in *KCLInput) ResolveSourceRef() error {
+	if in.Spec.SourceRef == nil {
+		return nil
+	}
+	if err := in.validateSourceRef(); err != nil {
+		return err
+	}
+	if in.Spec.SourceRef.Kind == SourceKindConfigMap {
+		return field.Invalid(field.NewPath("spec.sourceRef.kind"), in.Spec.SourceRef.Kind, "must be resolved from the required ConfigMap")
+	}
+	in.Spec.Source, in.Spec.SourceRef = in.Spec.SourceRef.String(), nil
+	return nil
+}
+
+// ResolveConfigMap replaces a ConfigMap spec.sourceRef with spec.files, taken
+// from the data of the ConfigMap.
+func (in *KCLInput) ResolveConfigMap(data map[string]string) error {
+	if err := in.validateSourceRef(); err != nil {
+		return err
+	}
+	if len(data) == 0 {
+		return field.Invalid(field.NewPath("spec.sourceRef.configMap"), in.Spec.SourceRef.ConfigMap.Name, "ConfigMap has no data")
+	}
+	in.Spec.Files, in.Spec.SourceRef = data, nil
+	return in.validateFiles()
+}
+
+// EntryFile returns the file in spec.files to run.
+func (in *KCLInput) EntryFile() string {
+	if in.Spec.Entry == "" {
+		return DefaultEntry
+	}
+	return in.Spec.Entry
+}
+
 // RunSpec defines the desired state of Crossplane KCL function.
 type RunSpec struct {
 	// Source is a required field for providing a KCL script inline.
+	// It may be omitted when Files or SourceRef is set.
+	// +optional
 	Source string `json:"source" yaml:"source"`
+	// SourceRef is a typed alternative to Source. It is mutually exclusive
+	// with Source and Files.
+	// +optional
+	SourceRef *SourceRef `json:"sourceRef,omitempty" yaml:"sourceRef,omitempty"`
+	// Files is a KCL module provided inline, as a map of file paths relative to
+	// the module root to their content, e.g. main.k, helpers.k and
+	// schemas/app.k. It is used instead of Source.
+	Files map[string]string `json:"files,omitempty" yaml:"files,omitempty"`
+	// Entry is the file in Files to run. Defaults to main.k.
+	Entry string `json:"entry,omitempty" yaml:"entry,omitempty"`
 	// Config is the compile config.
 	Config ConfigSpec `json:"config,omitempty" yaml:"config,omitempty"`
 	// Credentials for remote locations
@@ -66,6 +162,13 @@ type RunSpec struct {
 	// Dependencies are the external dependencies for the KCL code.
 	// The format of the `dependencies` field is same as the `[dependencies]` in the `kcl.mod` file
 	Dependencies string `json:"dependencies,omitempty" yaml:"dependencies,omitempty"`
+	// KclMod is the content of the kcl.mod for the KCL code. Its dependencies
+	// are added to Dependencies. Use it to pin the full module manifest rather
+	// than just its `[dependencies]` section.
+	KclMod string `json:"kclMod,omitempty" yaml:"kclMod,omitempty"`
+	// KclModLock is the content of the kcl.mod.lock that pins the dependencies
+	// of KclMod. It must agree with KclMod.
+	KclModLock string `json:"kclModLock,omitempty" yaml:"kclModLock,omitempty"`
 	// Params are the parameters in key-value pairs format.
 	Params map[string]runtime.RawExtension `json:"params,omitempty" yaml:"params,omitempty"`
 	// Resources is a list of resources to patch and create
@@ -83,6 +186,9 @@ type ConfigSpec struct {
 	Arguments []string `json:"arguments,omitempty" yaml:"arguments,omitempty"`
 	// Settings is the list of kcl setting files including all of the CLI config.
 	Settings []string `json:"settings,omitempty" yaml:"settings,omitempty"`
+	// InlineSettings is the list of kcl setting file contents, in the same
+	// kcl.yaml format as the files listed in Settings.
+	InlineSettings []string `json:"inlineSettings,omitempty" yaml:"inlineSettings,omitempty"`
 	// Overrides is the list of override paths and values, e.g., app.image="v2"
 	Overrides []string `json:"overrides,omitempty" yaml:"overrides,omitempty"`
 	// PathSelectors is the list of path selectors to select output result, e.g., a.b.c
@@ -0,0 +1,39 @@
+package v1alpha1
+
+import "github.com/crossplane-contrib/function-kcl/input/v1beta1"
+
+// The source types are shared with v1beta1, so both versions validate and
+// resolve a SourceRef the same way.
+type (
+	// SourceKind is the kind of location a SourceRef points to.
+	SourceKind = v1beta1.SourceKind
+	// SourceRef is a typed reference to the KCL source, as an alternative to
+	// the prefix-sniffed Source string.
+	SourceRef = v1beta1.SourceRef
+	// OCISource locates a KCL module in an OCI registry.
+	OCISource = v1beta1.OCISource
+	// GitSource locates a KCL module in a Git repository.
+	GitSource = v1beta1.GitSource
+	// HTTPSource locates a KCL file or archive served over HTTP(S).
+	HTTPSource = v1beta1.HTTPSource
+	// LocalSource locates a KCL file or module on the function's filesystem.
+	LocalSource = v1beta1.LocalSource
+	// ConfigMapSource locates a KCL module held in the data of a ConfigMap.
+	ConfigMapSource = v1beta1.ConfigMapSource
+)
+
+const (
+	// SourceKindInline is KCL code held in the SourceRef itself.
+	SourceKindInline = v1beta1.SourceKindInline
+	// SourceKindOCI is a KCL module published to an OCI registry.
+	SourceKindOCI = v1beta1.SourceKindOCI
+	// SourceKindGit is a KCL module in a Git repository.
+	SourceKindGit = v1beta1.SourceKindGit
+	// SourceKindHTTP is a KCL file or archive served over HTTP(S).
+	SourceKindHTTP = v1beta1.SourceKindHTTP
+	// SourceKindLocal is a KCL file or module on the function's filesystem.
+	SourceKindLocal = v1beta1.SourceKindLocal
+	// SourceKindConfigMap is a KCL module held in the data of a ConfigMap,
+	// which the function requests as a required resource.
+	SourceKindConfigMap = v1beta1.SourceKindConfigMap
+)
@@ -0,0 +1,88 @@
+package v1alpha1
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+)
+
+func TestResolveSourceRef(t *testing.T) {
+	type want struct {
+		source string
+		err    bool
+	}
+	cases := map[string]struct {
+		reason string
+		spec   RunSpec
+		want   want
+	}{
+		"None": {
+			reason: "A spec without a sourceRef should keep its source.",
+			spec:   RunSpec{Source: "oci://ghcr.io/kcl-lang/app"},
+			want:   want{source: "oci://ghcr.io/kcl-lang/app"},
+		},
+		"Inline": {
+			reason: "An inline sourceRef should resolve to its code.",
+			spec:   RunSpec{SourceRef: &SourceRef{Kind: SourceKindInline, Inline: "a = 1"}},
+			want:   want{source: "a = 1"},
+		},
+		"OCITag": {
+			reason: "An OCI sourceRef should resolve to an oci:// URL with its tag.",
+			spec:   RunSpec{SourceRef: &SourceRef{Kind: SourceKindOCI, OCI: &OCISource{Repo: "ghcr.io/kcl-lang/app", Tag: "0.2.0"}}},
+			want:   want{source: "oci://ghcr.io/kcl-lang/app?tag=0.2.0"},
+		},
+		"OCIDigest": {
+			reason: "An OCI sourceRef should resolve a digest as part of the reference.",
+			spec:   RunSpec{SourceRef: &SourceRef{Kind: SourceKindOCI, OCI: &OCISource{Repo: "oci://ghcr.io/kcl-lang/app", Digest: "sha256:abc"}}},
+			want:   want{source: "oci://ghcr.io/kcl-lang/app@sha256:abc"},
+		},
+		"Git": {
+			reason: "A Git sourceRef should resolve to a go-getter address.",
+			spec:   RunSpec{SourceRef: &SourceRef{Kind: SourceKindGit, Git: &GitSource{URL: "https://github.com/kcl-lang/modules.git", Path: "/app/", Ref: "v1"}}},
+			want:   want{source: "git::https://github.com/kcl-lang/modules.git//app?ref=v1"},
+		},
+		"HTTP": {
+			reason: "An HTTP sourceRef should resolve to its URL with the checksum appended.",
+			spec:   RunSpec{SourceRef: &SourceRef{Kind: SourceKindHTTP, HTTP: &HTTPSource{URL: "https://example.com/main.k?x=1", Checksum: "sha256:abc"}}},
+			want:   want{source: "https://example.com/main.k?x=1&checksum=sha256%3Aabc"},
+		},
+		"Local": {
+			reason: "A Local sourceRef should resolve to its path.",
+			spec:   RunSpec{SourceRef: &SourceRef{Kind: SourceKindLocal, Local: &LocalSource{Path: "/kcl/main.k"}}},
+			want:   want{source: "/kcl/main.k"},
+		},
+		"SourceAndSourceRef": {
+			reason: "Source and sourceRef should be mutually exclusive.",
+			spec:   RunSpec{Source: "a = 1", SourceRef: &SourceRef{Kind: SourceKindInline, Inline: "a = 1"}},
+			want:   want{source: "a = 1", err: true},
+		},
+		"MissingLocation": {
+			reason: "A sourceRef should hold the location of its kind.",
+			spec:   RunSpec{SourceRef: &SourceRef{Kind: SourceKindOCI}},
+			want:   want{err: true},
+		},
+		"WrongLocation": {
+			reason: "A sourceRef should not hold the location of another kind.",
+			spec:   RunSpec{SourceRef: &SourceRef{Kind: SourceKindLocal, Local: &LocalSource{Path: "main.k"}, Inline: "a = 1"}},
+			want:   want{err: true},
+		},
+		"TagAndDigest": {
+			reason: "An OCI sourceRef should not set both a tag and a digest.",
+			spec:   RunSpec{SourceRef: &SourceRef{Kind: SourceKindOCI, OCI: &OCISource{Repo: "ghcr.io/x", Tag: "1", Digest: "sha256:abc"}}},
+			want:   want{err: true},
+		},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			in := &KCLInput{Spec: tc.spec}
+			err := in.ResolveSourceRef()
+			if diff := cmp.Diff(tc.want.err, err != nil, cmpopts.EquateEmpty()); diff != "" {
+				t.Errorf("%s\nResolveSourceRef(): -want error, +got error:\n%s\n%v", tc.reason, diff, err)
+			}
+			if diff := cmp.Diff(tc.want.source, in.Spec.Source); diff != "" {
+				t.Errorf("%s\nResolveSourceRef(): -want source, +got source:\n%s", tc.reason, diff)
+			}
+		})
+	}
+}
@@ -21,6 +21,11 @@ func (in *ConfigSpec) DeepCopyInto(out *ConfigSpec) {
 		*out = make([]string, len(*in))
 		copy(*out, *in)
 	}
+	if in.InlineSettings != nil {
+		in, out := &in.InlineSettings, &out.InlineSettings
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
 	if in.Overrides != nil {
 		in, out := &in.Overrides, &out.Overrides
 		*out = make([]string, len(*in))
@@ -128,6 +133,18 @@ func (in ResourceList) DeepCopy() ResourceList {
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *RunSpec) DeepCopyInto(out *RunSpec) {
 	*out = *in
+	if in.SourceRef != nil {
+		in, out := &in.SourceRef, &out.SourceRef
+		*out = new(SourceRef)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Files != nil {
+		in, out := &in.Files, &out.Files
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
 	in.Config.DeepCopyInto(&out.Config)
 	out.Credentials = in.Credentials
 	if in.Params != nil {
@@ -0,0 +1,867 @@
+// Package v1beta1 contains the input type for this Function
+// +kubebuilder:object:generate=true
+// +groupName=krm.kcl.dev
+// +versionName=v1beta1
+package v1beta1
+
+import (
+	"encoding/json"
+	"fmt"
+	"path"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/crossplane-contrib/function-kcl/pkg/resource"
+	"github.com/crossplane/crossplane-runtime/v2/pkg/fieldpath"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	runtime "k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+)
+
+// This isn't a custom resource, in the sense that we never install its CRD.
+// It is a KRM-like object, so we generate a CRD to describe its schema.
+
+// KCLInput can be used to provide input to this Function.
+// +kubebuilder:object:root=true
+// +kubebuilder:storageversion
+// +kubebuilder:resource:categories=crossplane
+type KCLInput struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec RunSpec `json:"spec,omitempty" yaml:"spec,omitempty"`
+}
+
+// GroupVersion is the apiVersion of this KCLInput version.
+const GroupVersion = "krm.kcl.dev/v1beta1"
+
+// Hub marks v1beta1 as the version other KCLInput versions convert to.
+func (*KCLInput) Hub() {}
+
+// DefaultEntry is the file in spec.files that is run when spec.entry is not set.
+const DefaultEntry = "main.k"
+
+// StepName returns the name of the pipeline step the input belongs to: Step,
+// or else the name of the input.
+func (in *KCLInput) StepName() string {
+	if in.Spec.Step != "" {
+		return in.Spec.Step
+	}
+	return in.GetName()
+}
+
+// Validate returns an error if the input is invalid. Unlike v1alpha1, an
+// unknown target and fields that do not apply to the input are errors rather
+// than being ignored.
+func (in *KCLInput) Validate() error {
+	switch {
+	case in.Spec.SourceRef != nil:
+		if err := in.validateSourceRef(); err != nil {
+			return err
+		}
+	case len(in.Spec.Files) > 0:
+		if err := in.validateFiles(); err != nil {
+			return err
+		}
+	case in.Spec.Source == "":
+		return field.Required(field.NewPath("spec.source"), "kcl source cannot be empty")
+	}
+	if in.Spec.Entry != "" && len(in.Spec.Files) == 0 && (in.Spec.SourceRef == nil || in.Spec.SourceRef.Kind != SourceKindConfigMap) {
+		return field.Invalid(field.NewPath("spec.entry"), in.Spec.Entry, "spec.entry requires spec.files or a ConfigMap spec.sourceRef")
+	}
+	if in.Spec.KclModLock != "" && in.Spec.KclMod == "" {
+		return field.Invalid(field.NewPath("spec.kclModLock"), "<kcl.mod.lock>", "spec.kclModLock requires spec.kclMod")
+	}
+
+	if err := in.validateBuiltinParams(); err != nil {
+		return err
+	}
+	if err := in.validateParamsFrom(); err != nil {
+		return err
+	}
+	if err := in.validateSchemas(); err != nil {
+		return err
+	}
+	if err := in.validateTypedArguments(); err != nil {
+		return err
+	}
+	if err := in.validateListMergeKeys(); err != nil {
+		return err
+	}
+	switch in.Spec.NamingStrategy {
+	case "", resource.NameStrategy, resource.KindNameStrategy, resource.GroupKindNamespaceNameStrategy, resource.HashStrategy:
+	default:
+		return field.NotSupported(field.NewPath("spec.namingStrategy"), in.Spec.NamingStrategy, []string{string(resource.NameStrategy), string(resource.KindNameStrategy), string(resource.GroupKindNamespaceNameStrategy), string(resource.HashStrategy)})
+	}
+	if err := in.validateDefaults(); err != nil {
+		return err
+	}
+	if err := in.validatePropagation(); err != nil {
+		return err
+	}
+	if err := in.validateKubernetesObjects(); err != nil {
+		return err
+	}
+	switch in.Spec.ConflictPolicy {
+	case "", resource.ConflictOverwrite, resource.ConflictFail, resource.ConflictSkip:
+	default:
+		return field.NotSupported(field.NewPath("spec.conflictPolicy"), in.Spec.ConflictPolicy, []string{string(resource.ConflictOverwrite), string(resource.ConflictFail), string(resource.ConflictSkip)})
+	}
+	if in.Spec.FieldOwners && in.StepName() == "" {
+		return field.Required(field.NewPath("spec.step"), "fieldOwners requires the name of the step in spec.step or metadata.name")
+	}
+	if in.Spec.FieldOwnersDepth < 0 {
+		return field.Invalid(field.NewPath("spec.fieldOwnersDepth"), in.Spec.FieldOwnersDepth, "must not be negative")
+	}
+
+	switch in.Spec.Target {
+	case resource.Default, resource.PatchDesired, resource.Resources, resource.XR:
+	case resource.PatchResources:
+		if len(in.Spec.Resources) == 0 {
+			return field.Required(field.NewPath("spec.resources"), fmt.Sprintf("%s target requires at least one resource", resource.PatchResources))
+		}
+	default:
+		return field.NotSupported(field.NewPath("spec.target"), in.Spec.Target, []string{string(resource.Default), string(resource.PatchDesired), string(resource.PatchResources), string(resource.Resources), string(resource.XR)})
+	}
+	// Resources are also patched by objects routed to the PatchResources
+	// target with the krm.kcl.dev/target annotation, whatever the target.
+	for i, r := range in.Spec.Resources {
+		if r.Name == "" {
+			return field.Required(field.NewPath("spec.resources").Index(i).Child("name"), "name cannot be empty")
+		}
+		if r.Base == nil {
+			return field.Required(field.NewPath("spec.resources").Index(i).Child("base"), "base cannot be empty")
+		}
+	}
+
+	return nil
+}
+
+func (in *KCLInput) validateFiles() error {
+	if in.Spec.Source != "" {
+		return field.Invalid(field.NewPath("spec.files"), "<files>", "spec.source and spec.files are mutually exclusive")
+	}
+	names := make([]string, 0, len(in.Spec.Files))
+	for name := range in.Spec.Files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if path.IsAbs(name) || path.Clean(name) != name || name == ".." || strings.HasPrefix(name, "../") {
+			return field.Invalid(field.NewPath("spec.files").Key(name), name, "file name must be a clean path relative to the module root")
+		}
+	}
+	if _, ok := in.Spec.Files["kcl.mod"]; ok && in.Spec.KclMod != "" {
+		return field.Invalid(field.NewPath("spec.kclMod"), "<kcl.mod>", "spec.kclMod and a kcl.mod in spec.files are mutually exclusive")
+	}
+	if _, ok := in.Spec.Files[in.EntryFile()]; !ok {
+		return field.NotFound(field.NewPath("spec.entry"), in.EntryFile())
+	}
+	return nil
+}
+
+func isBuiltinParam(name string) bool {
+	for _, n := range BuiltinParamNames {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
+
+// includableBuiltinParams are the valid entries of builtinParams.include.
+func includableBuiltinParams() []string {
+	var names []string
+	for _, n := range BuiltinParamNames {
+		if !slices.Contains(OptInBuiltinParams, n) {
+			names = append(names, n)
+		}
+	}
+	return append(names, OptInBuiltinParams...)
+}
+
+func (in *KCLInput) validateBuiltinParams() error {
+	// Built-in params take precedence over params of the same name, so such a
+	// param would be silently replaced. A built-in param that is not included
+	// leaves the name free.
+	b := in.Spec.BuiltinParams
+	names := make([]string, 0, len(in.Spec.Params))
+	for name := range in.Spec.Params {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if isBuiltinParam(name) && b.Includes(name) {
+			return field.Invalid(field.NewPath("spec.params").Key(name), "<param>", "param is set by the function")
+		}
+	}
+	if b == nil {
+		return nil
+	}
+	includable := includableBuiltinParams()
+	for i, name := range b.Include {
+		if !slices.Contains(includable, name) {
+			return field.NotSupported(field.NewPath("spec.builtinParams.include").Index(i), name, includable)
+		}
+	}
+	for i, p := range b.Prune {
+		segs, err := fieldpath.Parse(p)
+		if err != nil {
+			return field.Invalid(field.NewPath("spec.builtinParams.prune").Index(i), p, err.Error())
+		}
+		if len(segs) < 2 || segs[0].Type != fieldpath.SegmentField || !isBuiltinParam(segs[0].Field) {
+			return field.Invalid(field.NewPath("spec.builtinParams.prune").Index(i), p, "path must start with a built-in param and name a field within it")
+		}
+	}
+	return nil
+}
+
+func (in *KCLInput) validateParamsFrom() error {
+	for i, p := range in.Spec.ParamsFrom {
+		path := field.NewPath("spec.paramsFrom").Index(i)
+		switch p.From {
+		case FromObservedComposite, FromDesiredComposite, FromContext:
+		default:
+			return field.NotSupported(path.Child("from"), p.From, []string{string(FromObservedComposite), string(FromDesiredComposite), string(FromContext)})
+		}
+		switch p.To {
+		case ToParam, ToOverride, ToArgument:
+		default:
+			return field.NotSupported(path.Child("to"), p.To, []string{string(ToParam), string(ToOverride), string(ToArgument)})
+		}
+		if p.FieldPath == "" {
+			return field.Required(path.Child("fieldPath"), "field path cannot be empty")
+		}
+		if p.Name == "" {
+			return field.Required(path.Child("name"), "name cannot be empty")
+		}
+		if p.To == ToParam && isBuiltinParam(p.Name) {
+			return field.Invalid(path.Child("name"), p.Name, "param is set by the function")
+		}
+	}
+	return nil
+}
+
+func (in *KCLInput) validateSchemas() error {
+	names := make([]string, 0, len(in.Spec.Schemas))
+	for name := range in.Spec.Schemas {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		s, p := in.Spec.Schemas[name], field.NewPath("spec.schemas").Key(name)
+		switch {
+		case (s.OpenAPIV3Schema == nil) == (s.KCL == nil):
+			return field.Invalid(p, "<schema>", "exactly one of openAPIV3Schema and kcl must be set")
+		case s.KCL != nil && s.KCL.Name == "":
+			return field.Required(p.Child("kcl", "name"), "schema name cannot be empty")
+		case s.KCL != nil && s.KCL.Source == "":
+			return field.Required(p.Child("kcl", "source"), "schema source cannot be empty")
+		}
+	}
+	return nil
+}
+
+func (in *KCLInput) validateListMergeKeys() error {
+	paths := make([]string, 0, len(in.Spec.ListMergeKeys))
+	for p := range in.Spec.ListMergeKeys {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+	for _, p := range paths {
+		fp := field.NewPath("spec.listMergeKeys").Key(p)
+		segs, err := fieldpath.Parse(p)
+		if err != nil {
+			return field.Invalid(fp, p, err.Error())
+		}
+		for _, s := range segs {
+			if s.Type != fieldpath.SegmentField {
+				return field.Invalid(fp, p, "path must name fields only, without list indexes")
+			}
+		}
+		if in.Spec.ListMergeKeys[p] == "" {
+			return field.Required(fp, "merge key cannot be empty")
+		}
+	}
+	return nil
+}
+
+func (in *KCLInput) validateDefaults() error {
+	for i, d := range in.Spec.Defaults {
+		p := field.NewPath("spec.defaults").Index(i)
+		for _, f := range [][2]string{{"apiVersion", d.APIVersion}, {"kind", d.Kind}, {"name", d.Name}} {
+			if _, err := path.Match(f[1], ""); err != nil {
+				return field.Invalid(p.Child(f[0]), f[1], err.Error())
+			}
+		}
+		values := make(map[string]interface{})
+		if err := json.Unmarshal(d.Values.Raw, &values); err != nil {
+			return field.Invalid(p.Child("values"), string(d.Values.Raw), "values must be an object")
+		}
+		if len(values) == 0 {
+			return field.Required(p.Child("values"), "values cannot be empty")
+		}
+	}
+	return nil
+}
+
+func (in *KCLInput) validatePropagation() error {
+	if in.Spec.Propagation == nil {
+		return nil
+	}
+	p := field.NewPath("spec.propagation")
+	for _, f := range []struct {
+		name string
+		keys []string
+	}{{"labels", in.Spec.Propagation.Labels}, {"annotations", in.Spec.Propagation.Annotations}} {
+		for i, k := range f.keys {
+			if k == "" {
+				return field.Required(p.Child(f.name).Index(i), "key cannot be empty")
+			}
+			if strings.Contains(strings.TrimSuffix(k, "*"), "*") {
+				return field.Invalid(p.Child(f.name).Index(i), k, "* is only allowed at the end of a key")
+			}
+		}
+	}
+	return nil
+}
+
+// KubernetesObjectManagementPolicies are the management policies of
+// provider-kubernetes Objects.
+var KubernetesObjectManagementPolicies = []string{"*", "Observe", "Create", "Update", "Delete", "LateInitialize"}
+
+func (in *KCLInput) validateKubernetesObjects() error {
+	k := in.Spec.KubernetesObjects
+	if k == nil {
+		return nil
+	}
+	p := field.NewPath("spec.kubernetesObjects")
+	if k.APIVersion != "" {
+		if group, version, ok := strings.Cut(k.APIVersion, "/"); !ok || group == "" || version == "" || strings.Contains(version, "/") {
+			return field.Invalid(p.Child("apiVersion"), k.APIVersion, "must be a group and version, e.g. kubernetes.crossplane.io/v1alpha2")
+		}
+	}
+	for i, s := range k.Selectors {
+		sp := p.Child("selectors").Index(i)
+		if s.APIVersion == "" && s.Kind == "" && s.Name == "" && len(s.MatchLabels) == 0 {
+			return field.Required(sp, "selector cannot be empty")
+		}
+		for _, f := range [][2]string{{"apiVersion", s.APIVersion}, {"kind", s.Kind}, {"name", s.Name}} {
+			if _, err := path.Match(f[1], ""); err != nil {
+				return field.Invalid(sp.Child(f[0]), f[1], err.Error())
+			}
+		}
+	}
+	if k.ProviderConfigRef != nil && k.ProviderConfigRef.Name == "" {
+		return field.Required(p.Child("providerConfigRef", "name"), "provider config name cannot be empty")
+	}
+	for i, mp := range k.ManagementPolicies {
+		if !slices.Contains(KubernetesObjectManagementPolicies, mp) {
+			return field.NotSupported(p.Child("managementPolicies").Index(i), mp, KubernetesObjectManagementPolicies)
+		}
+	}
+	switch k.ReadinessPolicy {
+	case "", "SuccessfulCreate", "DeriveFromObject", "AllTrue":
+	default:
+		return field.NotSupported(p.Child("readinessPolicy"), k.ReadinessPolicy, []string{"SuccessfulCreate", "DeriveFromObject", "AllTrue"})
+	}
+	return nil
+}
+
+// ReservedArgumentNames are the top level arguments the function sets itself.
+var ReservedArgumentNames = []string{"resource_list", "items", "params", "env", "PATH"}
+
+func (in *KCLInput) validateTypedArguments() error {
+	names := make([]string, 0, len(in.Spec.Config.TypedArguments))
+	for name := range in.Spec.Config.TypedArguments {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		p := field.NewPath("spec.config.typedArguments").Key(name)
+		switch {
+		case name == "":
+			return field.Required(p, "argument name cannot be empty")
+		case slices.Contains(ReservedArgumentNames, name):
+			return field.Invalid(p, name, "argument is set by the function")
+		case in.hasStringArgument(name):
+			return field.Duplicate(p, name)
+		case !json.Valid(in.Spec.Config.TypedArguments[name].Raw):
+			return field.Invalid(p, "<value>", "argument must be a JSON value")
+		}
+	}
+	return nil
+}
+
+// hasStringArgument reports whether config.arguments or paramsFrom also set
+// the top level argument name.
+func (in *KCLInput) hasStringArgument(name string) bool {
+	for _, arg := range in.Spec.Config.Arguments {
+		if k, _, _ := strings.Cut(arg, "="); k == name {
+			return true
+		}
+	}
+	for _, p := range in.Spec.ParamsFrom {
+		if p.To == ToArgument && p.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+func (in *KCLInput) validateSourceRef() error {
+	if in.Spec.Source != "" {
+		return field.Invalid(field.NewPath("spec.sourceRef"), "<sourceRef>", "spec.source and spec.sourceRef are mutually exclusive")
+	}
+	if len(in.Spec.Files) > 0 {
+		return field.Invalid(field.NewPath("spec.sourceRef"), "<sourceRef>", "spec.files and spec.sourceRef are mutually exclusive")
+	}
+	if err := in.Spec.SourceRef.Validate(field.NewPath("spec.sourceRef")); err != nil {
+		return err
+	}
+	switch in.Spec.SourceRef.Kind {
+	case SourceKindOCI, SourceKindGit, SourceKindHTTP, SourceKindLocal:
+		// A remote module is fetched as it is and runs with its own kcl.mod,
+		// so the function never writes a lock file next to it.
+		if in.Spec.KclModLock != "" {
+			return field.Invalid(field.NewPath("spec.kclModLock"), "<kcl.mod.lock>", "spec.kclModLock requires spec.source, spec.files or an Inline or ConfigMap spec.sourceRef")
+		}
+	}
+	return nil
+}
+
+// ResolveSourceRef replaces spec.sourceRef, if set, with the equivalent
+// spec.source. A ConfigMap sourceRef must be replaced by the files it holds
+// with ResolveConfigMap instead.
+func (in *KCLInput) ResolveSourceRef() error {
+	if in.Spec.SourceRef == nil {
+		return nil
+	}
+	if err := in.validateSourceRef(); err != nil {
+		return err
+	}
+	if in.Spec.SourceRef.Kind == SourceKindConfigMap {
+		return field.Invalid(field.NewPath("spec.sourceRef.kind"), in.Spec.SourceRef.Kind, "must be resolved from the required ConfigMap")
+	}
+	in.Spec.Source, in.Spec.SourceRef = in.Spec.SourceRef.String(), nil
+	return nil
+}
+
+// ResolveConfigMap replaces a ConfigMap spec.sourceRef with spec.files, taken
+// from the data of the ConfigMap.
+func (in *KCLInput) ResolveConfigMap(data map[string]string) error {
+	if err := in.validateSourceRef(); err != nil {
+		return err
+	}
+	if len(data) == 0 {
+		return field.Invalid(field.NewPath("spec.sourceRef.configMap"), in.Spec.SourceRef.ConfigMap.Name, "ConfigMap has no data")
+	}
+	in.Spec.Files, in.Spec.SourceRef = data, nil
+	return in.validateFiles()
+}
+
+// EntryFile returns the file in spec.files to run.
+func (in *KCLInput) EntryFile() string {
+	if in.Spec.Entry == "" {
+		return DefaultEntry
+	}
+	return in.Spec.Entry
+}
+
+// RunSpec defines the desired state of Crossplane KCL function.
+type RunSpec struct {
+	// Source is the KCL code, or the location of a KCL module in the form
+	// krm-kcl accepts. Prefer SourceRef for remote modules. Exactly one of
+	// Source, SourceRef and Files must be set.
+	// +optional
+	Source string `json:"source,omitempty" yaml:"source,omitempty"`
+	// SourceRef is a typed reference to the KCL source.
+	// +optional
+	SourceRef *SourceRef `json:"sourceRef,omitempty" yaml:"sourceRef,omitempty"`
+	// Files is a KCL module provided inline, as a map of file paths relative to
+	// the module root to their content, e.g. main.k, helpers.k and
+	// schemas/app.k.
+	// +optional
+	Files map[string]string `json:"files,omitempty" yaml:"files,omitempty"`
+	// Entry is the file in Files to run. Defaults to main.k.
+	// +optional
+	Entry string `json:"entry,omitempty" yaml:"entry,omitempty"`
+	// Config is the compile config.
+	// +optional
+	Config ConfigSpec `json:"config,omitempty" yaml:"config,omitempty"`
+	// Credentials names the registry of the registry credentials.
+	// +optional
+	Credentials CredSpec `json:"credentials,omitempty" yaml:"credentials,omitempty"`
+	// Dependencies are the external dependencies for the KCL code.
+	// The format of the `dependencies` field is same as the `[dependencies]` in the `kcl.mod` file
+	// +optional
+	Dependencies string `json:"dependencies,omitempty" yaml:"dependencies,omitempty"`
+	// KclMod is the content of the kcl.mod for the KCL code. Its dependencies
+	// are added to Dependencies.
+	// +optional
+	KclMod string `json:"kclMod,omitempty" yaml:"kclMod,omitempty"`
+	// KclModLock is the content of the kcl.mod.lock that pins the dependencies
+	// of KclMod. It requires and must agree with KclMod, and only applies to
+	// inline sources and Files.
+	// +optional
+	KclModLock string `json:"kclModLock,omitempty" yaml:"kclModLock,omitempty"`
+	// Params are the parameters in key-value pairs format.
+	// +optional
+	Params map[string]runtime.RawExtension `json:"params,omitempty" yaml:"params,omitempty"`
+	// Env are static environment values exposed to the KCL code as
+	// option("env"), on top of the environment variables the function allows.
+	// +optional
+	Env map[string]string `json:"env,omitempty" yaml:"env,omitempty"`
+	// BuiltinParams selects which of the params the function sets itself are
+	// populated, and prunes sub-trees from them. By default all of them are
+	// populated in full.
+	// +optional
+	BuiltinParams *BuiltinParams `json:"builtinParams,omitempty" yaml:"builtinParams,omitempty"`
+	// ParamsFrom populates params, overrides and arguments from field paths of
+	// the observed or desired composite resource or the pipeline context. They
+	// are resolved before Schemas are checked.
+	// +optional
+	ParamsFrom []ParamFrom `json:"paramsFrom,omitempty" yaml:"paramsFrom,omitempty"`
+	// Schemas validate params before the KCL code runs, keyed by the name of
+	// the param they validate, e.g. oxr or a key of Params.
+	// +optional
+	Schemas map[string]ParamsSchema `json:"schemas,omitempty" yaml:"schemas,omitempty"`
+	// Resources is a list of resources to patch and create, with the
+	// PatchResources target or objects routed to it.
+	// +optional
+	Resources ResourceList `json:"resources,omitempty"`
+	// ListMergeKeys are the keys lists are merged by when objects are patched
+	// onto desired resources or the XR, keyed by the field path of the list,
+	// e.g. spec.forProvider.containers: name. Other lists are replaced.
+	// +optional
+	ListMergeKeys map[string]string `json:"listMergeKeys,omitempty" yaml:"listMergeKeys,omitempty"`
+	// NamingStrategy generates the composition resource name of output objects
+	// without a krm.kcl.dev/composition-resource-name annotation: name uses
+	// metadata.name, kind-name and apiGroup-kind-namespace-name prefix it with
+	// the kind, API group and namespace, and hash uses a stable hash of them.
+	// Generated names are truncated to 63 characters. Defaults to name.
+	// +kubebuilder:validation:Enum:=name;kind-name;apiGroup-kind-namespace-name;hash
+	// +optional
+	NamingStrategy resource.NamingStrategy `json:"namingStrategy,omitempty" yaml:"namingStrategy,omitempty"`
+	// ConflictPolicy decides what happens when the output changes or deletes
+	// a field of a desired resource that an earlier step of the pipeline set:
+	// Overwrite changes it, Fail fails the step and Skip keeps the earlier
+	// value with a Warning result. Defaults to Overwrite.
+	// +kubebuilder:validation:Enum:=Overwrite;Fail;Skip
+	// +optional
+	ConflictPolicy resource.ConflictPolicy `json:"conflictPolicy,omitempty" yaml:"conflictPolicy,omitempty"`
+	// FieldOwners records the step that last changed each field of a desired
+	// composed resource in its krm.kcl.dev/field-owners annotation, and names
+	// it in conflicts. The step is named by Step.
+	// +optional
+	FieldOwners bool `json:"fieldOwners,omitempty" yaml:"fieldOwners,omitempty"`
+	// FieldOwnersDepth records field owners per field path of at most this
+	// many segments, e.g. 2 for spec.forProvider, rather than per leaf field,
+	// to keep the annotation of a large resource small. 0 records them per
+	// leaf field.
+	// +kubebuilder:validation:Minimum=0
+	// +optional
+	FieldOwnersDepth int `json:"fieldOwnersDepth,omitempty" yaml:"fieldOwnersDepth,omitempty"`
+	// Step is the name of the pipeline step the input belongs to, which
+	// Crossplane does not send to functions. It names the step in field
+	// owners and in the meta built-in param. Defaults to the metadata.name of
+	// the input.
+	// +optional
+	Step string `json:"step,omitempty" yaml:"step,omitempty"`
+	// Defaults are partial objects merged under the output objects that
+	// become desired composed resources, e.g. to set the providerConfigRef of
+	// every managed resource. Values the output sets take precedence.
+	// +optional
+	Defaults []ResourceDefaults `json:"defaults,omitempty" yaml:"defaults,omitempty"`
+	// Propagation copies labels and annotations of the observed XR, such as
+	// crossplane.io/composite or a cost-center label, to the output objects
+	// that become desired composed resources.
+	// +optional
+	Propagation *PropagationPolicy `json:"propagation,omitempty" yaml:"propagation,omitempty"`
+	// KubernetesObjects wraps the output objects it selects, and those
+	// annotated with krm.kcl.dev/kubernetes-object: "true", into
+	// provider-kubernetes Objects.
+	// +optional
+	KubernetesObjects *KubernetesObjects `json:"kubernetesObjects,omitempty" yaml:"kubernetesObjects,omitempty"`
+	// Target determines what object the export output should be applied to.
+	// It defaults to Default, as it does when the input is run.
+	// +kubebuilder:default:=Default
+	// +kubebuilder:validation:Enum:=Default;PatchDesired;PatchResources;Resources;XR
+	Target resource.Target `json:"target"`
+}
+
+// BuiltinParamNames are the params the function sets itself. The meta param
+// is reserved for those added since; it is only set when included.
+var BuiltinParamNames = []string{
+	"oxr", "dxr", "ocds", "dcds", "ctx", "extraResources", "requiredResources", "meta",
+}
+
+// OptInBuiltinParams are the built-in params that are only set when
+// builtinParams.include lists them: the request metadata in meta, and the
+// connection details of the composite, which are secret, under it.
+var OptInBuiltinParams = []string{"meta", "meta.oxrConnectionDetails", "meta.dxrConnectionDetails"}
+
+// BuiltinParams selects and prunes the params the function sets itself.
+type BuiltinParams struct {
+	// Include lists the built-in params to populate, out of oxr, dxr, ocds,
+	// dcds, ctx, extraResources and requiredResources, which are all populated
+	// by default, and meta, meta.oxrConnectionDetails and
+	// meta.dxrConnectionDetails, which are only populated when listed. Listing
+	// only the latter adds them to the default params.
+	// +optional
+	Include []string `json:"include,omitempty" yaml:"include,omitempty"`
+	// Prune lists field paths to remove from the built-in params. A path
+	// starts with the name of the param, and a * segment matches any key or
+	// index, e.g. ocds.*.Resource.status or oxr.metadata.managedFields.
+	// +optional
+	Prune []string `json:"prune,omitempty" yaml:"prune,omitempty"`
+}
+
+// Includes reports whether the built-in param name is populated. Including
+// a field of meta includes meta. Opt-in params add to the default params
+// rather than replacing them, so only the default params listed in Include
+// restrict which of them are populated.
+func (b *BuiltinParams) Includes(name string) bool {
+	var include []string
+	if b != nil {
+		include = b.Include
+	}
+	if slices.Contains(OptInBuiltinParams, name) {
+		for _, n := range include {
+			if n == name || strings.HasPrefix(n, name+".") {
+				return true
+			}
+		}
+		return false
+	}
+	if include == nil {
+		return true
+	}
+	restricted := len(include) == 0
+	for _, n := range include {
+		if slices.Contains(OptInBuiltinParams, n) {
+			continue
+		}
+		if n == name {
+			return true
+		}
+		restricted = true
+	}
+	return !restricted
+}
+
+// ParamFromSource is where a ParamFrom reads its value from.
+type ParamFromSource string
+
+const (
+	// FromObservedComposite reads from the observed composite resource.
+	FromObservedComposite ParamFromSource = "ObservedComposite"
+	// FromDesiredComposite reads from the desired composite resource.
+	FromDesiredComposite ParamFromSource = "DesiredComposite"
+	// FromContext reads from the pipeline context. The first segment of the
+	// field path is the context key, e.g.
+	// [apiextensions.crossplane.io/environment].region.
+	FromContext ParamFromSource = "Context"
+)
+
+// ParamFromTarget is where a ParamFrom writes its value to.
+type ParamFromTarget string
+
+const (
+	// ToParam sets the param Name, read with option("params").<name>.
+	ToParam ParamFromTarget = "Param"
+	// ToOverride sets the KCL value at the path Name, like an entry of
+	// config.overrides.
+	ToOverride ParamFromTarget = "Override"
+	// ToArgument sets the top level argument Name, read with option("<name>"),
+	// like an entry of config.arguments.
+	ToArgument ParamFromTarget = "Argument"
+)
+
+// ParamFrom populates a param, override or argument from a field path.
+type ParamFrom struct {
+	// From is where the value is read from.
+	// +kubebuilder:validation:Enum:=ObservedComposite;DesiredComposite;Context
+	From ParamFromSource `json:"from" yaml:"from"`
+	// FieldPath of the value, e.g. spec.parameters.region.
+	FieldPath string `json:"fieldPath" yaml:"fieldPath"`
+	// To is where the value is written to.
+	// +kubebuilder:validation:Enum:=Param;Override;Argument
+	To ParamFromTarget `json:"to" yaml:"to"`
+	// Name of the param or argument, or the path of the override, e.g.
+	// app.image.
+	Name string `json:"name" yaml:"name"`
+	// Default is used when the field path is not set.
+	// +kubebuilder:pruning:PreserveUnknownFields
+	// +kubebuilder:validation:Schemaless
+	// +optional
+	Default *runtime.RawExtension `json:"default,omitempty" yaml:"default,omitempty"`
+	// Required fails the function when the field path is not set and there is
+	// no Default. Otherwise the param, override or argument is left unset.
+	// +optional
+	Required bool `json:"required,omitempty" yaml:"required,omitempty"`
+}
+
+// ParamsSchema is the schema a param must match. Exactly one of
+// OpenAPIV3Schema and KCL must be set.
+type ParamsSchema struct {
+	// OpenAPIV3Schema is an OpenAPI v3 schema, as in a CRD.
+	// +kubebuilder:pruning:PreserveUnknownFields
+	// +kubebuilder:validation:Type=object
+	// +optional
+	OpenAPIV3Schema *runtime.RawExtension `json:"openAPIV3Schema,omitempty" yaml:"openAPIV3Schema,omitempty"`
+	// KCL is a KCL schema.
+	// +optional
+	KCL *KCLSchema `json:"kcl,omitempty" yaml:"kcl,omitempty"`
+}
+
+// ResourceDefaults is a partial object merged under every output object its
+// selector selects. An object must match every selector field that is set.
+type ResourceDefaults struct {
+	// APIVersion selects objects by apiVersion, as a glob pattern, e.g.
+	// *.aws.upbound.io/*.
+	// +optional
+	APIVersion string `json:"apiVersion,omitempty" yaml:"apiVersion,omitempty"`
+	// Kind selects objects by kind, as a glob pattern.
+	// +optional
+	Kind string `json:"kind,omitempty" yaml:"kind,omitempty"`
+	// Name selects objects by metadata.name, as a glob pattern.
+	// +optional
+	Name string `json:"name,omitempty" yaml:"name,omitempty"`
+	// MatchLabels selects objects with all of these labels.
+	// +optional
+	MatchLabels map[string]string `json:"matchLabels,omitempty" yaml:"matchLabels,omitempty"`
+	// Values is the partial object, e.g. a spec.providerConfigRef.
+	// +kubebuilder:pruning:PreserveUnknownFields
+	// +kubebuilder:validation:Type=object
+	Values runtime.RawExtension `json:"values" yaml:"values"`
+}
+
+// PropagationPolicy selects the labels and annotations of the observed XR
+// that are copied to output objects.
+type PropagationPolicy struct {
+	// Labels are the keys of the labels to copy. A key ending in * matches
+	// every key with that prefix, e.g. crossplane.io/*.
+	// +optional
+	Labels []string `json:"labels,omitempty" yaml:"labels,omitempty"`
+	// Annotations are the keys of the annotations to copy, like Labels.
+	// +optional
+	Annotations []string `json:"annotations,omitempty" yaml:"annotations,omitempty"`
+	// KeepExisting keeps the value of a label or annotation the output object
+	// already sets, instead of replacing it with the value of the XR.
+	// +optional
+	KeepExisting bool `json:"keepExisting,omitempty" yaml:"keepExisting,omitempty"`
+}
+
+// KubernetesObjects configures the provider-kubernetes Objects output objects
+// are wrapped into.
+type KubernetesObjects struct {
+	// APIVersion is the apiVersion of the Objects, e.g.
+	// kubernetes.m.crossplane.io/v1alpha1 for namespaced Objects. Defaults to
+	// kubernetes.crossplane.io/v1alpha2.
+	// +optional
+	APIVersion string `json:"apiVersion,omitempty" yaml:"apiVersion,omitempty"`
+	// Selectors select the output objects to wrap.
+	// +optional
+	Selectors []ObjectSelector `json:"selectors,omitempty" yaml:"selectors,omitempty"`
+	// ProviderConfigRef is the provider config of the Objects.
+	// +optional
+	ProviderConfigRef *ProviderConfigReference `json:"providerConfigRef,omitempty" yaml:"providerConfigRef,omitempty"`
+	// ManagementPolicies are the management policies of the Objects.
+	// +optional
+	ManagementPolicies []string `json:"managementPolicies,omitempty" yaml:"managementPolicies,omitempty"`
+	// ReadinessPolicy is the readiness policy of the Objects.
+	// +kubebuilder:validation:Enum:=SuccessfulCreate;DeriveFromObject;AllTrue
+	// +optional
+	ReadinessPolicy string `json:"readinessPolicy,omitempty" yaml:"readinessPolicy,omitempty"`
+}
+
+// ObjectSelector selects output objects. An object must match every field
+// that is set.
+type ObjectSelector struct {
+	// APIVersion selects objects by apiVersion, as a glob pattern.
+	// +optional
+	APIVersion string `json:"apiVersion,omitempty" yaml:"apiVersion,omitempty"`
+	// Kind selects objects by kind, as a glob pattern.
+	// +optional
+	Kind string `json:"kind,omitempty" yaml:"kind,omitempty"`
+	// Name selects objects by metadata.name, as a glob pattern.
+	// +optional
+	Name string `json:"name,omitempty" yaml:"name,omitempty"`
+	// MatchLabels selects objects with all of these labels.
+	// +optional
+	MatchLabels map[string]string `json:"matchLabels,omitempty" yaml:"matchLabels,omitempty"`
+}
+
+// ProviderConfigReference names a provider config.
+type ProviderConfigReference struct {
+	// Name of the provider config.
+	Name string `json:"name" yaml:"name"`
+}
+
+// KCLSchema is a KCL schema a param must match.
+type KCLSchema struct {
+	// Name of the schema, e.g. Params.
+	Name string `json:"name" yaml:"name"`
+	// Source is the KCL code that defines the schema.
+	Source string `json:"source" yaml:"source"`
+}
+
+// ConfigSpec defines the compile config.
+type ConfigSpec struct {
+	// Arguments is the list of top level dynamic arguments for the kcl option function, e.g., env="prod"
+	Arguments []string `json:"arguments,omitempty" yaml:"arguments,omitempty"`
+	// TypedArguments are top level dynamic arguments for the kcl option
+	// function given as JSON values, e.g., replicas: 3 or tags: [a, b]. Unlike
+	// arguments, option() returns them with their types, objects and lists
+	// included.
+	// +kubebuilder:validation:Schemaless
+	// +kubebuilder:validation:Type=object
+	// +kubebuilder:pruning:PreserveUnknownFields
+	TypedArguments map[string]runtime.RawExtension `json:"typedArguments,omitempty" yaml:"typedArguments,omitempty"`
+	// Settings is the list of kcl setting files including all of the CLI config.
+	Settings []string `json:"settings,omitempty" yaml:"settings,omitempty"`
+	// InlineSettings is the list of kcl setting file contents, in the same
+	// kcl.yaml format as the files listed in Settings.
+	InlineSettings []string `json:"inlineSettings,omitempty" yaml:"inlineSettings,omitempty"`
+	// Overrides is the list of override paths and values, e.g., app.image="v2"
+	Overrides []string `json:"overrides,omitempty" yaml:"overrides,omitempty"`
+	// PathSelectors is the list of path selectors to select output result, e.g., a.b.c
+	PathSelectors []string `json:"pathSelectors,omitempty" yaml:"pathSelectors,omitempty"`
+	// Vendor denotes running kcl in the vendor mode.
+	Vendor bool `json:"vendor,omitempty" yaml:"vendor,omitempty"`
+	// SortKeys denotes sorting the output result keys, e.g., `{b = 1, a = 2} => {a = 2, b = 1}`.
+	SortKeys bool `json:"sortKeys,omitempty" yaml:"sortKeys,omitempty"`
+	// ShowHidden denotes output the hidden attribute in the result.
+	ShowHidden bool `json:"showHidden,omitempty" yaml:"showHidden,omitempty"`
+	// DisableNone denotes running kcl and disable dumping None values.
+	DisableNone bool `json:"disableNone,omitempty" yaml:"disableNone,omitempty"`
+	// Debug denotes running kcl in debug mode.
+	Debug bool `json:"debug,omitempty" yaml:"debug,omitempty"`
+	// StrictRangeCheck performs the 32-bit strict numeric range checks on numbers.
+	StrictRangeCheck bool `json:"strictRangeCheck,omitempty" yaml:"strictRangeCheck,omitempty"`
+}
+
+// CredSpec names the registry that registry credentials are for. Unlike
+// v1alpha1 it holds no username and password, which are given as function
+// credentials or KCL_SRC_* environment variables instead.
+type CredSpec struct {
+	// Url of the registry, used when the credentials do not name one.
+	// +optional
+	Url string `json:"url,omitempty" yaml:"url,omitempty"`
+}
+
+type ResourceList []Resource
+
+type Resource struct {
+	// Name is a unique identifier for this entry in a ResourceList
+	Name string `json:"name"`
+	// Base of the composed resource that patches will be applied to.
+	// According to the patches and transforms functions, this may be ommited on
+	// occassion by a previous pipeline
+	// +kubebuilder:pruning:PreserveUnknownFields
+	// +kubebuilder:validation:EmbeddedResource
+	// +optional
+	Base *runtime.RawExtension `json:"base,omitempty"`
+}
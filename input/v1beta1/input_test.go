@@ -0,0 +1,214 @@
+package v1beta1
+
+import (
+	"testing"
+
+	"github.com/crossplane/crossplane-runtime/v2/pkg/test"
+	"github.com/google/go-cmp/cmp"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+
+	"github.com/crossplane-contrib/function-kcl/pkg/resource"
+)
+
+func TestValidate(t *testing.T) {
+	cases := map[string]struct {
+		reason string
+		spec   RunSpec
+		want   error
+	}{
+		"Valid": {
+			reason: "An inline source with a known target should be valid.",
+			spec:   RunSpec{Source: "a = 1", Target: resource.Resources},
+		},
+		"UnknownTarget": {
+			reason: "An unknown target should be rejected rather than defaulted.",
+			spec:   RunSpec{Source: "a = 1", Target: "Everything"},
+			want:   field.NotSupported(field.NewPath("spec.target"), resource.Target("Everything"), []string{"Default", "PatchDesired", "PatchResources", "Resources", "XR"}),
+		},
+		"NoSource": {
+			reason: "One of source, sourceRef and files should be required.",
+			spec:   RunSpec{Target: resource.Default},
+			want:   field.Required(field.NewPath("spec.source"), "kcl source cannot be empty"),
+		},
+		"ResourcesWithoutPatchResources": {
+			reason: "Resources should be accepted for any target, since objects can be routed to PatchResources by annotation.",
+			spec:   RunSpec{Source: "a = 1", Target: resource.Default, Resources: ResourceList{{Name: "a", Base: &runtime.RawExtension{}}}},
+		},
+		"ResourceWithoutName": {
+			reason: "Resources should be named whatever the target.",
+			spec:   RunSpec{Source: "a = 1", Target: resource.Default, Resources: ResourceList{{Base: &runtime.RawExtension{}}}},
+			want:   field.Required(field.NewPath("spec.resources").Index(0).Child("name"), "name cannot be empty"),
+		},
+		"PatchResourcesWithoutResources": {
+			reason: "The PatchResources target should require resources.",
+			spec:   RunSpec{Source: "a = 1", Target: resource.PatchResources},
+			want:   field.Required(field.NewPath("spec.resources"), "PatchResources target requires at least one resource"),
+		},
+		"EntryWithoutFiles": {
+			reason: "An entry should be rejected when there are no files to run it from.",
+			spec:   RunSpec{Source: "a = 1", Entry: "main.k", Target: resource.Default},
+			want:   field.Invalid(field.NewPath("spec.entry"), "main.k", "spec.entry requires spec.files or a ConfigMap spec.sourceRef"),
+		},
+		"EntryWithConfigMap": {
+			reason: "An entry should be allowed for a ConfigMap source, whose files are loaded later.",
+			spec:   RunSpec{SourceRef: &SourceRef{Kind: SourceKindConfigMap, ConfigMap: &ConfigMapSource{Name: "a", Namespace: "b"}}, Entry: "app.k", Target: resource.Default},
+		},
+		"LockWithoutMod": {
+			reason: "A kcl.mod.lock should be rejected without the kcl.mod it locks.",
+			spec:   RunSpec{Source: "a = 1", KclModLock: "[dependencies]", Target: resource.Default},
+			want:   field.Invalid(field.NewPath("spec.kclModLock"), "<kcl.mod.lock>", "spec.kclModLock requires spec.kclMod"),
+		},
+		"SchemaWithoutKind": {
+			reason: "A params schema should set exactly one of openAPIV3Schema and kcl.",
+			spec:   RunSpec{Source: "a = 1", Schemas: map[string]ParamsSchema{"oxr": {}}, Target: resource.Default},
+			want:   field.Invalid(field.NewPath("spec.schemas").Key("oxr"), "<schema>", "exactly one of openAPIV3Schema and kcl must be set"),
+		},
+		"KCLSchemaWithoutSource": {
+			reason: "A KCL params schema should include the code that defines it.",
+			spec:   RunSpec{Source: "a = 1", Schemas: map[string]ParamsSchema{"oxr": {KCL: &KCLSchema{Name: "XR"}}}, Target: resource.Default},
+			want:   field.Required(field.NewPath("spec.schemas").Key("oxr").Child("kcl", "source"), "schema source cannot be empty"),
+		},
+		"ParamsFromReservedName": {
+			reason: "paramsFrom should not overwrite a param the function sets.",
+			spec:   RunSpec{Source: "a = 1", ParamsFrom: []ParamFrom{{From: FromContext, FieldPath: "a", To: ToParam, Name: "oxr"}}, Target: resource.Default},
+			want:   field.Invalid(field.NewPath("spec.paramsFrom").Index(0).Child("name"), "oxr", "param is set by the function"),
+		},
+		"UnknownBuiltinParam": {
+			reason: "builtinParams should only include the params the function sets.",
+			spec:   RunSpec{Source: "a = 1", BuiltinParams: &BuiltinParams{Include: []string{"oxr", "env"}}, Target: resource.Default},
+			want:   field.NotSupported(field.NewPath("spec.builtinParams.include").Index(1), "env", []string{"oxr", "dxr", "ocds", "dcds", "ctx", "extraResources", "requiredResources", "meta", "meta.oxrConnectionDetails", "meta.dxrConnectionDetails"}),
+		},
+		"ParamsReservedName": {
+			reason: "A param should not share the name of an included built-in param, which would replace it.",
+			spec:   RunSpec{Source: "a = 1", Params: map[string]runtime.RawExtension{"oxr": {Raw: []byte(`{}`)}}, Target: resource.Default},
+			want:   field.Invalid(field.NewPath("spec.params").Key("oxr"), "<param>", "param is set by the function"),
+		},
+		"ParamsReservedNameIncluded": {
+			reason: "A param should not share the name of an opt-in built-in param once it is included.",
+			spec:   RunSpec{Source: "a = 1", BuiltinParams: &BuiltinParams{Include: []string{"meta"}}, Params: map[string]runtime.RawExtension{"meta": {Raw: []byte(`{}`)}}, Target: resource.Default},
+			want:   field.Invalid(field.NewPath("spec.params").Key("meta"), "<param>", "param is set by the function"),
+		},
+		"ParamsNameOfExcludedBuiltinParam": {
+			reason: "A param may share the name of a built-in param that is not included.",
+			spec:   RunSpec{Source: "a = 1", BuiltinParams: &BuiltinParams{Include: []string{"oxr"}}, Params: map[string]runtime.RawExtension{"meta": {Raw: []byte(`{}`)}, "ocds": {Raw: []byte(`{}`)}}, Target: resource.Default},
+		},
+		"PruneWholeParam": {
+			reason: "A prune path should name a field within a built-in param.",
+			spec:   RunSpec{Source: "a = 1", BuiltinParams: &BuiltinParams{Prune: []string{"ocds"}}, Target: resource.Default},
+			want:   field.Invalid(field.NewPath("spec.builtinParams.prune").Index(0), "ocds", "path must start with a built-in param and name a field within it"),
+		},
+		"ReservedTypedArgument": {
+			reason: "typedArguments should not set the arguments the function sets.",
+			spec:   RunSpec{Source: "a = 1", Config: ConfigSpec{TypedArguments: map[string]runtime.RawExtension{"params": {Raw: []byte(`{}`)}}}, Target: resource.Default},
+			want:   field.Invalid(field.NewPath("spec.config.typedArguments").Key("params"), "params", "argument is set by the function"),
+		},
+		"DuplicateTypedArgument": {
+			reason: "typedArguments should not repeat an entry of arguments.",
+			spec:   RunSpec{Source: "a = 1", Config: ConfigSpec{Arguments: []string{"replicas=3"}, TypedArguments: map[string]runtime.RawExtension{"replicas": {Raw: []byte(`3`)}}}, Target: resource.Default},
+			want:   field.Duplicate(field.NewPath("spec.config.typedArguments").Key("replicas"), "replicas"),
+		},
+		"ListMergeKeyWithIndex": {
+			reason: "A list merge key path should not index into a list.",
+			spec:   RunSpec{Source: "a = 1", ListMergeKeys: map[string]string{"spec.containers[0].ports": "name"}, Target: resource.Default},
+			want:   field.Invalid(field.NewPath("spec.listMergeKeys").Key("spec.containers[0].ports"), "spec.containers[0].ports", "path must name fields only, without list indexes"),
+		},
+		"UnknownNamingStrategy": {
+			reason: "An unknown naming strategy should be rejected.",
+			spec:   RunSpec{Source: "a = 1", NamingStrategy: "uuid", Target: resource.Default},
+			want:   field.NotSupported(field.NewPath("spec.namingStrategy"), resource.NamingStrategy("uuid"), []string{"name", "kind-name", "apiGroup-kind-namespace-name", "hash"}),
+		},
+		"UnknownConflictPolicy": {
+			reason: "An unknown conflict policy should be rejected.",
+			spec:   RunSpec{Source: "a = 1", ConflictPolicy: "Merge", Target: resource.Default},
+			want:   field.NotSupported(field.NewPath("spec.conflictPolicy"), resource.ConflictPolicy("Merge"), []string{"Overwrite", "Fail", "Skip"}),
+		},
+		"FieldOwnersWithoutStep": {
+			reason: "Field owners should require a step name, since Crossplane does not send it.",
+			spec:   RunSpec{Source: "a = 1", FieldOwners: true, Target: resource.Default},
+			want:   field.Required(field.NewPath("spec.step"), "fieldOwners requires the name of the step in spec.step or metadata.name"),
+		},
+		"FieldOwnersWithStep": {
+			reason: "Field owners should be accepted with a step name.",
+			spec:   RunSpec{Source: "a = 1", FieldOwners: true, Step: "networking", Target: resource.Default},
+		},
+		"FieldOwnersNegativeDepth": {
+			reason: "A negative field owners depth should be rejected.",
+			spec:   RunSpec{Source: "a = 1", FieldOwners: true, FieldOwnersDepth: -1, Step: "networking", Target: resource.Default},
+			want:   field.Invalid(field.NewPath("spec.fieldOwnersDepth"), -1, "must not be negative"),
+		},
+		"DefaultsBadPattern": {
+			reason: "A defaults selector that is not a valid glob pattern should be rejected.",
+			spec:   RunSpec{Source: "a = 1", Defaults: []ResourceDefaults{{Kind: "[Bucket", Values: runtime.RawExtension{Raw: []byte(`{"spec":{}}`)}}}, Target: resource.Default},
+			want:   field.Invalid(field.NewPath("spec.defaults").Index(0).Child("kind"), "[Bucket", "syntax error in pattern"),
+		},
+		"DefaultsEmptyValues": {
+			reason: "Defaults without values should be rejected.",
+			spec:   RunSpec{Source: "a = 1", Defaults: []ResourceDefaults{{Kind: "Bucket", Values: runtime.RawExtension{Raw: []byte(`{}`)}}}, Target: resource.Default},
+			want:   field.Required(field.NewPath("spec.defaults").Index(0).Child("values"), "values cannot be empty"),
+		},
+		"PropagationWildcard": {
+			reason: "A propagation key should only end in a wildcard.",
+			spec:   RunSpec{Source: "a = 1", Propagation: &PropagationPolicy{Labels: []string{"crossplane.io/*-name"}}, Target: resource.Default},
+			want:   field.Invalid(field.NewPath("spec.propagation.labels").Index(0), "crossplane.io/*-name", "* is only allowed at the end of a key"),
+		},
+		"KubernetesObjectsEmptySelector": {
+			reason: "An empty Kubernetes object selector should be rejected rather than wrap everything.",
+			spec:   RunSpec{Source: "a = 1", KubernetesObjects: &KubernetesObjects{Selectors: []ObjectSelector{{}}}, Target: resource.Default},
+			want:   field.Required(field.NewPath("spec.kubernetesObjects.selectors").Index(0), "selector cannot be empty"),
+		},
+		"KubernetesObjectsManagementPolicy": {
+			reason: "An unknown management policy should be rejected.",
+			spec:   RunSpec{Source: "a = 1", KubernetesObjects: &KubernetesObjects{ManagementPolicies: []string{"Observe", "Orphan"}}, Target: resource.Default},
+			want:   field.NotSupported(field.NewPath("spec.kubernetesObjects.managementPolicies").Index(1), "Orphan", KubernetesObjectManagementPolicies),
+		},
+		"KubernetesObjectsAPIVersion": {
+			reason: "A kubernetesObjects apiVersion without a version should be rejected.",
+			spec:   RunSpec{Source: "a = 1", KubernetesObjects: &KubernetesObjects{APIVersion: "kubernetes.crossplane.io"}, Target: resource.Default},
+			want:   field.Invalid(field.NewPath("spec.kubernetesObjects.apiVersion"), "kubernetes.crossplane.io", "must be a group and version, e.g. kubernetes.crossplane.io/v1alpha2"),
+		},
+		"KclModLockRemoteSourceRef": {
+			reason: "A kcl.mod.lock should be rejected with a remote sourceRef, which it cannot apply to.",
+			spec:   RunSpec{SourceRef: &SourceRef{Kind: SourceKindOCI, OCI: &OCISource{Repo: "ghcr.io/kcl-lang/app"}}, KclMod: "[package]", KclModLock: "[dependencies]", Target: resource.Default},
+			want:   field.Invalid(field.NewPath("spec.kclModLock"), "<kcl.mod.lock>", "spec.kclModLock requires spec.source, spec.files or an Inline or ConfigMap spec.sourceRef"),
+		},
+		"SourceAndFiles": {
+			reason: "Source and files should be mutually exclusive.",
+			spec:   RunSpec{Source: "a = 1", Files: map[string]string{"main.k": "a = 1"}, Target: resource.Default},
+			want:   field.Invalid(field.NewPath("spec.files"), "<files>", "spec.source and spec.files are mutually exclusive"),
+		},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			in := &KCLInput{Spec: tc.spec}
+			err := in.Validate()
+			if diff := cmp.Diff(tc.want, err, test.EquateErrors()); diff != "" {
+				t.Errorf("%s\nValidate(): -want error, +got error:\n%s", tc.reason, diff)
+			}
+		})
+	}
+}
+
+func TestBuiltinParamsIncludes(t *testing.T) {
+	cases := map[string]struct {
+		reason string
+		b      *BuiltinParams
+		name   string
+		want   bool
+	}{
+		"DefaultParam":        {reason: "Default params should be included without builtinParams.", name: "oxr", want: true},
+		"OptInParam":          {reason: "Opt-in params should not be included without builtinParams.", name: "meta", want: false},
+		"OptInAddsToDefaults": {reason: "Including meta should keep the default params.", b: &BuiltinParams{Include: []string{"meta"}}, name: "oxr", want: true},
+		"OptInIncluded":       {reason: "Including meta should include it.", b: &BuiltinParams{Include: []string{"meta"}}, name: "meta", want: true},
+		"OptInField":          {reason: "Including a field of meta should include meta.", b: &BuiltinParams{Include: []string{"meta.oxrConnectionDetails"}}, name: "meta", want: true},
+		"DefaultRestricts":    {reason: "Listing a default param should leave out the others.", b: &BuiltinParams{Include: []string{"oxr", "meta"}}, name: "ocds", want: false},
+		"Empty":               {reason: "An empty list should include no default param.", b: &BuiltinParams{Include: []string{}}, name: "oxr", want: false},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			if got := tc.b.Includes(tc.name); got != tc.want {
+				t.Errorf("%s\nIncludes(%q): want %t, got %t", tc.reason, tc.name, tc.want, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,226 @@
+package v1beta1
+
+import (
+	"net/url"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/util/validation/field"
+)
+
+// SourceKind is the kind of location a SourceRef points to.
+type SourceKind string
+
+const (
+	// SourceKindInline is KCL code held in the SourceRef itself.
+	SourceKindInline SourceKind = "Inline"
+	// SourceKindOCI is a KCL module published to an OCI registry.
+	SourceKindOCI SourceKind = "OCI"
+	// SourceKindGit is a KCL module in a Git repository.
+	SourceKindGit SourceKind = "Git"
+	// SourceKindHTTP is a KCL file or archive served over HTTP(S).
+	SourceKindHTTP SourceKind = "HTTP"
+	// SourceKindLocal is a KCL file or module on the function's filesystem.
+	SourceKindLocal SourceKind = "Local"
+	// SourceKindConfigMap is a KCL module held in the data of a ConfigMap,
+	// which the function requests as a required resource.
+	SourceKindConfigMap SourceKind = "ConfigMap"
+)
+
+// SourceRef is a typed reference to the KCL source, as an alternative to the
+// prefix-sniffed Source string.
+type SourceRef struct {
+	// Kind of the source. The field of the same name holds its location.
+	// +kubebuilder:validation:Enum:=Inline;OCI;Git;HTTP;Local;ConfigMap
+	Kind SourceKind `json:"kind" yaml:"kind"`
+	// Inline is the KCL code, when Kind is Inline.
+	// +optional
+	Inline string `json:"inline,omitempty" yaml:"inline,omitempty"`
+	// OCI locates the module, when Kind is OCI.
+	// +optional
+	OCI *OCISource `json:"oci,omitempty" yaml:"oci,omitempty"`
+	// Git locates the module, when Kind is Git.
+	// +optional
+	Git *GitSource `json:"git,omitempty" yaml:"git,omitempty"`
+	// HTTP locates the file or archive, when Kind is HTTP.
+	// +optional
+	HTTP *HTTPSource `json:"http,omitempty" yaml:"http,omitempty"`
+	// Local locates the file or module, when Kind is Local.
+	// +optional
+	Local *LocalSource `json:"local,omitempty" yaml:"local,omitempty"`
+	// ConfigMap locates the module, when Kind is ConfigMap.
+	// +optional
+	ConfigMap *ConfigMapSource `json:"configMap,omitempty" yaml:"configMap,omitempty"`
+	// CredentialsName is the name of the function credentials used to fetch
+	// the source. Defaults to kcl-registry.
+	// +optional
+	CredentialsName string `json:"credentialsName,omitempty" yaml:"credentialsName,omitempty"`
+}
+
+// OCISource is a KCL module published to an OCI registry.
+type OCISource struct {
+	// Repo is the repository of the module, e.g. ghcr.io/kcl-lang/app.
+	Repo string `json:"repo" yaml:"repo"`
+	// Tag of the module. Mutually exclusive with Digest.
+	// +optional
+	Tag string `json:"tag,omitempty" yaml:"tag,omitempty"`
+	// Digest of the module, e.g. sha256:... Mutually exclusive with Tag.
+	// +optional
+	Digest string `json:"digest,omitempty" yaml:"digest,omitempty"`
+}
+
+// GitSource is a KCL module in a Git repository.
+type GitSource struct {
+	// URL of the repository, e.g. https://github.com/kcl-lang/modules.git.
+	URL string `json:"url" yaml:"url"`
+	// Ref is the branch or tag to check out. Mutually exclusive with Commit.
+	// +optional
+	Ref string `json:"ref,omitempty" yaml:"ref,omitempty"`
+	// Commit is the commit to check out. Mutually exclusive with Ref.
+	// +optional
+	Commit string `json:"commit,omitempty" yaml:"commit,omitempty"`
+	// Path of the module within the repository. Defaults to its root.
+	// +optional
+	Path string `json:"path,omitempty" yaml:"path,omitempty"`
+}
+
+// HTTPSource is a KCL file or archive served over HTTP(S).
+type HTTPSource struct {
+	// URL of the file or archive.
+	URL string `json:"url" yaml:"url"`
+	// Checksum the download must match, e.g. sha256:...
+	// +optional
+	Checksum string `json:"checksum,omitempty" yaml:"checksum,omitempty"`
+}
+
+// LocalSource is a KCL file or module on the function's filesystem.
+type LocalSource struct {
+	// Path of the file or module.
+	Path string `json:"path" yaml:"path"`
+}
+
+// ConfigMapSource is a KCL module held in the data of a ConfigMap. Each key is
+// a file in the module root.
+type ConfigMapSource struct {
+	// Name of the ConfigMap.
+	Name string `json:"name" yaml:"name"`
+	// Namespace of the ConfigMap.
+	Namespace string `json:"namespace" yaml:"namespace"`
+}
+
+// sourceKinds are the kinds of SourceRef, in the order their fields are
+// checked.
+var sourceKinds = []SourceKind{SourceKindInline, SourceKindOCI, SourceKindGit, SourceKindHTTP, SourceKindLocal, SourceKindConfigMap}
+
+// Validate returns an error if the SourceRef does not describe exactly one
+// location of its Kind.
+func (r *SourceRef) Validate(path *field.Path) error {
+	set := map[SourceKind]bool{
+		SourceKindInline:    r.Inline != "",
+		SourceKindOCI:       r.OCI != nil,
+		SourceKindGit:       r.Git != nil,
+		SourceKindHTTP:      r.HTTP != nil,
+		SourceKindLocal:     r.Local != nil,
+		SourceKindConfigMap: r.ConfigMap != nil,
+	}
+	if _, ok := set[r.Kind]; !ok {
+		kinds := make([]string, 0, len(sourceKinds))
+		for _, k := range sourceKinds {
+			kinds = append(kinds, string(k))
+		}
+		return field.NotSupported(path.Child("kind"), r.Kind, kinds)
+	}
+	for _, k := range sourceKinds {
+		if set[k] && k != r.Kind {
+			return field.Forbidden(path.Child((&SourceRef{Kind: k}).field()), "must not be set when kind is "+string(r.Kind))
+		}
+	}
+	child := path.Child(r.field())
+	if !set[r.Kind] {
+		return field.Required(child, "required when kind is "+string(r.Kind))
+	}
+	switch r.Kind {
+	case SourceKindOCI:
+		if r.OCI.Repo == "" {
+			return field.Required(child.Child("repo"), "repository cannot be empty")
+		}
+		if r.OCI.Tag != "" && r.OCI.Digest != "" {
+			return field.Invalid(child.Child("digest"), r.OCI.Digest, "tag and digest are mutually exclusive")
+		}
+	case SourceKindGit:
+		if r.Git.URL == "" {
+			return field.Required(child.Child("url"), "url cannot be empty")
+		}
+		if r.Git.Ref != "" && r.Git.Commit != "" {
+			return field.Invalid(child.Child("commit"), r.Git.Commit, "ref and commit are mutually exclusive")
+		}
+	case SourceKindHTTP:
+		if r.HTTP.URL == "" {
+			return field.Required(child.Child("url"), "url cannot be empty")
+		}
+	case SourceKindLocal:
+		if r.Local.Path == "" {
+			return field.Required(child.Child("path"), "path cannot be empty")
+		}
+	case SourceKindConfigMap:
+		if r.ConfigMap.Name == "" {
+			return field.Required(child.Child("name"), "name cannot be empty")
+		}
+		if r.ConfigMap.Namespace == "" {
+			return field.Required(child.Child("namespace"), "namespace cannot be empty")
+		}
+	}
+	return nil
+}
+
+// field returns the name of the field holding the location of the Kind.
+func (r *SourceRef) field() string {
+	if r.Kind == SourceKindConfigMap {
+		return "configMap"
+	}
+	return strings.ToLower(string(r.Kind))
+}
+
+// String returns the SourceRef in the string form of spec.source, which is
+// what krm-kcl fetches: oci://repo?tag=... or oci://repo@sha256:..., a
+// go-getter style git:: or HTTP address, a local path or the inline code
+// itself. The SourceRef must be valid and must not be a ConfigMap, which has
+// no string form.
+func (r *SourceRef) String() string {
+	switch r.Kind {
+	case SourceKindOCI:
+		src := "oci://" + strings.TrimPrefix(r.OCI.Repo, "oci://")
+		switch {
+		case r.OCI.Digest != "":
+			// A digest is part of the reference itself; as a tag it would
+			// become repo:sha256:..., which registries reject.
+			src += "@" + r.OCI.Digest
+		case r.OCI.Tag != "":
+			src += "?tag=" + url.QueryEscape(r.OCI.Tag)
+		}
+		return src
+	case SourceKindGit:
+		src := "git::" + strings.TrimPrefix(r.Git.URL, "git::")
+		if r.Git.Path != "" {
+			src += "//" + strings.Trim(r.Git.Path, "/")
+		}
+		// go-getter checks out a branch, tag or commit alike.
+		if ref := r.Git.Ref + r.Git.Commit; ref != "" {
+			src += "?ref=" + url.QueryEscape(ref)
+		}
+		return src
+	case SourceKindHTTP:
+		src := r.HTTP.URL
+		if r.HTTP.Checksum != "" {
+			sep := "?"
+			if strings.Contains(src, "?") {
+				sep = "&"
+			}
+			src += sep + "checksum=" + url.QueryEscape(r.HTTP.Checksum)
+		}
+		return src
+	case SourceKindLocal:
+		return r.Local.Path
+	default:
+		return r.Inline
+	}
+}
@@ -0,0 +1,49 @@
+package v1beta1
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	"oras.land/oras-go/v2/registry"
+)
+
+func TestSourceRefStringOCIDigest(t *testing.T) {
+	digest := "sha256:" + strings.Repeat("a", 64)
+	r := &SourceRef{Kind: SourceKindOCI, OCI: &OCISource{Repo: "ghcr.io/kcl-lang/app", Digest: digest}}
+
+	src := r.String()
+	if want := "oci://ghcr.io/kcl-lang/app@" + digest; src != want {
+		t.Fatalf("String(): want %q, got %q", want, src)
+	}
+	ref, err := registry.ParseReference(strings.TrimPrefix(src, "oci://"))
+	if err != nil {
+		t.Fatalf("ParseReference(%q): %v", src, err)
+	}
+	if diff := cmp.Diff(digest, ref.Reference); diff != "" {
+		t.Errorf("ParseReference(%q): -want reference, +got reference:\n%s", src, diff)
+	}
+	if _, err := ref.Digest(); err != nil {
+		t.Errorf("ParseReference(%q).Digest(): %v", src, err)
+	}
+}
+
+func TestSourceRefValidateOrder(t *testing.T) {
+	r := &SourceRef{
+		Kind:      SourceKindConfigMap,
+		ConfigMap: &ConfigMapSource{Name: "app", Namespace: "default"},
+		Inline:    "a = 1",
+		OCI:       &OCISource{Repo: "ghcr.io/x"},
+		Git:       &GitSource{URL: "https://github.com/x.git"},
+		HTTP:      &HTTPSource{URL: "https://example.com/main.k"},
+		Local:     &LocalSource{Path: "/kcl"},
+	}
+	want := field.Forbidden(field.NewPath("spec.sourceRef.inline"), "must not be set when kind is ConfigMap").Error()
+	// The first conflicting field is reported, whatever the map order.
+	for i := 0; i < 20; i++ {
+		if got := r.Validate(field.NewPath("spec.sourceRef")).Error(); got != want {
+			t.Fatalf("Validate(): want %q, got %q", want, got)
+		}
+	}
+}
@@ -0,0 +1,245 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"github.com/crossplane/crossplane-runtime/v2/pkg/errors"
+
+	fkcl "github.com/crossplane-contrib/function-kcl/input/v1beta1"
+)
+
+// spec.dependencies carries only the [dependencies] section of a kcl.mod, and
+// config.settings only paths to files that do not exist inside the function
+// pod. spec.kclMod, spec.kclModLock and config.inlineSettings carry the whole
+// files instead. They are written next to the program for inline sources; for
+// remote sources the kcl.mod dependencies are added to spec.dependencies and
+// the settings are written to temporary files. A kcl.mod.lock cannot apply to
+// a remote source, so it is rejected rather than ignored.
+
+const (
+	kclModFile     = "kcl.mod"
+	kclModLockFile = "kcl.mod.lock"
+	kclModDefault  = "[package]\nname = \"main\"\n"
+)
+
+// kclMod is the part of a kcl.mod or kcl.mod.lock that we check.
+type kclMod struct {
+	Dependencies map[string]any `toml:"dependencies"`
+}
+
+// modDependencies returns the [dependencies] section of a kcl.mod, in the
+// format spec.dependencies expects: one line per dependency, sorted by name,
+// with tables written inline. Sub-tables such as [dependencies.konfig] and
+// multi-line inline tables are flattened the same way.
+func modDependencies(mod string) (string, error) {
+	m := kclMod{}
+	if _, err := toml.Decode(mod, &m); err != nil {
+		return "", errors.Wrap(err, "cannot parse kcl.mod")
+	}
+	names := make([]string, 0, len(m.Dependencies))
+	for name := range m.Dependencies {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	lines := make([]string, 0, len(names))
+	for _, name := range names {
+		lines = append(lines, tomlKey(name)+" = "+tomlInline(m.Dependencies[name]))
+	}
+	return strings.Join(lines, "\n"), nil
+}
+
+// tomlInline returns v, as decoded from TOML, as an inline TOML value.
+func tomlInline(v any) string {
+	switch t := v.(type) {
+	case string:
+		return strconv.Quote(t)
+	case map[string]any:
+		keys := make([]string, 0, len(t))
+		for k := range t {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		fields := make([]string, 0, len(keys))
+		for _, k := range keys {
+			fields = append(fields, tomlKey(k)+" = "+tomlInline(t[k]))
+		}
+		return "{ " + strings.Join(fields, ", ") + " }"
+	case []any:
+		elems := make([]string, 0, len(t))
+		for _, e := range t {
+			elems = append(elems, tomlInline(e))
+		}
+		return "[" + strings.Join(elems, ", ") + "]"
+	case []map[string]any:
+		elems := make([]string, 0, len(t))
+		for _, e := range t {
+			elems = append(elems, tomlInline(e))
+		}
+		return "[" + strings.Join(elems, ", ") + "]"
+	default:
+		return fmt.Sprint(t)
+	}
+}
+
+// tomlKey returns k as a TOML key, quoted unless it is a bare key.
+func tomlKey(k string) string {
+	if k != "" && strings.Trim(k, "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789_-") == "" {
+		return k
+	}
+	return strconv.Quote(k)
+}
+
+// withModDependencies returns deps with the dependencies of mod appended.
+func withModDependencies(deps, mod string) (string, error) {
+	if mod == "" {
+		return deps, nil
+	}
+	md, err := modDependencies(mod)
+	switch {
+	case err != nil:
+		return "", err
+	case md == "":
+		return deps, nil
+	case deps == "":
+		return md, nil
+	default:
+		return deps + "\n" + md, nil
+	}
+}
+
+// remoteDependencies returns the dependencies to resolve for a source fetched
+// to entry: spec.dependencies, then those of spec.kclMod and of the kcl.mod of
+// the fetched module itself. The function resolves them with the registry
+// login of the request before removing it, so that the dependencies of a
+// private module are not left for kpm to fetch without the login when the
+// program runs.
+func remoteDependencies(in *fkcl.KCLInput, entry string) (string, error) {
+	deps, err := withModDependencies(in.Spec.Dependencies, in.Spec.KclMod)
+	if err != nil {
+		return "", err
+	}
+	root := entry
+	if fi, err := os.Stat(entry); err == nil && !fi.IsDir() {
+		root = filepath.Dir(entry)
+	}
+	mod, err := os.ReadFile(filepath.Join(root, kclModFile))
+	switch {
+	case os.IsNotExist(err):
+		return deps, nil
+	case err != nil:
+		return "", errors.Wrap(err, "cannot read the kcl.mod of the source")
+	}
+	return withModDependencies(deps, string(mod))
+}
+
+// checkModLockSource returns an error if in has a kcl.mod.lock but a remote
+// source. A remote module is fetched into a directory of its own and runs with
+// its own kcl.mod, so a lock file written by the function would never be read.
+func checkModLockSource(in *fkcl.KCLInput) error {
+	if in.Spec.KclModLock == "" || len(in.Spec.Files) > 0 || isInlineSource(in.Spec.Source) {
+		return nil
+	}
+	return errors.New("spec.kclModLock is only supported for inline sources and spec.files; pin the dependencies of a remote module in its own kcl.mod.lock")
+}
+
+// checkModLock returns an error if lock does not pin every dependency of mod
+// at the version mod asks for.
+func checkModLock(mod, lock string) error {
+	if mod == "" || lock == "" {
+		return nil
+	}
+	m := kclMod{}
+	if _, err := toml.Decode(mod, &m); err != nil {
+		return errors.Wrap(err, "cannot parse kcl.mod")
+	}
+	l := kclMod{}
+	if _, err := toml.Decode(lock, &l); err != nil {
+		return errors.Wrap(err, "cannot parse kcl.mod.lock")
+	}
+
+	names := make([]string, 0, len(m.Dependencies))
+	for name := range m.Dependencies {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		want := dependencyVersion(m.Dependencies[name], "version", "tag", "commit")
+		locked, ok := l.Dependencies[name]
+		if !ok {
+			return errors.Errorf("kcl.mod.lock does not match kcl.mod: dependency %q is missing from kcl.mod.lock", name)
+		}
+		if want == "" {
+			continue
+		}
+		got := dependencyVersion(locked, "version", "oci_tag", "git_tag", "commit")
+		if !lockedAt(locked, want) {
+			return errors.Errorf("kcl.mod.lock does not match kcl.mod: dependency %q is %q in kcl.mod but %q in kcl.mod.lock", name, want, got)
+		}
+	}
+	return nil
+}
+
+// dependencyVersion returns the version a dependency asks for: either the
+// dependency itself when it is a plain version string, or the first of keys
+// that is set.
+func dependencyVersion(dep any, keys ...string) string {
+	switch d := dep.(type) {
+	case string:
+		return d
+	case map[string]any:
+		for _, k := range keys {
+			if v, ok := d[k].(string); ok && v != "" {
+				return v
+			}
+		}
+	}
+	return ""
+}
+
+// lockedAt reports whether a kcl.mod.lock entry pins version, which may be a
+// version, an OCI or Git tag, or a Git commit.
+func lockedAt(locked any, version string) bool {
+	d, ok := locked.(map[string]any)
+	if !ok {
+		return false
+	}
+	for _, k := range []string{"version", "oci_tag", "git_tag", "commit"} {
+		if v, ok := d[k].(string); ok && v == version {
+			return true
+		}
+	}
+	return false
+}
+
+// writeModFiles writes the kcl.mod and kcl.mod.lock of in to dir, if set.
+func writeModFiles(dir string, in *fkcl.KCLInput) error {
+	for name, content := range map[string]string{kclModFile: in.Spec.KclMod, kclModLockFile: in.Spec.KclModLock} {
+		if content == "" {
+			continue
+		}
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o600); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeSettings writes each of the inline settings of in to a kcl.yaml-style
+// file under dir and returns their paths.
+func writeSettings(dir string, in *fkcl.KCLInput) ([]string, error) {
+	paths := make([]string, 0, len(in.Spec.Config.InlineSettings))
+	for i, content := range in.Spec.Config.InlineSettings {
+		p := filepath.Join(dir, fmt.Sprintf("settings-%d.yaml", i))
+		if err := os.WriteFile(p, []byte(content), 0o600); err != nil {
+			return nil, err
+		}
+		paths = append(paths, p)
+	}
+	return paths, nil
+}
@@ -0,0 +1,245 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	fkcl "github.com/crossplane-contrib/function-kcl/input/v1beta1"
+)
+
+const testKCLMod = `[package]
+name = "app"
+version = "0.0.1"
+
+[dependencies]
+k8s = "1.31"
+konfig = { git = "https://github.com/kcl-lang/konfig.git", tag = "v0.4.0" }
+
+[profile]
+entries = ["main.k"]
+`
+
+func TestModDependencies(t *testing.T) {
+	cases := map[string]struct {
+		reason string
+		mod    string
+		want   string
+	}{
+		"InlineTables": {
+			reason: "Dependencies should be returned one per line, sorted by name.",
+			mod:    testKCLMod,
+			want: `k8s = "1.31"
+konfig = { git = "https://github.com/kcl-lang/konfig.git", tag = "v0.4.0" }`,
+		},
+		"SubTables": {
+			reason: "A [dependencies.name] sub-table should be written as an inline table.",
+			mod: `[package]
+name = "app"
+
+[dependencies]
+k8s = "1.31"
+
+[dependencies.konfig]
+git = "https://github.com/kcl-lang/konfig.git"
+tag = "v0.4.0"
+`,
+			want: `k8s = "1.31"
+konfig = { git = "https://github.com/kcl-lang/konfig.git", tag = "v0.4.0" }`,
+		},
+		"MultiLineArray": {
+			reason: "A value that spans several lines should be written on one.",
+			mod: `[dependencies]
+app = { oci = "oci://ghcr.io/kcl-lang/app", tag = "0.1.0", exclude = [
+    "tests",
+] }
+`,
+			want: `app = { exclude = ["tests"], oci = "oci://ghcr.io/kcl-lang/app", tag = "0.1.0" }`,
+		},
+		"None": {
+			reason: "A kcl.mod without dependencies should have none.",
+			mod:    "[package]\nname = \"app\"\n",
+		},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			got, err := modDependencies(tc.mod)
+			if err != nil {
+				t.Fatalf("%s\nmodDependencies(...): unexpected error %v", tc.reason, err)
+			}
+			if diff := cmp.Diff(tc.want, got); diff != "" {
+				t.Errorf("%s\nmodDependencies(...): -want, +got:\n%s", tc.reason, diff)
+			}
+		})
+	}
+}
+
+func TestWithModDependencies(t *testing.T) {
+	if got, _ := withModDependencies(`helloworld = "0.1.0"`, testKCLMod); !strings.HasPrefix(got, "helloworld = \"0.1.0\"\nk8s = ") {
+		t.Errorf("withModDependencies(...): want spec.dependencies first, got %q", got)
+	}
+	if got, _ := withModDependencies(`helloworld = "0.1.0"`, "[package]\nname = \"app\"\n"); got != `helloworld = "0.1.0"` {
+		t.Errorf("withModDependencies(...): want spec.dependencies unchanged, got %q", got)
+	}
+	if _, err := withModDependencies("", "[dependencies"); err == nil {
+		t.Errorf("withModDependencies(...): want an error for a kcl.mod that is not TOML")
+	}
+}
+
+func TestCheckModLockSource(t *testing.T) {
+	cases := map[string]struct {
+		reason  string
+		spec    fkcl.RunSpec
+		wantErr bool
+	}{
+		"Inline": {
+			reason: "A lock file should be accepted with an inline source.",
+			spec:   fkcl.RunSpec{Source: "a = 1", KclMod: testKCLMod, KclModLock: "[dependencies]"},
+		},
+		"Files": {
+			reason: "A lock file should be accepted with spec.files.",
+			spec:   fkcl.RunSpec{Files: map[string]string{"main.k": "a = 1"}, KclMod: testKCLMod, KclModLock: "[dependencies]"},
+		},
+		"Remote": {
+			reason:  "A lock file should be rejected with a remote source, which it cannot apply to.",
+			spec:    fkcl.RunSpec{Source: "oci://ghcr.io/kcl-lang/app", KclMod: testKCLMod, KclModLock: "[dependencies]"},
+			wantErr: true,
+		},
+		"RemoteWithoutLock": {
+			reason: "A remote source without a lock file should be accepted.",
+			spec:   fkcl.RunSpec{Source: "oci://ghcr.io/kcl-lang/app", KclMod: testKCLMod},
+		},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			err := checkModLockSource(&fkcl.KCLInput{Spec: tc.spec})
+			if (err != nil) != tc.wantErr {
+				t.Errorf("%s\ncheckModLockSource(...): want error %t, got %v", tc.reason, tc.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestCheckModLock(t *testing.T) {
+	cases := map[string]struct {
+		reason string
+		lock   string
+		want   string
+	}{
+		"NoLock": {
+			reason: "A kcl.mod without a lock file is not checked.",
+		},
+		"Matches": {
+			reason: "A lock file that pins every dependency at the requested version is accepted.",
+			lock: `[dependencies]
+  [dependencies.k8s]
+    name = "k8s"
+    version = "1.31"
+  [dependencies.konfig]
+    name = "konfig"
+    git_tag = "v0.4.0"
+    commit = "abc123"
+  [dependencies.transitive]
+    name = "transitive"
+    version = "0.1.0"
+`,
+		},
+		"Missing": {
+			reason: "A dependency missing from the lock file is reported.",
+			lock: `[dependencies]
+  [dependencies.k8s]
+    version = "1.31"
+`,
+			want: `kcl.mod.lock does not match kcl.mod: dependency "konfig" is missing from kcl.mod.lock`,
+		},
+		"Mismatch": {
+			reason: "A dependency locked at another version is reported with both versions.",
+			lock: `[dependencies]
+  [dependencies.k8s]
+    version = "1.30"
+  [dependencies.konfig]
+    git_tag = "v0.4.0"
+`,
+			want: `kcl.mod.lock does not match kcl.mod: dependency "k8s" is "1.31" in kcl.mod but "1.30" in kcl.mod.lock`,
+		},
+		"Invalid": {
+			reason: "A lock file that is not TOML is reported.",
+			lock:   "[dependencies",
+			want:   "cannot parse kcl.mod.lock",
+		},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			err := checkModLock(testKCLMod, tc.lock)
+			got := ""
+			if err != nil {
+				got = err.Error()
+			}
+			if tc.want == "" && got != "" || !strings.HasPrefix(got, tc.want) {
+				t.Errorf("%s\ncheckModLock(...): want %q, got %q", tc.reason, tc.want, got)
+			}
+		})
+	}
+}
+
+func TestRemoteDependencies(t *testing.T) {
+	const privateMod = `[package]
+name = "app"
+
+[dependencies]
+private = { oci = "oci://registry.example.com/kcl/private", tag = "0.1.0" }
+`
+	module := t.TempDir()
+	if err := os.WriteFile(filepath.Join(module, kclModFile), []byte(privateMod), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	prog := filepath.Join(module, "main.k")
+	if err := os.WriteFile(prog, []byte("import private\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	const private = `private = { oci = "oci://registry.example.com/kcl/private", tag = "0.1.0" }`
+
+	cases := map[string]struct {
+		reason string
+		spec   fkcl.RunSpec
+		entry  string
+		want   string
+	}{
+		"PrivateModuleDir": {
+			reason: "The dependencies of the kcl.mod of a fetched module should be resolved with the login.",
+			entry:  module,
+			want:   private,
+		},
+		"PrivateModuleFile": {
+			reason: "The kcl.mod next to a fetched entry file should be read.",
+			entry:  prog,
+			want:   private,
+		},
+		"SpecFirst": {
+			reason: "spec.dependencies should come before those of the module.",
+			spec:   fkcl.RunSpec{Dependencies: `helloworld = "0.1.0"`},
+			entry:  module,
+			want:   "helloworld = \"0.1.0\"\n" + private,
+		},
+		"NoKCLMod": {
+			reason: "A source without a kcl.mod should only resolve spec.dependencies.",
+			spec:   fkcl.RunSpec{Dependencies: `helloworld = "0.1.0"`},
+			entry:  t.TempDir(),
+			want:   `helloworld = "0.1.0"`,
+		},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			got, err := remoteDependencies(&fkcl.KCLInput{Spec: tc.spec}, tc.entry)
+			if err != nil {
+				t.Fatalf("\n%s\nremoteDependencies(...): %v", tc.reason, err)
+			}
+			if diff := cmp.Diff(tc.want, got); diff != "" {
+				t.Errorf("\n%s\nremoteDependencies(...): -want, +got:\n%s", tc.reason, diff)
+			}
+		})
+	}
+}
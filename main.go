@@ -20,6 +20,8 @@ type CLI struct {
 	Dependencies 	   string `help:"File containing dependencies to add to all functions."`
 	Insecure     	   bool   `help:"Run without mTLS credentials. If you supply this flag --tls-server-certs-dir will be ignored."`
 	MaxRecvMessageSize int    `help:"Maximum size of received messages in MB." default:"4"`
+	EnvAllow           []string `help:"Environment variables exposed to KCL code as option(\"env\"), as shell patterns such as KCL_*. Use * to expose all of them." default:"PATH" env:"FUNCTION_KCL_ENV_ALLOW"`
+	EnvDeny            []string `help:"Environment variables never exposed to KCL code, as shell patterns. Takes precedence over --env-allow." default:"KCL_SRC_PASSWORD" env:"FUNCTION_KCL_ENV_DENY"`
 }
 
 // Run this Function.
@@ -47,7 +49,8 @@ func (c *CLI) Run() error {
 	if cache.enabled() {
 		log.Info("render cache enabled", "maxEntries", cache.max, "ttl", cache.ttl.String())
 	}
-	return function.Serve(&Function{dependencies: dependencies, log: log, recycler: rec, cache: cache},
+	env := envPolicy{allow: c.EnvAllow, deny: c.EnvDeny}
+	return function.Serve(&Function{dependencies: dependencies, log: log, recycler: rec, cache: cache, schemas: newSchemaCacheFromEnv(), env: env},
 		function.Listen(c.Network, c.Address),
 		function.MTLSCertificates(c.TLSCertsDir),
 		function.Insecure(c.Insecure),
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/crossplane/crossplane-runtime/v2/pkg/errors"
+	"github.com/crossplane/crossplane-runtime/v2/pkg/fieldpath"
+	"k8s.io/apimachinery/pkg/runtime"
+
+	fkcl "github.com/crossplane-contrib/function-kcl/input/v1beta1"
+)
+
+// paramSources are the objects spec.paramsFrom reads field paths from.
+type paramSources struct {
+	oxr map[string]any
+	dxr map[string]any
+	ctx map[string]any
+}
+
+// resolveParamsFrom sets the params, overrides and arguments of in from the
+// field paths of spec.paramsFrom. Overrides and arguments are appended after
+// the static ones in spec.config, so that they take precedence.
+func resolveParamsFrom(in *fkcl.KCLInput, src paramSources) error {
+	for i, p := range in.Spec.ParamsFrom {
+		var obj map[string]any
+		switch p.From {
+		case fkcl.FromObservedComposite:
+			obj = src.oxr
+		case fkcl.FromDesiredComposite:
+			obj = src.dxr
+		case fkcl.FromContext:
+			obj = src.ctx
+		}
+
+		v, err := fieldpath.Pave(obj).GetValue(p.FieldPath)
+		switch {
+		case fieldpath.IsNotFound(err) && p.Default != nil:
+			if err := json.Unmarshal(p.Default.Raw, &v); err != nil {
+				return errors.Wrapf(err, "cannot parse the default of spec.paramsFrom[%d]", i)
+			}
+		case fieldpath.IsNotFound(err) && p.Required:
+			return errors.Errorf("spec.paramsFrom[%d]: %s is required but not set in the %s", i, p.FieldPath, describeParamSource(p.From))
+		case fieldpath.IsNotFound(err):
+			continue
+		case err != nil:
+			return errors.Wrapf(err, "spec.paramsFrom[%d]: cannot read %s", i, p.FieldPath)
+		}
+
+		switch p.To {
+		case fkcl.ToParam:
+			raw, err := json.Marshal(v)
+			if err != nil {
+				return errors.Wrapf(err, "spec.paramsFrom[%d]: cannot encode %s", i, p.FieldPath)
+			}
+			if in.Spec.Params == nil {
+				in.Spec.Params = make(map[string]runtime.RawExtension)
+			}
+			in.Spec.Params[p.Name] = runtime.RawExtension{Raw: raw}
+		case fkcl.ToOverride:
+			in.Spec.Config.Overrides = append(in.Spec.Config.Overrides, p.Name+"="+kclLiteral(v))
+		case fkcl.ToArgument:
+			arg, err := argumentValue(v)
+			if err != nil {
+				return errors.Wrapf(err, "spec.paramsFrom[%d]: cannot encode %s", i, p.FieldPath)
+			}
+			in.Spec.Config.Arguments = append(in.Spec.Config.Arguments, p.Name+"="+arg)
+		}
+	}
+	return nil
+}
+
+func describeParamSource(from fkcl.ParamFromSource) string {
+	switch from {
+	case fkcl.FromObservedComposite:
+		return "observed composite resource"
+	case fkcl.FromDesiredComposite:
+		return "desired composite resource"
+	default:
+		return "pipeline context"
+	}
+}
+
+// argumentValue returns v as the value of a -D style argument: strings as
+// they are, anything else as JSON.
+func argumentValue(v any) (string, error) {
+	if s, ok := v.(string); ok {
+		return s, nil
+	}
+	b, err := json.Marshal(v)
+	return string(b), err
+}
+
+// kclLiteral returns v, decoded from JSON, as a KCL literal.
+func kclLiteral(v any) string {
+	switch t := v.(type) {
+	case nil:
+		return "None"
+	case bool:
+		if t {
+			return "True"
+		}
+		return "False"
+	case string:
+		return strconv.Quote(t)
+	case float64:
+		return strconv.FormatFloat(t, 'f', -1, 64)
+	case int64:
+		return strconv.FormatInt(t, 10)
+	case []any:
+		items := make([]string, 0, len(t))
+		for _, item := range t {
+			items = append(items, kclLiteral(item))
+		}
+		return "[" + strings.Join(items, ", ") + "]"
+	case map[string]any:
+		keys := make([]string, 0, len(t))
+		for k := range t {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		items := make([]string, 0, len(t))
+		for _, k := range keys {
+			items = append(items, strconv.Quote(k)+": "+kclLiteral(t[k]))
+		}
+		return "{" + strings.Join(items, ", ") + "}"
+	default:
+		return fmt.Sprintf("%v", t)
+	}
+}
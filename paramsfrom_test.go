@@ -0,0 +1,99 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/crossplane/crossplane-runtime/v2/pkg/errors"
+	"github.com/crossplane/crossplane-runtime/v2/pkg/test"
+	"github.com/google/go-cmp/cmp"
+	"k8s.io/apimachinery/pkg/runtime"
+
+	fkcl "github.com/crossplane-contrib/function-kcl/input/v1beta1"
+)
+
+func TestResolveParamsFrom(t *testing.T) {
+	src := paramSources{
+		oxr: map[string]any{"spec": map[string]any{"parameters": map[string]any{"region": "eu-west-1", "replicas": int64(3), "tags": map[string]any{"team": "a"}}}},
+		dxr: map[string]any{"status": map[string]any{"ready": true}},
+		ctx: map[string]any{"apiextensions.crossplane.io/environment": map[string]any{"tier": "prod"}},
+	}
+	type want struct {
+		spec fkcl.RunSpec
+		err  error
+	}
+	cases := map[string]struct {
+		reason     string
+		paramsFrom []fkcl.ParamFrom
+		want       want
+	}{
+		"Param": {
+			reason:     "A field of the observed XR should be set as a param.",
+			paramsFrom: []fkcl.ParamFrom{{From: fkcl.FromObservedComposite, FieldPath: "spec.parameters.tags", To: fkcl.ToParam, Name: "tags"}},
+			want:       want{spec: fkcl.RunSpec{Params: map[string]runtime.RawExtension{"tags": {Raw: []byte(`{"team":"a"}`)}}}},
+		},
+		"Override": {
+			reason:     "A field of the desired XR should be set as a KCL override.",
+			paramsFrom: []fkcl.ParamFrom{{From: fkcl.FromDesiredComposite, FieldPath: "status.ready", To: fkcl.ToOverride, Name: "app.ready"}},
+			want:       want{spec: fkcl.RunSpec{Config: fkcl.ConfigSpec{Overrides: []string{"app.ready=True"}}}},
+		},
+		"Argument": {
+			reason: "Fields of the context should be set as arguments, strings as they are and anything else as JSON.",
+			paramsFrom: []fkcl.ParamFrom{
+				{From: fkcl.FromContext, FieldPath: "[apiextensions.crossplane.io/environment].tier", To: fkcl.ToArgument, Name: "tier"},
+				{From: fkcl.FromObservedComposite, FieldPath: "spec.parameters.replicas", To: fkcl.ToArgument, Name: "replicas"},
+			},
+			want: want{spec: fkcl.RunSpec{Config: fkcl.ConfigSpec{Arguments: []string{"tier=prod", "replicas=3"}}}},
+		},
+		"Default": {
+			reason:     "The default should be used when the field path is not set.",
+			paramsFrom: []fkcl.ParamFrom{{From: fkcl.FromObservedComposite, FieldPath: "spec.parameters.size", To: fkcl.ToOverride, Name: "app.size", Default: &runtime.RawExtension{Raw: []byte(`"small"`)}}},
+			want:       want{spec: fkcl.RunSpec{Config: fkcl.ConfigSpec{Overrides: []string{`app.size="small"`}}}},
+		},
+		"Optional": {
+			reason:     "An optional field path that is not set should be skipped.",
+			paramsFrom: []fkcl.ParamFrom{{From: fkcl.FromObservedComposite, FieldPath: "spec.parameters.size", To: fkcl.ToParam, Name: "size"}},
+		},
+		"Required": {
+			reason:     "A required field path that is not set should be an error.",
+			paramsFrom: []fkcl.ParamFrom{{From: fkcl.FromObservedComposite, FieldPath: "spec.parameters.size", To: fkcl.ToParam, Name: "size", Required: true}},
+			want:       want{err: errors.New("spec.paramsFrom[0]: spec.parameters.size is required but not set in the observed composite resource")},
+		},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			in := &fkcl.KCLInput{Spec: fkcl.RunSpec{ParamsFrom: tc.paramsFrom}}
+			err := resolveParamsFrom(in, src)
+			if diff := cmp.Diff(tc.want.err, err, test.EquateErrors()); diff != "" {
+				t.Errorf("%s\nresolveParamsFrom(...): -want error, +got error:\n%s", tc.reason, diff)
+			}
+			if err != nil {
+				return
+			}
+			in.Spec.ParamsFrom = nil
+			if diff := cmp.Diff(tc.want.spec, in.Spec); diff != "" {
+				t.Errorf("%s\nresolveParamsFrom(...): -want spec, +got spec:\n%s", tc.reason, diff)
+			}
+		})
+	}
+}
+
+func TestKCLLiteral(t *testing.T) {
+	cases := map[string]struct {
+		v    any
+		want string
+	}{
+		"None":   {v: nil, want: "None"},
+		"Bool":   {v: false, want: "False"},
+		"String": {v: `a "b"`, want: `"a \"b\""`},
+		"Float":  {v: 1.5, want: "1.5"},
+		"List":   {v: []any{int64(1), "a"}, want: `[1, "a"]`},
+		"Dict":   {v: map[string]any{"b": true, "a": nil}, want: `{"a": None, "b": True}`},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			if got := kclLiteral(tc.v); got != tc.want {
+				t.Errorf("kclLiteral(%v) = %s, want %s", tc.v, got, tc.want)
+			}
+		})
+	}
+}
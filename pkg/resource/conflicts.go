@@ -0,0 +1,225 @@
+package resource
+
+import (
+	"encoding/json"
+	"reflect"
+	"sort"
+
+	"github.com/crossplane/crossplane-runtime/v2/pkg/fieldpath"
+	"github.com/pkg/errors"
+)
+
+// ConflictPolicy decides what happens when a step changes or deletes a field
+// of a desired resource that an earlier step of the pipeline set.
+type ConflictPolicy string
+
+const (
+	// ConflictOverwrite lets the step change the field.
+	ConflictOverwrite ConflictPolicy = "Overwrite"
+	// ConflictFail fails the step.
+	ConflictFail ConflictPolicy = "Fail"
+	// ConflictSkip keeps the value the earlier step set.
+	ConflictSkip ConflictPolicy = "Skip"
+)
+
+// AnnotationKeyFieldOwners records the step that last changed each field of
+// a desired composed resource, as a JSON object keyed by field path. Owners
+// are recorded per leaf field, or per field up to a depth, so that the
+// annotation of a large resource can be kept within the 256KiB all
+// annotations of an object must fit in.
+const AnnotationKeyFieldOwners = "krm.kcl.dev/field-owners"
+
+// Conflict is a field an earlier step set that a step changed or deleted.
+type Conflict struct {
+	// Path of the field.
+	Path string
+	// Owner is the step that last changed the field, or the recorded field
+	// it is part of, if field owners are recorded.
+	Owner string
+}
+
+// ResolveConflicts compares after, an object as a step left it, with before,
+// the object as earlier steps desired it, and returns the fields of before
+// whose value after changes or deletes, sorted by path. Lists are compared as
+// a whole. With the Skip policy, those fields are restored to their value in
+// before, once after is merged.
+func ResolveConflicts(before, after map[string]interface{}, policy ConflictPolicy) ([]Conflict, error) {
+	owners, err := FieldOwners(before)
+	if err != nil {
+		return nil, err
+	}
+	var conflicts []Conflict
+	walkLeaves(before, nil, func(path []string, v interface{}) {
+		if isOwnersAnnotation(path) {
+			return
+		}
+		if av, ok := lookup(after, path); ok && jsonEqual(av, v) {
+			return
+		}
+		conflicts = append(conflicts, Conflict{Path: pathString(path), Owner: ownerOf(owners, path)})
+		if policy == ConflictSkip {
+			set(after, path, v)
+		}
+	})
+	sort.Slice(conflicts, func(i, j int) bool { return conflicts[i].Path < conflicts[j].Path })
+	return conflicts, nil
+}
+
+// RecordFieldOwners records step as the owner of every field of after that it
+// adds, changes or deletes part of relative to before, in the field owners
+// annotation of after. The other fields keep the owners recorded in before.
+// Fields are the leaves of after, or their paths cut to depth segments when
+// depth is positive. after is left as it is when it does not differ from
+// before.
+func RecordFieldOwners(before, after map[string]interface{}, step string, depth int) error {
+	previous, err := FieldOwners(before)
+	if err != nil {
+		return err
+	}
+	// present maps the fields of after to their owners in before.
+	present := make(map[string]string)
+	changed := make(map[string]bool)
+	walkLeaves(after, nil, func(path []string, v interface{}) {
+		if isOwnersAnnotation(path) {
+			return
+		}
+		p := pathString(ownedField(path, depth))
+		if _, ok := present[p]; !ok {
+			present[p] = ownerOf(previous, ownedField(path, depth))
+		}
+		if bv, ok := lookup(before, path); !ok || !jsonEqual(bv, v) {
+			changed[p] = true
+		}
+	})
+	walkLeaves(before, nil, func(path []string, _ interface{}) {
+		if isOwnersAnnotation(path) {
+			return
+		}
+		if _, ok := lookup(after, path); !ok {
+			changed[pathString(ownedField(path, depth))] = true
+		}
+	})
+	if len(changed) == 0 {
+		return nil
+	}
+	owners := make(map[string]string, len(present))
+	for p, o := range present {
+		switch {
+		case changed[p]:
+			owners[p] = step
+		case o != "":
+			owners[p] = o
+		}
+	}
+	b, err := json.Marshal(owners)
+	if err != nil {
+		return errors.Wrap(err, "cannot encode field owners")
+	}
+	set(after, []string{"metadata", "annotations", AnnotationKeyFieldOwners}, string(b))
+	return nil
+}
+
+// FieldOwners returns the field owners recorded in obj, keyed by field path.
+func FieldOwners(obj map[string]interface{}) (map[string]string, error) {
+	owners := make(map[string]string)
+	v, ok := lookup(obj, []string{"metadata", "annotations", AnnotationKeyFieldOwners})
+	if !ok {
+		return owners, nil
+	}
+	s, _ := v.(string)
+	if err := json.Unmarshal([]byte(s), &owners); err != nil {
+		return nil, errors.Wrapf(err, "cannot decode %q annotation", AnnotationKeyFieldOwners)
+	}
+	return owners, nil
+}
+
+// walkLeaves calls fn with the path and value of every leaf of obj. Lists and
+// empty objects are leaves.
+func walkLeaves(obj map[string]interface{}, path []string, fn func(path []string, v interface{})) {
+	for k, v := range obj {
+		p := append(path[:len(path):len(path)], k)
+		if m, ok := v.(map[string]interface{}); ok && len(m) > 0 {
+			walkLeaves(m, p, fn)
+			continue
+		}
+		fn(p, v)
+	}
+}
+
+func lookup(obj map[string]interface{}, path []string) (interface{}, bool) {
+	var v interface{} = obj
+	for _, k := range path {
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		if v, ok = m[k]; !ok {
+			return nil, false
+		}
+	}
+	return v, true
+}
+
+// set sets the value at path in obj, replacing anything along the path that
+// is not an object.
+func set(obj map[string]interface{}, path []string, v interface{}) {
+	m := obj
+	for _, k := range path[:len(path)-1] {
+		child, ok := m[k].(map[string]interface{})
+		if !ok {
+			child = make(map[string]interface{})
+			m[k] = child
+		}
+		m = child
+	}
+	m[path[len(path)-1]] = v
+}
+
+// ownedField returns the prefix of path that owners are recorded for: path
+// itself, or its first depth segments when depth is positive.
+func ownedField(path []string, depth int) []string {
+	if depth > 0 && len(path) > depth {
+		return path[:depth]
+	}
+	return path
+}
+
+// ownerOf returns the owner recorded for path or for the longest prefix of it
+// that has one, so that owners recorded at another depth still apply.
+func ownerOf(owners map[string]string, path []string) string {
+	for i := len(path); i > 0; i-- {
+		if o, ok := owners[pathString(path[:i])]; ok {
+			return o
+		}
+	}
+	return ""
+}
+
+func isOwnersAnnotation(path []string) bool {
+	return len(path) == 3 && path[0] == "metadata" && path[1] == "annotations" && path[2] == AnnotationKeyFieldOwners
+}
+
+func pathString(path []string) string {
+	segs := make(fieldpath.Segments, 0, len(path))
+	for _, k := range path {
+		segs = append(segs, fieldpath.Field(k))
+	}
+	return segs.String()
+}
+
+// jsonEqual reports whether a and b encode to the same JSON, so that numbers
+// decoded as int64 and float64 compare equal.
+func jsonEqual(a, b interface{}) bool {
+	if reflect.DeepEqual(a, b) {
+		return true
+	}
+	ja, err := json.Marshal(a)
+	if err != nil {
+		return false
+	}
+	jb, err := json.Marshal(b)
+	if err != nil {
+		return false
+	}
+	return string(ja) == string(jb)
+}
@@ -0,0 +1,146 @@
+package resource
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestResolveConflicts(t *testing.T) {
+	before := func() map[string]interface{} {
+		return map[string]interface{}{
+			"metadata": map[string]interface{}{
+				"name":        "bucket",
+				"annotations": map[string]interface{}{AnnotationKeyFieldOwners: `{"spec":"base"}`},
+			},
+			"spec": map[string]interface{}{"region": "eu-west-1", "size": float64(3), "tags": []interface{}{"a"}},
+		}
+	}
+	after := func() map[string]interface{} {
+		return map[string]interface{}{
+			"metadata": map[string]interface{}{"name": "bucket"},
+			"spec":     map[string]interface{}{"region": "us-east-1", "size": int64(3), "acl": "private"},
+		}
+	}
+	cases := map[string]struct {
+		reason string
+		policy ConflictPolicy
+		want   []Conflict
+		after  map[string]interface{}
+	}{
+		"Overwrite": {
+			reason: "Changed and deleted fields should be reported, and left as the step set them.",
+			policy: ConflictOverwrite,
+			want:   []Conflict{{Path: "spec.region", Owner: "base"}, {Path: "spec.tags", Owner: "base"}},
+			after:  after(),
+		},
+		"Skip": {
+			reason: "Changed and deleted fields should be restored with the Skip policy.",
+			policy: ConflictSkip,
+			want:   []Conflict{{Path: "spec.region", Owner: "base"}, {Path: "spec.tags", Owner: "base"}},
+			after: map[string]interface{}{
+				"metadata": map[string]interface{}{"name": "bucket"},
+				"spec":     map[string]interface{}{"region": "eu-west-1", "size": int64(3), "acl": "private", "tags": []interface{}{"a"}},
+			},
+		},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			got := after()
+			conflicts, err := ResolveConflicts(before(), got, tc.policy)
+			if err != nil {
+				t.Fatalf("%s\nResolveConflicts(...): unexpected error %v", tc.reason, err)
+			}
+			if diff := cmp.Diff(tc.want, conflicts); diff != "" {
+				t.Errorf("%s\nResolveConflicts(...): -want conflicts, +got conflicts:\n%s", tc.reason, diff)
+			}
+			if diff := cmp.Diff(tc.after, got); diff != "" {
+				t.Errorf("%s\nResolveConflicts(...): -want after, +got after:\n%s", tc.reason, diff)
+			}
+		})
+	}
+}
+
+func TestRecordFieldOwners(t *testing.T) {
+	before := func() map[string]interface{} {
+		return map[string]interface{}{
+			"metadata": map[string]interface{}{
+				"name":        "bucket",
+				"labels":      map[string]interface{}{"team": "a"},
+				"annotations": map[string]interface{}{AnnotationKeyFieldOwners: `{"metadata.name":"base","metadata.labels":"base","spec":"base","status":"base"}`},
+			},
+			"spec":   map[string]interface{}{"region": "eu-west-1"},
+			"status": map[string]interface{}{"phase": "Ready", "size": float64(3)},
+		}
+	}
+	after := func() map[string]interface{} {
+		return map[string]interface{}{
+			"metadata": map[string]interface{}{"name": "bucket", "labels": map[string]interface{}{"team": "a"}},
+			"spec":     map[string]interface{}{"region": "eu-west-1", "data": map[string]interface{}{"app.config": "x"}},
+			"status":   map[string]interface{}{"phase": "Ready"},
+			"data":     map[string]interface{}{"a": "b"},
+		}
+	}
+	cases := map[string]struct {
+		reason string
+		depth  int
+		want   map[string]string
+	}{
+		"Leaves": {
+			reason: "Owners should be recorded per leaf for additions and changes, unchanged leaves keeping the owner of the field recorded before.",
+			want: map[string]string{
+				"metadata.name":         "base",
+				"metadata.labels.team":  "base",
+				"spec.region":           "base",
+				"spec.data[app.config]": "kcl",
+				"status.phase":          "base",
+				"data.a":                "kcl",
+			},
+		},
+		"Depth": {
+			reason: "Owners should be recorded per field path cut to the depth, for additions, changes and deletions alike.",
+			depth:  2,
+			want: map[string]string{
+				"metadata.name":   "base",
+				"metadata.labels": "base",
+				"spec.region":     "base",
+				"spec.data":       "kcl",
+				"status.phase":    "base",
+				"data.a":          "kcl",
+			},
+		},
+		"TopLevel": {
+			reason: "A deletion should make the step the owner of the field it is part of.",
+			depth:  1,
+			want:   map[string]string{"spec": "kcl", "status": "kcl", "data": "kcl"},
+		},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			a := after()
+			if err := RecordFieldOwners(before(), a, "kcl", tc.depth); err != nil {
+				t.Fatal(err)
+			}
+			got, err := FieldOwners(a)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(tc.want, got); diff != "" {
+				t.Errorf("%s\nRecordFieldOwners(...): -want, +got:\n%s", tc.reason, diff)
+			}
+		})
+	}
+}
+
+func TestRecordFieldOwnersUnchanged(t *testing.T) {
+	obj := func() map[string]interface{} {
+		return map[string]interface{}{"metadata": map[string]interface{}{"name": "bucket"}, "spec": map[string]interface{}{"region": "eu-west-1"}}
+	}
+	got := obj()
+	if err := RecordFieldOwners(obj(), got, "kcl", 0); err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(obj(), got); diff != "" {
+		t.Errorf("RecordFieldOwners(...): want a resource the step did not change left as it is, -want, +got:\n%s", diff)
+	}
+}
@@ -0,0 +1,142 @@
+package resource
+
+import (
+	"path"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// ResourceDefaults is a partial object merged under every output object it
+// selects, e.g. to set the providerConfigRef of every managed resource.
+type ResourceDefaults struct {
+	// APIVersion selects objects by apiVersion, as a path.Match pattern, e.g.
+	// *.aws.upbound.io/*. Empty matches any apiVersion.
+	APIVersion string
+	// Kind selects objects by kind, as a path.Match pattern.
+	Kind string
+	// Name selects objects by metadata.name, as a path.Match pattern.
+	Name string
+	// MatchLabels selects objects with all of these labels.
+	MatchLabels map[string]string
+	// Values is the partial object.
+	Values map[string]interface{}
+}
+
+// Matches reports whether d selects u. Patterns are assumed to be valid.
+func (d ResourceDefaults) Matches(u *unstructured.Unstructured) bool {
+	return ObjectSelector{APIVersion: d.APIVersion, Kind: d.Kind, Name: d.Name, MatchLabels: d.MatchLabels}.Matches(u)
+}
+
+// ObjectSelector selects output objects. An object must match every field
+// that is set.
+type ObjectSelector struct {
+	// APIVersion selects objects by apiVersion, as a path.Match pattern.
+	APIVersion string
+	// Kind selects objects by kind, as a path.Match pattern.
+	Kind string
+	// Name selects objects by metadata.name, as a path.Match pattern.
+	Name string
+	// MatchLabels selects objects with all of these labels.
+	MatchLabels map[string]string
+}
+
+// Matches reports whether s selects u. Patterns are assumed to be valid.
+func (s ObjectSelector) Matches(u *unstructured.Unstructured) bool {
+	for _, m := range [][2]string{{s.APIVersion, u.GetAPIVersion()}, {s.Kind, u.GetKind()}, {s.Name, u.GetName()}} {
+		if m[0] == "" {
+			continue
+		}
+		if ok, _ := path.Match(m[0], m[1]); !ok {
+			return false
+		}
+	}
+	labels := u.GetLabels()
+	for k, v := range s.MatchLabels {
+		if l, ok := labels[k]; !ok || l != v {
+			return false
+		}
+	}
+	return true
+}
+
+// ApplyDefaults merges the values of every entry of defaults that selects u
+// under u: objects are merged recursively, and any value u sets, lists
+// included, takes precedence. When several entries set the same field, the
+// later entry wins. Entries are selected by u as it was output, so defaults
+// are applied before u is wrapped into a provider-kubernetes Object and
+// before XR metadata is propagated to it.
+func ApplyDefaults(defaults []ResourceDefaults, u *unstructured.Unstructured) {
+	mergeDefaults(defaults, selectDefaults(defaults, u), u)
+}
+
+// selectDefaults reports whether each entry of defaults selects u.
+func selectDefaults(defaults []ResourceDefaults, u *unstructured.Unstructured) []bool {
+	selected := make([]bool, len(defaults))
+	for i, d := range defaults {
+		selected[i] = d.Matches(u)
+	}
+	return selected
+}
+
+// mergeDefaults merges the selected entries of defaults under u, the later
+// entry winning.
+func mergeDefaults(defaults []ResourceDefaults, selected []bool, u *unstructured.Unstructured) {
+	for i := len(defaults) - 1; i >= 0; i-- {
+		if selected[i] {
+			mergeUnder(u.Object, defaults[i].Values)
+		}
+	}
+}
+
+// applyWrappedDefaults applies defaults to u and then wraps it into a
+// provider-kubernetes Object when k selects it. Entries that select the Object
+// but not u, e.g. by kind: Object, are then merged under the Object.
+func applyWrappedDefaults(defaults []ResourceDefaults, k *KubernetesObjects, u *unstructured.Unstructured, naming NamingStrategy) error {
+	selected := selectDefaults(defaults, u)
+	mergeDefaults(defaults, selected, u)
+	wrapped, err := WrapKubernetesObject(k, u, naming)
+	if err != nil || !wrapped {
+		return err
+	}
+	wrapper := selectDefaults(defaults, u)
+	for i := range wrapper {
+		wrapper[i] = wrapper[i] && !selected[i]
+	}
+	mergeDefaults(defaults, wrapper, u)
+	return nil
+}
+
+// mergeUnder sets every field of defaults that dst does not set.
+func mergeUnder(dst, defaults map[string]interface{}) {
+	for k, dv := range defaults {
+		v, exists := dst[k]
+		if !exists {
+			dst[k] = runtime.DeepCopyJSONValue(dv)
+			continue
+		}
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		if dm, ok := dv.(map[string]interface{}); ok {
+			mergeUnder(m, dm)
+		}
+	}
+}
+
+// composedObjects returns the objects of data that become composed resources,
+// skipping meta resources and the XR.
+func composedObjects(data []unstructured.Unstructured, xr *unstructured.Unstructured) []*unstructured.Unstructured {
+	var out []*unstructured.Unstructured
+	for i := range data {
+		if data[i].GetAPIVersion() == MetaApiVersion {
+			continue
+		}
+		if xr != nil && data[i].GetAPIVersion() == xr.GetAPIVersion() && data[i].GetKind() == xr.GetKind() {
+			continue
+		}
+		out = append(out, &data[i])
+	}
+	return out
+}
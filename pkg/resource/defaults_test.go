@@ -0,0 +1,130 @@
+package resource
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func TestApplyDefaults(t *testing.T) {
+	providerConfig := ResourceDefaults{
+		APIVersion: "*.aws.upbound.io/*",
+		Values: map[string]interface{}{
+			"metadata": map[string]interface{}{"labels": map[string]interface{}{"team": "platform"}},
+			"spec": map[string]interface{}{
+				"deletionPolicy":    "Orphan",
+				"providerConfigRef": map[string]interface{}{"name": "default"},
+			},
+		},
+	}
+	cases := map[string]struct {
+		reason   string
+		defaults []ResourceDefaults
+		obj      map[string]interface{}
+		want     map[string]interface{}
+	}{
+		"MergedUnder": {
+			reason:   "Defaults should fill the fields the object does not set, keeping the fields it does.",
+			defaults: []ResourceDefaults{providerConfig},
+			obj: map[string]interface{}{
+				"apiVersion": "s3.aws.upbound.io/v1beta1",
+				"kind":       "Bucket",
+				"metadata":   map[string]interface{}{"name": "logs", "labels": map[string]interface{}{"team": "data"}},
+				"spec":       map[string]interface{}{"deletionPolicy": "Delete"},
+			},
+			want: map[string]interface{}{
+				"apiVersion": "s3.aws.upbound.io/v1beta1",
+				"kind":       "Bucket",
+				"metadata":   map[string]interface{}{"name": "logs", "labels": map[string]interface{}{"team": "data"}},
+				"spec": map[string]interface{}{
+					"deletionPolicy":    "Delete",
+					"providerConfigRef": map[string]interface{}{"name": "default"},
+				},
+			},
+		},
+		"NotSelected": {
+			reason:   "Defaults should not apply to objects their selector does not select.",
+			defaults: []ResourceDefaults{providerConfig},
+			obj: map[string]interface{}{
+				"apiVersion": "v1",
+				"kind":       "ConfigMap",
+				"metadata":   map[string]interface{}{"name": "logs"},
+			},
+			want: map[string]interface{}{
+				"apiVersion": "v1",
+				"kind":       "ConfigMap",
+				"metadata":   map[string]interface{}{"name": "logs"},
+			},
+		},
+		"Labels": {
+			reason: "Defaults should only apply to objects with all of their labels.",
+			defaults: []ResourceDefaults{
+				{MatchLabels: map[string]string{"tier": "debug"}, Values: map[string]interface{}{"spec": map[string]interface{}{"deletionPolicy": "Delete"}}},
+				{MatchLabels: map[string]string{"tier": "prod"}, Values: map[string]interface{}{"spec": map[string]interface{}{"deletionPolicy": "Orphan"}}},
+			},
+			obj: map[string]interface{}{
+				"kind":     "Bucket",
+				"metadata": map[string]interface{}{"name": "logs", "labels": map[string]interface{}{"tier": "prod"}},
+			},
+			want: map[string]interface{}{
+				"kind":     "Bucket",
+				"metadata": map[string]interface{}{"name": "logs", "labels": map[string]interface{}{"tier": "prod"}},
+				"spec":     map[string]interface{}{"deletionPolicy": "Orphan"},
+			},
+		},
+		"LaterWins": {
+			reason: "When several defaults set the same field the later one should win.",
+			defaults: []ResourceDefaults{
+				{Values: map[string]interface{}{"spec": map[string]interface{}{"deletionPolicy": "Delete", "managementPolicies": []interface{}{"*"}}}},
+				{Kind: "Bucket", Values: map[string]interface{}{"spec": map[string]interface{}{"deletionPolicy": "Orphan"}}},
+			},
+			obj: map[string]interface{}{
+				"kind":     "Bucket",
+				"metadata": map[string]interface{}{"name": "logs"},
+			},
+			want: map[string]interface{}{
+				"kind":     "Bucket",
+				"metadata": map[string]interface{}{"name": "logs"},
+				"spec":     map[string]interface{}{"deletionPolicy": "Orphan", "managementPolicies": []interface{}{"*"}},
+			},
+		},
+		"ListsNotMerged": {
+			reason: "A list the object sets should be kept as it is.",
+			defaults: []ResourceDefaults{
+				{Values: map[string]interface{}{"spec": map[string]interface{}{"managementPolicies": []interface{}{"*"}}}},
+			},
+			obj: map[string]interface{}{
+				"kind": "Bucket",
+				"spec": map[string]interface{}{"managementPolicies": []interface{}{"Observe"}},
+			},
+			want: map[string]interface{}{
+				"kind": "Bucket",
+				"spec": map[string]interface{}{"managementPolicies": []interface{}{"Observe"}},
+			},
+		},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			u := &unstructured.Unstructured{Object: tc.obj}
+			ApplyDefaults(tc.defaults, u)
+			if diff := cmp.Diff(tc.want, u.Object); diff != "" {
+				t.Errorf("%s\nApplyDefaults(...): -want, +got:\n%s", tc.reason, diff)
+			}
+		})
+	}
+}
+
+func TestApplyDefaultsCopiesValues(t *testing.T) {
+	d := ResourceDefaults{Values: map[string]interface{}{"spec": map[string]interface{}{"providerConfigRef": map[string]interface{}{"name": "default"}}}}
+	a := &unstructured.Unstructured{Object: map[string]interface{}{"kind": "Bucket"}}
+	b := &unstructured.Unstructured{Object: map[string]interface{}{"kind": "Bucket"}}
+	ApplyDefaults([]ResourceDefaults{d}, a)
+	ApplyDefaults([]ResourceDefaults{d}, b)
+	if err := unstructured.SetNestedField(a.Object, "other", "spec", "providerConfigRef", "name"); err != nil {
+		t.Fatal(err)
+	}
+	if name, _, _ := unstructured.NestedString(b.Object, "spec", "providerConfigRef", "name"); name != "default" {
+		t.Errorf("objects should not share default values, got providerConfigRef %q", name)
+	}
+}
@@ -0,0 +1,60 @@
+package resource
+
+import (
+	"github.com/crossplane/function-sdk-go/resource"
+	"github.com/pkg/errors"
+)
+
+// DeleteSelectors are the selectors of a DeleteResources meta resource.
+type DeleteSelectors []DeleteSelector
+
+// DeleteSelector selects desired composed resources to delete. A resource is
+// selected when it matches every field that is set.
+type DeleteSelector struct {
+	// Name is the composition resource name of the resource.
+	Name string `json:"name,omitempty"`
+	// APIVersion of the resource.
+	APIVersion string `json:"apiVersion,omitempty"`
+	// Kind of the resource.
+	Kind string `json:"kind,omitempty"`
+	// MatchLabels are labels the resource must have.
+	MatchLabels map[string]string `json:"matchLabels,omitempty"`
+}
+
+// matches reports whether cd, named name, is selected by s.
+func (s DeleteSelector) matches(name resource.Name, cd *resource.DesiredComposed) bool {
+	if s.Name != "" && resource.Name(s.Name) != name {
+		return false
+	}
+	if s.APIVersion != "" && s.APIVersion != cd.Resource.GetAPIVersion() {
+		return false
+	}
+	if s.Kind != "" && s.Kind != cd.Resource.GetKind() {
+		return false
+	}
+	labels := cd.Resource.GetLabels()
+	for k, v := range s.MatchLabels {
+		if l, ok := labels[k]; !ok || l != v {
+			return false
+		}
+	}
+	return true
+}
+
+// DeleteResources deletes the desired composed resources that any of the
+// selectors select, including those set by earlier steps of the pipeline. A
+// selector that selects nothing is not an error, so that KCL code can veto a
+// resource whether or not an earlier step set it.
+func DeleteResources(selectors DeleteSelectors, desired map[resource.Name]*resource.DesiredComposed) error {
+	for i, s := range selectors {
+		if s.Name == "" && s.APIVersion == "" && s.Kind == "" && len(s.MatchLabels) == 0 {
+			return errors.Errorf("selectors[%d]: at least one of name, apiVersion, kind and matchLabels must be set", i)
+		}
+		for name, cd := range desired {
+			if s.matches(name, cd) {
+				delete(desired, name)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,80 @@
+package resource
+
+import (
+	"sort"
+	"testing"
+
+	res "github.com/crossplane/function-sdk-go/resource"
+	"github.com/crossplane/function-sdk-go/resource/composed"
+	"github.com/google/go-cmp/cmp"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func TestDeleteResources(t *testing.T) {
+	cd := func(apiVersion, kind string, labels map[string]interface{}) *res.DesiredComposed {
+		return &res.DesiredComposed{Resource: &composed.Unstructured{Unstructured: unstructured.Unstructured{Object: map[string]interface{}{
+			"apiVersion": apiVersion,
+			"kind":       kind,
+			"metadata":   map[string]interface{}{"labels": labels},
+		}}}}
+	}
+	cases := map[string]struct {
+		reason    string
+		selectors DeleteSelectors
+		want      []string
+		wantErr   bool
+	}{
+		"Name": {
+			reason:    "A name should select the resource with that composition resource name.",
+			selectors: DeleteSelectors{{Name: "bucket"}},
+			want:      []string{"policy", "queue"},
+		},
+		"GVK": {
+			reason:    "An apiVersion and kind should select every resource of that type.",
+			selectors: DeleteSelectors{{APIVersion: "s3.aws.upbound.io/v1beta1", Kind: "BucketPolicy"}},
+			want:      []string{"bucket", "queue"},
+		},
+		"Labels": {
+			reason:    "Labels should select every resource that has them.",
+			selectors: DeleteSelectors{{MatchLabels: map[string]string{"tier": "debug"}}},
+			want:      []string{"bucket"},
+		},
+		"AllFields": {
+			reason:    "A resource should only be selected when it matches every field.",
+			selectors: DeleteSelectors{{Kind: "Bucket", MatchLabels: map[string]string{"tier": "debug"}}},
+			want:      []string{"bucket", "policy", "queue"},
+		},
+		"Missing": {
+			reason:    "A selector that selects nothing should not be an error.",
+			selectors: DeleteSelectors{{Name: "topic"}},
+			want:      []string{"bucket", "policy", "queue"},
+		},
+		"Empty": {
+			reason:    "An empty selector should be rejected rather than delete everything.",
+			selectors: DeleteSelectors{{}},
+			want:      []string{"bucket", "policy", "queue"},
+			wantErr:   true,
+		},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			desired := map[res.Name]*res.DesiredComposed{
+				"bucket": cd("s3.aws.upbound.io/v1beta1", "Bucket", nil),
+				"policy": cd("s3.aws.upbound.io/v1beta1", "BucketPolicy", map[string]interface{}{"tier": "debug"}),
+				"queue":  cd("sqs.aws.upbound.io/v1beta1", "Queue", map[string]interface{}{"tier": "debug"}),
+			}
+			err := DeleteResources(tc.selectors, desired)
+			if (err != nil) != tc.wantErr {
+				t.Errorf("%s\nDeleteResources(...): error = %v, wantErr %v", tc.reason, err, tc.wantErr)
+			}
+			got := make([]string, 0, len(desired))
+			for n := range desired {
+				got = append(got, string(n))
+			}
+			sort.Strings(got)
+			if diff := cmp.Diff(tc.want, got); diff != "" {
+				t.Errorf("%s\nDeleteResources(...): -want, +got:\n%s", tc.reason, diff)
+			}
+		})
+	}
+}
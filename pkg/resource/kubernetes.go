@@ -0,0 +1,135 @@
+package resource
+
+import (
+	"strings"
+
+	"github.com/crossplane/function-sdk-go/resource"
+	"github.com/pkg/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// AnnotationKeyKubernetesObject set to "true" wraps an output object into a
+// provider-kubernetes Object, and set to "false" keeps it from being wrapped.
+const AnnotationKeyKubernetesObject = "krm.kcl.dev/kubernetes-object"
+
+const (
+	// KubernetesObjectAPIVersion is the default apiVersion of
+	// provider-kubernetes Objects.
+	KubernetesObjectAPIVersion = "kubernetes.crossplane.io/v1alpha2"
+	// KubernetesObjectKind is the kind of provider-kubernetes Objects.
+	KubernetesObjectKind = "Object"
+)
+
+// KubernetesObjects wraps the output objects it selects into provider-kubernetes
+// Objects, so that plain Kubernetes manifests can be composed into a remote
+// cluster.
+type KubernetesObjects struct {
+	// APIVersion is the apiVersion of the Objects, KubernetesObjectAPIVersion
+	// if empty.
+	APIVersion string
+	// Selectors select the objects to wrap, in addition to those annotated
+	// with krm.kcl.dev/kubernetes-object: "true".
+	Selectors []ObjectSelector
+	// ProviderConfigName is the provider config of the Objects.
+	ProviderConfigName string
+	// ManagementPolicies are the management policies of the Objects.
+	ManagementPolicies []string
+	// ReadinessPolicy is the readiness policy of the Objects, e.g.
+	// DeriveFromObject.
+	ReadinessPolicy string
+}
+
+// apiVersion returns the apiVersion of the Objects.
+func (k *KubernetesObjects) apiVersion() string {
+	if k == nil || k.APIVersion == "" {
+		return KubernetesObjectAPIVersion
+	}
+	return k.APIVersion
+}
+
+// selects reports whether u is to be wrapped.
+func (k *KubernetesObjects) selects(u *unstructured.Unstructured) (bool, error) {
+	if v, found := u.GetAnnotations()[AnnotationKeyKubernetesObject]; found {
+		switch v {
+		case "true", "false":
+			return v == "true", nil
+		default:
+			return false, errors.Errorf("invalid %q annotation value %q on %s: must be true or false", AnnotationKeyKubernetesObject, v, describeObject(u))
+		}
+	}
+	if k == nil {
+		return false, nil
+	}
+	for _, s := range k.Selectors {
+		if s.Matches(u) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// WrapKubernetesObject replaces u with a provider-kubernetes Object whose
+// manifest is u, when u is selected. The composition resource name u would
+// have, and its krm.kcl.dev/ready annotation, are moved to the Object, so that
+// it takes the place of u in the desired composed resources. k may be nil, in
+// which case only annotated objects are wrapped, with the provider defaults.
+func WrapKubernetesObject(k *KubernetesObjects, u *unstructured.Unstructured, naming NamingStrategy) (bool, error) {
+	if group := apiGroup(u.GetAPIVersion()); group == apiGroup(KubernetesObjectAPIVersion) || group == apiGroup(k.apiVersion()) {
+		return false, nil
+	}
+	wrap, err := k.selects(u)
+	removeAnnotation(u, AnnotationKeyKubernetesObject)
+	if err != nil || !wrap {
+		return false, err
+	}
+
+	cd := resource.NewDesiredComposed()
+	cd.Resource.Unstructured = *u
+	name := ResourceName(cd, naming)
+	if name == "" {
+		return false, errors.Errorf("cannot wrap %s into a %s: it has no name", describeObject(u), KubernetesObjectKind)
+	}
+	annotations := map[string]string{AnnotationKeyCompositionResourceName: name}
+	if v, found := u.GetAnnotations()[AnnotationKeyReady]; found {
+		annotations[AnnotationKeyReady] = v
+	}
+	// The manifest keeps no annotations of its own, not even an empty object.
+	removeAnnotation(u, AnnotationKeyCompositionResourceName)
+	removeAnnotation(u, AnnotationKeyReady)
+
+	spec := map[string]interface{}{
+		"forProvider": map[string]interface{}{"manifest": u.Object},
+	}
+	if k != nil {
+		if k.ProviderConfigName != "" {
+			spec["providerConfigRef"] = map[string]interface{}{"name": k.ProviderConfigName}
+		}
+		if len(k.ManagementPolicies) > 0 {
+			policies := make([]interface{}, len(k.ManagementPolicies))
+			for i, p := range k.ManagementPolicies {
+				policies[i] = p
+			}
+			spec["managementPolicies"] = policies
+		}
+		if k.ReadinessPolicy != "" {
+			spec["readiness"] = map[string]interface{}{"policy": k.ReadinessPolicy}
+		}
+	}
+	wrapper := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": k.apiVersion(),
+		"kind":       KubernetesObjectKind,
+		"spec":       spec,
+	}}
+	wrapper.SetAnnotations(annotations)
+	u.Object = wrapper.Object
+	return true, nil
+}
+
+// apiGroup returns the group of apiVersion, which is empty for the core group.
+func apiGroup(apiVersion string) string {
+	group, _, found := strings.Cut(apiVersion, "/")
+	if !found {
+		return ""
+	}
+	return group
+}
@@ -0,0 +1,238 @@
+package resource
+
+import (
+	"testing"
+
+	fnv1 "github.com/crossplane/function-sdk-go/proto/v1"
+	res "github.com/crossplane/function-sdk-go/resource"
+	"github.com/crossplane/function-sdk-go/resource/composite"
+	"github.com/google/go-cmp/cmp"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func TestWrapKubernetesObject(t *testing.T) {
+	configMap := func(annotations map[string]interface{}) map[string]interface{} {
+		metadata := map[string]interface{}{"name": "settings", "namespace": "apps"}
+		if annotations != nil {
+			metadata["annotations"] = annotations
+		}
+		return map[string]interface{}{
+			"apiVersion": "v1",
+			"kind":       "ConfigMap",
+			"metadata":   metadata,
+			"data":       map[string]interface{}{"debug": "true"},
+		}
+	}
+	cases := map[string]struct {
+		reason      string
+		k           *KubernetesObjects
+		obj         map[string]interface{}
+		want        map[string]interface{}
+		wantWrapped bool
+		wantErr     bool
+	}{
+		"Selected": {
+			reason: "A selected object should be wrapped with the configured provider config and policies.",
+			k: &KubernetesObjects{
+				Selectors:          []ObjectSelector{{APIVersion: "v1", Kind: "ConfigMap"}},
+				ProviderConfigName: "remote",
+				ManagementPolicies: []string{"Observe", "Create"},
+				ReadinessPolicy:    "DeriveFromObject",
+			},
+			obj: configMap(nil),
+			want: map[string]interface{}{
+				"apiVersion": KubernetesObjectAPIVersion,
+				"kind":       KubernetesObjectKind,
+				"metadata": map[string]interface{}{
+					"annotations": map[string]interface{}{AnnotationKeyCompositionResourceName: "settings"},
+				},
+				"spec": map[string]interface{}{
+					"forProvider":        map[string]interface{}{"manifest": configMap(nil)},
+					"providerConfigRef":  map[string]interface{}{"name": "remote"},
+					"managementPolicies": []interface{}{"Observe", "Create"},
+					"readiness":          map[string]interface{}{"policy": "DeriveFromObject"},
+				},
+			},
+			wantWrapped: true,
+		},
+		"Annotated": {
+			reason: "An annotated object should be wrapped without a config, keeping its composition resource name and readiness on the wrapper.",
+			obj: configMap(map[string]interface{}{
+				AnnotationKeyKubernetesObject:        "true",
+				AnnotationKeyCompositionResourceName: "app-settings",
+				AnnotationKeyReady:                   "True",
+			}),
+			want: map[string]interface{}{
+				"apiVersion": KubernetesObjectAPIVersion,
+				"kind":       KubernetesObjectKind,
+				"metadata": map[string]interface{}{
+					"annotations": map[string]interface{}{
+						AnnotationKeyCompositionResourceName: "app-settings",
+						AnnotationKeyReady:                   "True",
+					},
+				},
+				"spec": map[string]interface{}{
+					"forProvider": map[string]interface{}{"manifest": configMap(nil)},
+				},
+			},
+			wantWrapped: true,
+		},
+		"APIVersion": {
+			reason: "A selected object should be wrapped into an Object of the configured apiVersion.",
+			k: &KubernetesObjects{
+				APIVersion: "kubernetes.m.crossplane.io/v1alpha1",
+				Selectors:  []ObjectSelector{{Kind: "ConfigMap"}},
+			},
+			obj: configMap(nil),
+			want: map[string]interface{}{
+				"apiVersion": "kubernetes.m.crossplane.io/v1alpha1",
+				"kind":       KubernetesObjectKind,
+				"metadata": map[string]interface{}{
+					"annotations": map[string]interface{}{AnnotationKeyCompositionResourceName: "settings"},
+				},
+				"spec": map[string]interface{}{
+					"forProvider": map[string]interface{}{"manifest": configMap(nil)},
+				},
+			},
+			wantWrapped: true,
+		},
+		"AlreadyAnObject": {
+			reason: "An Object of the configured apiVersion should not be wrapped again.",
+			k: &KubernetesObjects{
+				APIVersion: "kubernetes.m.crossplane.io/v1alpha1",
+				Selectors:  []ObjectSelector{{Name: "settings"}},
+			},
+			obj:  map[string]interface{}{"apiVersion": "kubernetes.m.crossplane.io/v1alpha1", "kind": "Object", "metadata": map[string]interface{}{"name": "settings"}},
+			want: map[string]interface{}{"apiVersion": "kubernetes.m.crossplane.io/v1alpha1", "kind": "Object", "metadata": map[string]interface{}{"name": "settings"}},
+		},
+		"OptedOut": {
+			reason: "An object annotated with false should not be wrapped even when selected.",
+			k:      &KubernetesObjects{Selectors: []ObjectSelector{{Kind: "ConfigMap"}}},
+			obj:    configMap(map[string]interface{}{AnnotationKeyKubernetesObject: "false"}),
+			want:   configMap(nil),
+		},
+		"NotSelected": {
+			reason: "An object that is neither selected nor annotated should be left as it is.",
+			k:      &KubernetesObjects{Selectors: []ObjectSelector{{Kind: "Deployment"}}},
+			obj:    configMap(nil),
+			want:   configMap(nil),
+		},
+		"InvalidAnnotation": {
+			reason:  "An annotation value other than true or false should be rejected.",
+			obj:     configMap(map[string]interface{}{AnnotationKeyKubernetesObject: "yes"}),
+			want:    configMap(nil),
+			wantErr: true,
+		},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			u := &unstructured.Unstructured{Object: tc.obj}
+			wrapped, err := WrapKubernetesObject(tc.k, u, NameStrategy)
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("%s\nWrapKubernetesObject(...): want error %t, got %v", tc.reason, tc.wantErr, err)
+			}
+			if wrapped != tc.wantWrapped {
+				t.Errorf("%s\nWrapKubernetesObject(...): want wrapped %t, got %t", tc.reason, tc.wantWrapped, wrapped)
+			}
+			if diff := cmp.Diff(tc.want, u.Object); diff != "" {
+				t.Errorf("%s\nWrapKubernetesObject(...): -want, +got:\n%s", tc.reason, diff)
+			}
+		})
+	}
+}
+
+func TestProcessResourcesPropagatesToKubernetesObjects(t *testing.T) {
+	dxr := &res.Composite{Resource: composite.New(), ConnectionDetails: res.ConnectionDetails{}}
+	oxr := &res.Composite{Resource: composite.New()}
+	oxr.Resource.SetAPIVersion("example.org/v1")
+	oxr.Resource.SetKind("XR")
+	oxr.Resource.SetLabels(map[string]string{"cost-center": "1234"})
+	desired := map[res.Name]*res.DesiredComposed{}
+	data := []unstructured.Unstructured{{Object: map[string]interface{}{
+		"apiVersion": "v1",
+		"kind":       "ConfigMap",
+		"metadata":   map[string]interface{}{"name": "settings", "labels": map[string]interface{}{"app": "web"}},
+	}}}
+
+	opts := &AddResourcesOptions{
+		Data:              data,
+		Overwrite:         true,
+		Propagation:       &PropagationPolicy{Labels: []string{"cost-center"}},
+		Defaults:          []ResourceDefaults{{Kind: KubernetesObjectKind, Values: map[string]interface{}{"spec": map[string]interface{}{"deletionPolicy": "Orphan"}}}},
+		KubernetesObjects: &KubernetesObjects{Selectors: []ObjectSelector{{Kind: "ConfigMap"}}},
+	}
+	if _, err := ProcessResources(dxr, oxr, desired, nil, map[string]*fnv1.ResourceSelector{}, map[string]*fnv1.ResourceSelector{}, &ConditionResources{}, &EventResources{}, &map[string]interface{}{}, Resources, nil, opts); err != nil {
+		t.Fatalf("ProcessResources(...): unexpected error %v", err)
+	}
+	cd, ok := desired["settings"]
+	if !ok {
+		t.Fatal("ProcessResources(...): want the wrapped settings Object")
+	}
+	// The wrapper, not the manifest, gets the labels of the XR and the defaults.
+	if diff := cmp.Diff(map[string]string{"cost-center": "1234"}, cd.Resource.GetLabels()); diff != "" {
+		t.Errorf("ProcessResources(...): -want Object labels, +got:\n%s", diff)
+	}
+	if got, _ := cd.Resource.GetString("spec.deletionPolicy"); got != "Orphan" {
+		t.Errorf("ProcessResources(...): want the defaults merged under the Object, got deletionPolicy %q", got)
+	}
+	manifest, _, _ := unstructured.NestedStringMap(cd.Resource.Object, "spec", "forProvider", "manifest", "metadata", "labels")
+	if diff := cmp.Diff(map[string]string{"app": "web"}, manifest); diff != "" {
+		t.Errorf("ProcessResources(...): -want manifest labels, +got:\n%s", diff)
+	}
+}
+
+func TestProcessResourcesAppliesDefaultsToKubernetesObjects(t *testing.T) {
+	dxr := &res.Composite{Resource: composite.New(), ConnectionDetails: res.ConnectionDetails{}}
+	oxr := &res.Composite{Resource: composite.New()}
+	oxr.Resource.SetAPIVersion("example.org/v1")
+	oxr.Resource.SetKind("XR")
+	oxr.Resource.SetLabels(map[string]string{"cost-center": "1234"})
+	desired := map[res.Name]*res.DesiredComposed{}
+	data := []unstructured.Unstructured{{Object: map[string]interface{}{
+		"apiVersion": "s3.aws.upbound.io/v1beta1",
+		"kind":       "Bucket",
+		"metadata":   map[string]interface{}{"name": "bucket"},
+	}}}
+
+	opts := &AddResourcesOptions{
+		Data:        data,
+		Overwrite:   true,
+		Propagation: &PropagationPolicy{Labels: []string{"cost-center"}},
+		Defaults: []ResourceDefaults{
+			{Kind: "Bucket", Values: map[string]interface{}{"spec": map[string]interface{}{"forProvider": map[string]interface{}{"region": "us-east-1"}}}},
+			{Kind: KubernetesObjectKind, Values: map[string]interface{}{"spec": map[string]interface{}{"deletionPolicy": "Orphan"}}},
+			{Values: map[string]interface{}{"metadata": map[string]interface{}{"labels": map[string]interface{}{"team": "platform"}}}},
+			{MatchLabels: map[string]string{"cost-center": "1234"}, Values: map[string]interface{}{"metadata": map[string]interface{}{"labels": map[string]interface{}{"propagated": "true"}}}},
+		},
+		KubernetesObjects: &KubernetesObjects{Selectors: []ObjectSelector{{Kind: "Bucket"}}},
+	}
+	if _, err := ProcessResources(dxr, oxr, desired, nil, map[string]*fnv1.ResourceSelector{}, map[string]*fnv1.ResourceSelector{}, &ConditionResources{}, &EventResources{}, &map[string]interface{}{}, Resources, nil, opts); err != nil {
+		t.Fatalf("ProcessResources(...): unexpected error %v", err)
+	}
+	cd, ok := desired["bucket"]
+	if !ok {
+		t.Fatal("ProcessResources(...): want the wrapped bucket Object")
+	}
+	// Defaults select the Bucket as it was output and are merged under the
+	// manifest; only the one selecting the Object itself is merged under it.
+	want := map[string]interface{}{
+		"apiVersion": KubernetesObjectAPIVersion,
+		"kind":       KubernetesObjectKind,
+		"metadata": map[string]interface{}{
+			"annotations": map[string]interface{}{},
+			"labels":      map[string]interface{}{"cost-center": "1234"},
+		},
+		"spec": map[string]interface{}{
+			"deletionPolicy": "Orphan",
+			"forProvider": map[string]interface{}{"manifest": map[string]interface{}{
+				"apiVersion": "s3.aws.upbound.io/v1beta1",
+				"kind":       "Bucket",
+				"metadata":   map[string]interface{}{"name": "bucket", "labels": map[string]interface{}{"team": "platform"}},
+				"spec":       map[string]interface{}{"forProvider": map[string]interface{}{"region": "us-east-1"}},
+			}},
+		},
+	}
+	if diff := cmp.Diff(want, cd.Resource.Object); diff != "" {
+		t.Errorf("ProcessResources(...): -want, +got:\n%s", diff)
+	}
+}
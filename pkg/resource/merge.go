@@ -0,0 +1,140 @@
+package resource
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/crossplane/crossplane-runtime/v2/pkg/fieldpath"
+	"github.com/pkg/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// protectedFields identify the object a patch is applied to, so a patch never
+// changes or deletes them.
+var protectedFields = []string{"apiVersion", "kind", "metadata.name"}
+
+// MergePatch applies patch to dst with JSON merge patch (RFC 7386) semantics:
+// objects are merged recursively, a null deletes the field it is set on, and
+// any other value, lists included, replaces the value in dst. A list whose
+// field path has a merge key in mergeKeys, e.g. spec.containers: name, is
+// instead merged element by element, matching the elements of dst that have
+// the same value for the key and appending the others. When overwrite is
+// false, changing or deleting an existing value is an error.
+//
+// dst is modified in place, apart from its lists and their elements, which
+// are copied before they are merged. The protected apiVersion, kind and metadata.name fields of
+// dst are left as they are.
+func MergePatch(dst, patch map[string]interface{}, mergeKeys map[string]string, overwrite bool) error {
+	keys := make(map[string]string, len(mergeKeys))
+	for p, k := range mergeKeys {
+		segs, err := fieldpath.Parse(p)
+		if err != nil {
+			return errors.Wrapf(err, "cannot parse list merge key path %q", p)
+		}
+		keys[segs.String()] = k
+	}
+	m := merger{keys: keys, overwrite: overwrite}
+	return m.mergeObject(dst, patch, nil)
+}
+
+type merger struct {
+	keys      map[string]string
+	overwrite bool
+}
+
+func (m merger) mergeObject(dst, patch map[string]interface{}, path fieldpath.Segments) error {
+	for k, pv := range patch {
+		p := append(path[:len(path):len(path)], fieldpath.Field(k))
+		if protected(p.String(), pv) {
+			continue
+		}
+		dv, exists := dst[k]
+		if pv == nil {
+			if exists && !m.overwrite {
+				return fmt.Errorf("%s: cannot delete %v", p, dv)
+			}
+			delete(dst, k)
+			continue
+		}
+		v, err := m.mergeValue(dv, exists, pv, p)
+		if err != nil {
+			return err
+		}
+		dst[k] = v
+	}
+	return nil
+}
+
+func (m merger) mergeValue(dv interface{}, exists bool, pv interface{}, path fieldpath.Segments) (interface{}, error) {
+	switch p := pv.(type) {
+	case map[string]interface{}:
+		d, ok := dv.(map[string]interface{})
+		if !ok {
+			if exists && dv != nil && !m.overwrite {
+				return nil, fmt.Errorf("%s: conflicting values %v and %v", path, dv, pv)
+			}
+			d = make(map[string]interface{}, len(p))
+		}
+		return d, m.mergeObject(d, p, path)
+	case []interface{}:
+		if key, ok := m.keys[path.String()]; ok {
+			if d, ok := dv.([]interface{}); ok {
+				return m.mergeList(d, p, key, path)
+			}
+		}
+	}
+	if exists && dv != nil && !m.overwrite && !reflect.DeepEqual(dv, pv) {
+		return nil, fmt.Errorf("%s: conflicting values %v and %v", path, dv, pv)
+	}
+	return pv, nil
+}
+
+// mergeList merges the elements of patch into a copy of dst by the value of
+// key. Elements without the key are appended.
+func (m merger) mergeList(dst, patch []interface{}, key string, path fieldpath.Segments) ([]interface{}, error) {
+	out := make([]interface{}, len(dst), len(dst)+len(patch))
+	copy(out, dst)
+	for _, pv := range patch {
+		po, ok := pv.(map[string]interface{})
+		if !ok || po[key] == nil {
+			out = append(out, pv)
+			continue
+		}
+		i := indexByKey(out, key, po[key])
+		if i < 0 {
+			out = append(out, pv)
+			continue
+		}
+		// The elements of a list are deep copied before they are merged, since
+		// dst shares them, and the objects and lists in them, with the object
+		// it was read from.
+		elem := runtime.DeepCopyJSONValue(out[i]).(map[string]interface{})
+		if err := m.mergeObject(elem, po, path); err != nil {
+			return nil, err
+		}
+		out[i] = elem
+	}
+	return out, nil
+}
+
+func indexByKey(list []interface{}, key string, value interface{}) int {
+	for i, v := range list {
+		if o, ok := v.(map[string]interface{}); ok && reflect.DeepEqual(o[key], value) {
+			return i
+		}
+	}
+	return -1
+}
+
+// protected reports whether setting path to v would change a protected field:
+// the field itself, or an object it is in being deleted or replaced.
+func protected(path string, v interface{}) bool {
+	_, isObject := v.(map[string]interface{})
+	for _, f := range protectedFields {
+		if f == path || (!isObject && strings.HasPrefix(f, path+".")) {
+			return true
+		}
+	}
+	return false
+}
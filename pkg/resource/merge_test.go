@@ -0,0 +1,139 @@
+package resource
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestMergePatch(t *testing.T) {
+	type args struct {
+		dst       map[string]interface{}
+		patch     map[string]interface{}
+		mergeKeys map[string]string
+		overwrite bool
+	}
+	cases := map[string]struct {
+		reason  string
+		args    args
+		want    map[string]interface{}
+		wantErr bool
+	}{
+		"DottedKeys": {
+			reason: "Keys that contain dots should be set as they are.",
+			args: args{
+				dst:       map[string]interface{}{"data": map[string]interface{}{"a": "1"}},
+				patch:     map[string]interface{}{"data": map[string]interface{}{"app.config": "x=1"}},
+				overwrite: true,
+			},
+			want: map[string]interface{}{"data": map[string]interface{}{"a": "1", "app.config": "x=1"}},
+		},
+		"NullDeletes": {
+			reason: "A null should delete the field it is set on.",
+			args: args{
+				dst:       map[string]interface{}{"spec": map[string]interface{}{"a": "1", "b": "2"}},
+				patch:     map[string]interface{}{"spec": map[string]interface{}{"a": nil}},
+				overwrite: true,
+			},
+			want: map[string]interface{}{"spec": map[string]interface{}{"b": "2"}},
+		},
+		"ListReplaced": {
+			reason: "A list without a merge key should be replaced.",
+			args: args{
+				dst:       map[string]interface{}{"spec": map[string]interface{}{"tags": []interface{}{"a", "b"}}},
+				patch:     map[string]interface{}{"spec": map[string]interface{}{"tags": []interface{}{"c"}}},
+				overwrite: true,
+			},
+			want: map[string]interface{}{"spec": map[string]interface{}{"tags": []interface{}{"c"}}},
+		},
+		"ListMergedByKey": {
+			reason: "A list with a merge key should be merged element by element.",
+			args: args{
+				dst: map[string]interface{}{"spec": map[string]interface{}{"containers": []interface{}{
+					map[string]interface{}{"name": "app", "image": "app:1", "args": []interface{}{"-v"}},
+					map[string]interface{}{"name": "proxy", "image": "proxy:1"},
+				}}},
+				patch: map[string]interface{}{"spec": map[string]interface{}{"containers": []interface{}{
+					map[string]interface{}{"name": "app", "image": "app:2", "args": nil},
+					map[string]interface{}{"name": "sidecar", "image": "sidecar:1"},
+				}}},
+				mergeKeys: map[string]string{"spec.containers": "name"},
+				overwrite: true,
+			},
+			want: map[string]interface{}{"spec": map[string]interface{}{"containers": []interface{}{
+				map[string]interface{}{"name": "app", "image": "app:2"},
+				map[string]interface{}{"name": "proxy", "image": "proxy:1"},
+				map[string]interface{}{"name": "sidecar", "image": "sidecar:1"},
+			}}},
+		},
+		"ProtectedFields": {
+			reason: "apiVersion, kind and metadata.name should never be changed or deleted.",
+			args: args{
+				dst: map[string]interface{}{"apiVersion": "v1", "kind": "ConfigMap", "metadata": map[string]interface{}{"name": "a"}},
+				patch: map[string]interface{}{
+					"apiVersion": "v2",
+					"kind":       nil,
+					"metadata":   map[string]interface{}{"name": "b", "labels": map[string]interface{}{"app": "a"}},
+				},
+				overwrite: true,
+			},
+			want: map[string]interface{}{"apiVersion": "v1", "kind": "ConfigMap", "metadata": map[string]interface{}{"name": "a", "labels": map[string]interface{}{"app": "a"}}},
+		},
+		"Conflict": {
+			reason: "Changing an existing value should be an error without overwrite.",
+			args: args{
+				dst:   map[string]interface{}{"spec": map[string]interface{}{"a": "1"}},
+				patch: map[string]interface{}{"spec": map[string]interface{}{"a": "2"}},
+			},
+			want:    map[string]interface{}{"spec": map[string]interface{}{"a": "1"}},
+			wantErr: true,
+		},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			err := MergePatch(tc.args.dst, tc.args.patch, tc.args.mergeKeys, tc.args.overwrite)
+			if (err != nil) != tc.wantErr {
+				t.Errorf("%s\nMergePatch(...): error = %v, wantErr %v", tc.reason, err, tc.wantErr)
+			}
+			if diff := cmp.Diff(tc.want, tc.args.dst); diff != "" {
+				t.Errorf("%s\nMergePatch(...): -want, +got:\n%s", tc.reason, diff)
+			}
+		})
+	}
+}
+
+func TestMergePatchCopiesListElements(t *testing.T) {
+	app := map[string]interface{}{
+		"name":      "app",
+		"resources": map[string]interface{}{"limits": map[string]interface{}{"cpu": "1"}},
+		"args":      []interface{}{"-v"},
+	}
+	original := map[string]interface{}{"spec": map[string]interface{}{"containers": []interface{}{app}}}
+	want := map[string]interface{}{"spec": map[string]interface{}{"containers": []interface{}{map[string]interface{}{
+		"name":      "app",
+		"resources": map[string]interface{}{"limits": map[string]interface{}{"cpu": "1"}},
+		"args":      []interface{}{"-v"},
+	}}}}
+
+	// dst shares its containers with original, as a shallow copy of a desired
+	// object does.
+	dst := map[string]interface{}{"spec": map[string]interface{}{"containers": original["spec"].(map[string]interface{})["containers"]}}
+	patch := map[string]interface{}{"spec": map[string]interface{}{"containers": []interface{}{map[string]interface{}{
+		"name":      "app",
+		"resources": map[string]interface{}{"limits": map[string]interface{}{"cpu": "2", "memory": "1Gi"}},
+		"args":      nil,
+	}}}}
+	if err := MergePatch(dst, patch, map[string]string{"spec.containers": "name"}, true); err != nil {
+		t.Fatalf("MergePatch(...): %v", err)
+	}
+	if diff := cmp.Diff(want, original); diff != "" {
+		t.Errorf("MergePatch(...): the object dst was copied from changed: -want, +got:\n%s", diff)
+	}
+	got := dst["spec"].(map[string]interface{})["containers"].([]interface{})[0]
+	if diff := cmp.Diff(map[string]interface{}{
+		"name":      "app",
+		"resources": map[string]interface{}{"limits": map[string]interface{}{"cpu": "2", "memory": "1Gi"}},
+	}, got); diff != "" {
+		t.Errorf("MergePatch(...): -want, +got:\n%s", diff)
+	}
+}
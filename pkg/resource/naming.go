@@ -0,0 +1,94 @@
+package resource
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+
+	"github.com/crossplane/crossplane-runtime/v2/pkg/meta"
+	"github.com/crossplane/function-sdk-go/resource"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// NamingStrategy decides the composition resource name of an output object
+// that has no krm.kcl.dev/composition-resource-name annotation.
+type NamingStrategy string
+
+const (
+	// NameStrategy uses metadata.name.
+	NameStrategy NamingStrategy = "name"
+	// KindNameStrategy uses the kind and metadata.name, e.g. bucket-logs.
+	KindNameStrategy NamingStrategy = "kind-name"
+	// GroupKindNamespaceNameStrategy uses the API group, kind, namespace and
+	// name, e.g. s3.aws.upbound.io-bucket-team-a-logs.
+	GroupKindNamespaceNameStrategy NamingStrategy = "apiGroup-kind-namespace-name"
+	// HashStrategy uses a stable hash of the API group, kind, namespace and
+	// name, prefixed with the kind, e.g. bucket-5d41402abc4b2a76.
+	HashStrategy NamingStrategy = "hash"
+)
+
+// maxResourceNameLength is the length generated composition resource names
+// are truncated to, so that they remain valid label values.
+const maxResourceNameLength = 63
+
+// ResourceName returns the composition resource name of cd: its
+// krm.kcl.dev/composition-resource-name annotation, which is removed, or else
+// the name the strategy generates. Generated names that are too long are
+// truncated, with a hash of the whole name appended so that they stay unique.
+func ResourceName(cd *resource.DesiredComposed, strategy NamingStrategy) string {
+	if name, found := cd.Resource.GetAnnotations()[AnnotationKeyCompositionResourceName]; found {
+		meta.RemoveAnnotations(cd.Resource, AnnotationKeyCompositionResourceName)
+		return name
+	}
+	switch strategy {
+	case "", NameStrategy:
+		// metadata.name is used as it is, so that existing names never change.
+		return cd.Resource.GetName()
+	}
+	return truncateName(generateName(&cd.Resource.Unstructured, strategy))
+}
+
+func generateName(u *unstructured.Unstructured, strategy NamingStrategy) string {
+	gvk := u.GroupVersionKind()
+	kind := strings.ToLower(gvk.Kind)
+	switch strategy {
+	case KindNameStrategy:
+		return joinNonEmpty(kind, u.GetName())
+	case GroupKindNamespaceNameStrategy:
+		return joinNonEmpty(gvk.Group, kind, u.GetNamespace(), u.GetName())
+	case HashStrategy:
+		sum := sha256.Sum256([]byte(strings.Join([]string{gvk.Group, gvk.Kind, u.GetNamespace(), u.GetName()}, "/")))
+		return joinNonEmpty(kind, hex.EncodeToString(sum[:8]))
+	}
+	return u.GetName()
+}
+
+func joinNonEmpty(parts ...string) string {
+	out := make([]string, 0, len(parts))
+	for _, p := range parts {
+		if p != "" {
+			out = append(out, p)
+		}
+	}
+	return strings.Join(out, "-")
+}
+
+// truncateName truncates name to maxResourceNameLength, replacing its end with
+// a hash of the whole name.
+func truncateName(name string) string {
+	if len(name) <= maxResourceNameLength {
+		return name
+	}
+	sum := sha256.Sum256([]byte(name))
+	suffix := hex.EncodeToString(sum[:4])
+	return strings.TrimRight(name[:maxResourceNameLength-len(suffix)-1], "-.") + "-" + suffix
+}
+
+// describeObject names u in errors, e.g. Bucket "team-a/logs" (s3.aws.upbound.io/v1beta1).
+func describeObject(u *unstructured.Unstructured) string {
+	name := u.GetName()
+	if ns := u.GetNamespace(); ns != "" {
+		name = ns + "/" + name
+	}
+	return u.GetKind() + " \"" + name + "\" (" + u.GetAPIVersion() + ")"
+}
@@ -0,0 +1,118 @@
+package resource
+
+import (
+	"strings"
+	"testing"
+
+	res "github.com/crossplane/function-sdk-go/resource"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func TestResourceName(t *testing.T) {
+	long := strings.Repeat("a", 70)
+	cases := map[string]struct {
+		reason   string
+		strategy NamingStrategy
+		obj      map[string]interface{}
+		want     string
+	}{
+		"Annotation": {
+			reason:   "An explicit annotation should always be respected.",
+			strategy: HashStrategy,
+			obj:      map[string]interface{}{"kind": "Bucket", "metadata": map[string]interface{}{"name": "logs", "annotations": map[string]interface{}{AnnotationKeyCompositionResourceName: "my-bucket"}}},
+			want:     "my-bucket",
+		},
+		"Name": {
+			reason:   "The default strategy should use metadata.name as it is, however long.",
+			strategy: "",
+			obj:      map[string]interface{}{"kind": "Bucket", "metadata": map[string]interface{}{"name": long}},
+			want:     long,
+		},
+		"KindName": {
+			reason:   "kind-name should prefix the name with the kind.",
+			strategy: KindNameStrategy,
+			obj:      map[string]interface{}{"apiVersion": "s3.aws.upbound.io/v1beta1", "kind": "Bucket", "metadata": map[string]interface{}{"name": "logs"}},
+			want:     "bucket-logs",
+		},
+		"GroupKindNamespaceName": {
+			reason:   "apiGroup-kind-namespace-name should skip the parts that are not set.",
+			strategy: GroupKindNamespaceNameStrategy,
+			obj:      map[string]interface{}{"apiVersion": "v1", "kind": "ConfigMap", "metadata": map[string]interface{}{"name": "logs", "namespace": "team-a"}},
+			want:     "configmap-team-a-logs",
+		},
+		"Hash": {
+			reason:   "hash should be stable.",
+			strategy: HashStrategy,
+			obj:      map[string]interface{}{"apiVersion": "s3.aws.upbound.io/v1beta1", "kind": "Bucket", "metadata": map[string]interface{}{"name": "logs"}},
+			want:     "bucket-ca8d6fee0709d836",
+		},
+		"Truncated": {
+			reason:   "Generated names should be truncated, keeping them unique.",
+			strategy: KindNameStrategy,
+			obj:      map[string]interface{}{"kind": "Bucket", "metadata": map[string]interface{}{"name": long}},
+			want:     "bucket-" + strings.Repeat("a", 47) + "-cc3ba5bb",
+		},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			cd := res.NewDesiredComposed()
+			cd.Resource.Unstructured = unstructured.Unstructured{Object: tc.obj}
+			got := ResourceName(cd, tc.strategy)
+			if got != tc.want {
+				t.Errorf("%s\nResourceName(...): want %q, got %q", tc.reason, tc.want, got)
+			}
+			if len(got) > maxResourceNameLength && tc.strategy != "" {
+				t.Errorf("%s\nResourceName(...): %q is longer than %d", tc.reason, got, maxResourceNameLength)
+			}
+		})
+	}
+}
+
+func TestDuplicateCheckerReportsBothObjects(t *testing.T) {
+	c := newDuplicateChecker(NameStrategy)
+	desired := map[res.Name]*res.DesiredComposed{}
+	for _, kind := range []string{"Bucket", "Queue"} {
+		cd := res.NewDesiredComposed()
+		cd.Resource.Unstructured = unstructured.Unstructured{Object: map[string]interface{}{"apiVersion": "example.org/v1", "kind": kind, "metadata": map[string]interface{}{"name": "a", "namespace": "ns"}}}
+		err := c.CheckAndSetDesired(desired, cd)
+		if kind == "Bucket" && err != nil {
+			t.Fatal(err)
+		}
+		if kind == "Queue" {
+			if err == nil || !strings.Contains(err.Error(), `Bucket "ns/a" (example.org/v1) and Queue "ns/a" (example.org/v1)`) {
+				t.Errorf("CheckAndSetDesired(...): want an error naming both objects, got %v", err)
+			}
+		}
+	}
+}
+
+func TestDuplicateCheckerEmptyName(t *testing.T) {
+	cases := map[string]struct {
+		reason  string
+		naming  NamingStrategy
+		wantErr bool
+	}{
+		"Default": {
+			reason: "An object without a name should be accepted when no naming strategy is set.",
+		},
+		"Name": {
+			reason: "An object without a name should be accepted with the name strategy.",
+			naming: NameStrategy,
+		},
+		"KindName": {
+			reason:  "An object without a name should be rejected with a strategy that generates names from it.",
+			naming:  KindNameStrategy,
+			wantErr: true,
+		},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			cd := res.NewDesiredComposed()
+			cd.Resource.Unstructured = unstructured.Unstructured{Object: map[string]interface{}{"apiVersion": "example.org/v1"}}
+			err := newDuplicateChecker(tc.naming).CheckDuplicateName(cd, "")
+			if (err != nil) != tc.wantErr {
+				t.Errorf("%s\nCheckDuplicateName(...): want error %t, got %v", tc.reason, tc.wantErr, err)
+			}
+		})
+	}
+}
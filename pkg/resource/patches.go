@@ -0,0 +1,100 @@
+package resource
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+
+	"github.com/crossplane/function-sdk-go/resource"
+	jsonpatch "github.com/evanphx/json-patch/v5"
+	"github.com/pkg/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	utiljson "k8s.io/apimachinery/pkg/util/json"
+)
+
+// JSONPatches are the patches of a Patches meta resource.
+type JSONPatches []JSONPatch
+
+// JSONPatch is a list of RFC 6902 JSON patch operations, applied in order to
+// either a desired composed resource or the desired XR.
+type JSONPatch struct {
+	// Resource is the composition resource name of the desired composed
+	// resource to patch.
+	Resource string `json:"resource,omitempty"`
+	// Composite patches the desired XR instead of a composed resource.
+	Composite bool `json:"composite,omitempty"`
+	// Operations are the JSON patch operations, e.g.
+	// {"op": "add", "path": "/spec/ports/0", "value": 80}.
+	Operations []json.RawMessage `json:"operations"`
+}
+
+// ApplyJSONPatches applies each patch to the desired composed resource or the
+// desired XR it targets. A patch fails as a whole when one of its operations
+// does, including a test operation whose value does not match.
+func ApplyJSONPatches(patches JSONPatches, dxr *resource.Composite, desired map[resource.Name]*resource.DesiredComposed) error {
+	for i, p := range patches {
+		var obj map[string]interface{}
+		switch {
+		case p.Composite && p.Resource != "":
+			return errors.Errorf("patches[%d]: resource and composite are mutually exclusive", i)
+		case p.Composite:
+			obj = dxr.Resource.Object
+		case p.Resource != "":
+			cd, ok := desired[resource.Name(p.Resource)]
+			if !ok {
+				return errors.Errorf("patches[%d]: no desired composed resource %q", i, p.Resource)
+			}
+			obj = cd.Resource.Object
+		default:
+			return errors.Errorf("patches[%d]: one of resource and composite must be set", i)
+		}
+		patched, err := applyJSONPatch(obj, p.Operations)
+		if err != nil {
+			return errors.Wrapf(err, "patches[%d]", i)
+		}
+		for _, f := range protectedFields {
+			before, _, _ := unstructured.NestedFieldNoCopy(obj, strings.Split(f, ".")...)
+			after, _, _ := unstructured.NestedFieldNoCopy(patched, strings.Split(f, ".")...)
+			if !reflect.DeepEqual(before, after) {
+				return errors.Errorf("patches[%d]: cannot change %s", i, f)
+			}
+		}
+		if p.Composite {
+			dxr.Resource.Object = patched
+		} else {
+			desired[resource.Name(p.Resource)].Resource.Object = patched
+		}
+	}
+	return nil
+}
+
+// applyJSONPatch applies ops to a copy of obj one at a time, so that an error
+// names the operation that failed. Missing parents of an added value are
+// created, since the desired state is often sparse.
+func applyJSONPatch(obj map[string]interface{}, ops []json.RawMessage) (map[string]interface{}, error) {
+	doc, err := json.Marshal(obj)
+	if err != nil {
+		return nil, err
+	}
+	opts := jsonpatch.NewApplyOptions()
+	opts.EnsurePathExistsOnAdd = true
+	for i, raw := range ops {
+		var op jsonpatch.Operation
+		if err := json.Unmarshal(raw, &op); err != nil {
+			return nil, errors.Wrapf(err, "operations[%d]: cannot decode operation", i)
+		}
+		if doc, err = (jsonpatch.Patch{op}).ApplyWithOptions(doc, opts); err != nil {
+			if errors.Is(err, jsonpatch.ErrTestFailed) {
+				path, _ := op.Path()
+				v, _ := op.ValueInterface()
+				return nil, errors.Errorf("operations[%d]: test failed: %s is not %v", i, path, v)
+			}
+			return nil, errors.Wrapf(err, "operations[%d]: cannot apply %s operation", i, op.Kind())
+		}
+	}
+	out := make(map[string]interface{})
+	if err := utiljson.Unmarshal(doc, &out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
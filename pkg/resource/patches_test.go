@@ -0,0 +1,103 @@
+package resource
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	res "github.com/crossplane/function-sdk-go/resource"
+	"github.com/crossplane/function-sdk-go/resource/composed"
+	"github.com/crossplane/function-sdk-go/resource/composite"
+	"github.com/google/go-cmp/cmp"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func TestApplyJSONPatches(t *testing.T) {
+	ops := func(s ...string) []json.RawMessage {
+		out := make([]json.RawMessage, 0, len(s))
+		for _, o := range s {
+			out = append(out, json.RawMessage(o))
+		}
+		return out
+	}
+	bucket := func() map[string]interface{} {
+		return map[string]interface{}{
+			"apiVersion": "s3.aws.upbound.io/v1beta1",
+			"kind":       "Bucket",
+			"metadata":   map[string]interface{}{"name": "bucket"},
+			"spec":       map[string]interface{}{"tags": []interface{}{"a", "c"}, "region": "eu-west-1"},
+		}
+	}
+	cases := map[string]struct {
+		reason  string
+		patches JSONPatches
+		want    map[string]interface{}
+		wantXR  map[string]interface{}
+		wantErr string
+	}{
+		"InOrder": {
+			reason: "Operations should be applied in order, including inserts at a position.",
+			patches: JSONPatches{{Resource: "bucket", Operations: ops(
+				`{"op": "test", "path": "/spec/region", "value": "eu-west-1"}`,
+				`{"op": "add", "path": "/spec/tags/1", "value": "b"}`,
+				`{"op": "remove", "path": "/spec/tags/0"}`,
+				`{"op": "replace", "path": "/spec/region", "value": "us-east-1"}`,
+			)}},
+			want: map[string]interface{}{
+				"apiVersion": "s3.aws.upbound.io/v1beta1",
+				"kind":       "Bucket",
+				"metadata":   map[string]interface{}{"name": "bucket"},
+				"spec":       map[string]interface{}{"tags": []interface{}{"b", "c"}, "region": "us-east-1"},
+			},
+		},
+		"Composite": {
+			reason:  "A composite patch should edit the desired XR, creating missing parents.",
+			patches: JSONPatches{{Composite: true, Operations: ops(`{"op": "add", "path": "/status/ready", "value": true}`)}},
+			want:    bucket(),
+			wantXR:  map[string]interface{}{"apiVersion": "example.org/v1", "kind": "XR", "status": map[string]interface{}{"ready": true}},
+		},
+		"TestFailed": {
+			reason:  "A failed test operation should name the operation and the value it expected.",
+			patches: JSONPatches{{Resource: "bucket", Operations: ops(`{"op": "test", "path": "/spec/region", "value": "us-east-1"}`)}},
+			wantErr: "patches[0]: operations[0]: test failed: /spec/region is not us-east-1",
+		},
+		"UnknownResource": {
+			reason:  "A patch should target an existing desired composed resource.",
+			patches: JSONPatches{{Resource: "queue", Operations: ops(`{"op": "remove", "path": "/spec"}`)}},
+			wantErr: `patches[0]: no desired composed resource "queue"`,
+		},
+		"Protected": {
+			reason:  "A patch should not change the name of a resource.",
+			patches: JSONPatches{{Resource: "bucket", Operations: ops(`{"op": "replace", "path": "/metadata/name", "value": "other"}`)}},
+			wantErr: "patches[0]: cannot change metadata.name",
+		},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			dxr := &res.Composite{Resource: composite.New()}
+			dxr.Resource.SetAPIVersion("example.org/v1")
+			dxr.Resource.SetKind("XR")
+			desired := map[res.Name]*res.DesiredComposed{
+				"bucket": {Resource: &composed.Unstructured{Unstructured: unstructured.Unstructured{Object: bucket()}}},
+			}
+			err := ApplyJSONPatches(tc.patches, dxr, desired)
+			if tc.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+					t.Errorf("%s\nApplyJSONPatches(...): want error %q, got %v", tc.reason, tc.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("%s\nApplyJSONPatches(...): unexpected error %v", tc.reason, err)
+			}
+			if diff := cmp.Diff(tc.want, desired["bucket"].Resource.Object); diff != "" {
+				t.Errorf("%s\nApplyJSONPatches(...): -want bucket, +got bucket:\n%s", tc.reason, diff)
+			}
+			if tc.wantXR != nil {
+				if diff := cmp.Diff(tc.wantXR, dxr.Resource.Object); diff != "" {
+					t.Errorf("%s\nApplyJSONPatches(...): -want xr, +got xr:\n%s", tc.reason, diff)
+				}
+			}
+		})
+	}
+}
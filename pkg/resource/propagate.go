@@ -0,0 +1,71 @@
+package resource
+
+import (
+	"strings"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// PropagationPolicy selects the labels and annotations of the observed XR
+// that are copied to output objects.
+type PropagationPolicy struct {
+	// Labels are the keys of the labels to copy. A key ending in * matches
+	// every key with that prefix, e.g. crossplane.io/*.
+	Labels []string
+	// Annotations are the keys of the annotations to copy, like Labels.
+	Annotations []string
+	// KeepExisting keeps the value of a label or annotation the output object
+	// already sets, instead of replacing it with the value of the XR.
+	KeepExisting bool
+}
+
+// PropagateMetadata copies the labels and annotations of xr that policy
+// selects to u.
+func PropagateMetadata(policy *PropagationPolicy, xr, u *unstructured.Unstructured) {
+	if policy == nil || xr == nil {
+		return
+	}
+	if l := propagate(policy.Labels, xr.GetLabels(), u.GetLabels(), policy.KeepExisting); l != nil {
+		u.SetLabels(l)
+	}
+	if a := propagate(policy.Annotations, xr.GetAnnotations(), u.GetAnnotations(), policy.KeepExisting); a != nil {
+		u.SetAnnotations(a)
+	}
+}
+
+// propagate returns dst with the entries of src whose key matches one of keys
+// added, or nil when there are none.
+func propagate(keys []string, src, dst map[string]string, keepExisting bool) map[string]string {
+	var out map[string]string
+	for k, v := range src {
+		if !matchesKey(keys, k) {
+			continue
+		}
+		if _, exists := dst[k]; exists && keepExisting {
+			continue
+		}
+		if out == nil {
+			out = make(map[string]string, len(dst)+1)
+			for dk, dv := range dst {
+				out[dk] = dv
+			}
+		}
+		out[k] = v
+	}
+	return out
+}
+
+func matchesKey(keys []string, key string) bool {
+	for _, k := range keys {
+		if prefix, ok := strings.CutSuffix(k, "*"); ok {
+			if strings.HasPrefix(key, prefix) {
+				return true
+			}
+			continue
+		}
+		if k == key {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,125 @@
+package resource
+
+import (
+	"testing"
+
+	fnv1 "github.com/crossplane/function-sdk-go/proto/v1"
+	res "github.com/crossplane/function-sdk-go/resource"
+	"github.com/crossplane/function-sdk-go/resource/composite"
+	"github.com/google/go-cmp/cmp"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func TestPropagateMetadata(t *testing.T) {
+	xr := &unstructured.Unstructured{Object: map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"labels": map[string]interface{}{
+				"crossplane.io/composite":       "team-a-x7k2p",
+				"crossplane.io/claim-name":      "team-a",
+				"crossplane.io/claim-namespace": "default",
+				"cost-center":                   "1234",
+				"app":                           "logs",
+			},
+			"annotations": map[string]interface{}{
+				"example.org/owner": "team-a",
+				"example.org/notes": "internal",
+			},
+		},
+	}}
+	cases := map[string]struct {
+		reason string
+		policy *PropagationPolicy
+		obj    map[string]interface{}
+		want   map[string]interface{}
+	}{
+		"KeysAndPrefixes": {
+			reason: "Labels and annotations matching a key or prefix should be copied.",
+			policy: &PropagationPolicy{Labels: []string{"crossplane.io/*", "cost-center"}, Annotations: []string{"example.org/owner"}},
+			obj:    map[string]interface{}{"kind": "Bucket"},
+			want: map[string]interface{}{
+				"kind": "Bucket",
+				"metadata": map[string]interface{}{
+					"labels": map[string]interface{}{
+						"crossplane.io/composite":       "team-a-x7k2p",
+						"crossplane.io/claim-name":      "team-a",
+						"crossplane.io/claim-namespace": "default",
+						"cost-center":                   "1234",
+					},
+					"annotations": map[string]interface{}{"example.org/owner": "team-a"},
+				},
+			},
+		},
+		"Override": {
+			reason: "Values the object already sets should be replaced by default.",
+			policy: &PropagationPolicy{Labels: []string{"cost-center"}},
+			obj: map[string]interface{}{
+				"kind":     "Bucket",
+				"metadata": map[string]interface{}{"labels": map[string]interface{}{"cost-center": "5678", "tier": "prod"}},
+			},
+			want: map[string]interface{}{
+				"kind":     "Bucket",
+				"metadata": map[string]interface{}{"labels": map[string]interface{}{"cost-center": "1234", "tier": "prod"}},
+			},
+		},
+		"KeepExisting": {
+			reason: "Values the object already sets should be kept with KeepExisting.",
+			policy: &PropagationPolicy{Labels: []string{"cost-center", "app"}, KeepExisting: true},
+			obj: map[string]interface{}{
+				"kind":     "Bucket",
+				"metadata": map[string]interface{}{"labels": map[string]interface{}{"cost-center": "5678"}},
+			},
+			want: map[string]interface{}{
+				"kind":     "Bucket",
+				"metadata": map[string]interface{}{"labels": map[string]interface{}{"cost-center": "5678", "app": "logs"}},
+			},
+		},
+		"NoPolicy": {
+			reason: "Nothing should be copied without a policy.",
+			obj:    map[string]interface{}{"kind": "Bucket"},
+			want:   map[string]interface{}{"kind": "Bucket"},
+		},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			u := &unstructured.Unstructured{Object: tc.obj}
+			PropagateMetadata(tc.policy, xr, u)
+			if diff := cmp.Diff(tc.want, u.Object); diff != "" {
+				t.Errorf("%s\nPropagateMetadata(...): -want, +got:\n%s", tc.reason, diff)
+			}
+		})
+	}
+}
+
+func TestProcessResourcesPropagatesToComposedResources(t *testing.T) {
+	dxr := &res.Composite{Resource: composite.New(), ConnectionDetails: res.ConnectionDetails{}}
+	dxr.Resource.SetAPIVersion("example.org/v1")
+	dxr.Resource.SetKind("XR")
+	oxr := &res.Composite{Resource: composite.New()}
+	oxr.Resource.SetAPIVersion("example.org/v1")
+	oxr.Resource.SetKind("XR")
+	oxr.Resource.SetLabels(map[string]string{"cost-center": "1234"})
+	desired := map[res.Name]*res.DesiredComposed{}
+	data := []unstructured.Unstructured{
+		{Object: map[string]interface{}{
+			"apiVersion": "s3.aws.upbound.io/v1beta1",
+			"kind":       "Bucket",
+			"metadata":   map[string]interface{}{"name": "bucket"},
+		}},
+		{Object: map[string]interface{}{
+			"apiVersion": "example.org/v1",
+			"kind":       "XR",
+			"status":     map[string]interface{}{"ready": true},
+		}},
+	}
+
+	opts := &AddResourcesOptions{Data: data, Overwrite: true, Propagation: &PropagationPolicy{Labels: []string{"cost-center"}}}
+	if _, err := ProcessResources(dxr, oxr, desired, nil, map[string]*fnv1.ResourceSelector{}, map[string]*fnv1.ResourceSelector{}, &ConditionResources{}, &EventResources{}, &map[string]interface{}{}, Default, nil, opts); err != nil {
+		t.Fatalf("ProcessResources(...): unexpected error %v", err)
+	}
+	if got := desired["bucket"].Resource.GetLabels()["cost-center"]; got != "1234" {
+		t.Errorf("ProcessResources(...): want the cost-center label on the bucket, got %q", got)
+	}
+	if _, found := dxr.Resource.GetLabels()["cost-center"]; found {
+		t.Errorf("ProcessResources(...): want no labels propagated to the XR")
+	}
+}
@@ -18,6 +18,7 @@ import (
 	"gopkg.in/yaml.v2"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/runtime"
+	utiljson "k8s.io/apimachinery/pkg/util/json"
 
 	krmyaml "kcl-lang.io/krm-kcl/pkg/yaml"
 )
@@ -153,6 +154,28 @@ func DataResourcesFromYaml(in []byte) (result []unstructured.Unstructured, err e
 	return
 }
 
+// DataResourcesFromJSON returns the manifests list from a JSON array of
+// objects. Numbers are decoded as int64 or float64, as unstructured objects
+// expect, and null items are skipped like empty YAML documents.
+func DataResourcesFromJSON(in []byte) ([]unstructured.Unstructured, error) {
+	var items []interface{}
+	if err := utiljson.Unmarshal(in, &items); err != nil {
+		return nil, err
+	}
+	result := make([]unstructured.Unstructured, 0, len(items))
+	for i, item := range items {
+		if item == nil {
+			continue
+		}
+		obj, ok := item.(map[string]interface{})
+		if !ok {
+			return nil, errors.Errorf("item %d is a %T, not an object", i, item)
+		}
+		result = append(result, unstructured.Unstructured{Object: obj})
+	}
+	return result, nil
+}
+
 // DesiredMatch matches a list of data to apply to a desired resource
 // This is used when targeting PatchDesired resources
 type DesiredMatch map[*resource.DesiredComposed][]map[string]interface{}
@@ -160,6 +183,10 @@ type DesiredMatch map[*resource.DesiredComposed][]map[string]interface{}
 // MatchResources finds and associates the data to the desired resource
 // The length of the passed data should match the total count of desired match data
 func MatchResources(desired map[resource.Name]*resource.DesiredComposed, data []unstructured.Unstructured) (DesiredMatch, error) {
+	return matchResources(desired, data, NameStrategy)
+}
+
+func matchResources(desired map[resource.Name]*resource.DesiredComposed, data []unstructured.Unstructured, naming NamingStrategy) (DesiredMatch, error) {
 	// Iterate over the data patches and match them to desired resources
 	matches := make(DesiredMatch)
 	count := 0
@@ -170,7 +197,7 @@ func MatchResources(desired map[resource.Name]*resource.DesiredComposed, data []
 		// PatchDesired
 		cd := resource.NewDesiredComposed()
 		cd.Resource.Unstructured = d
-		if found, ok := desired[resource.Name(GetResourceName(cd))]; ok {
+		if found, ok := desired[resource.Name(ResourceName(cd, naming))]; ok {
 			if _, ok := matches[found]; !ok {
 				matches[found] = []map[string]interface{}{d.Object}
 			} else {
@@ -229,6 +256,21 @@ type AddResourcesOptions struct {
 	Basename  string
 	Data      []unstructured.Unstructured
 	Overwrite bool
+	// MergeKeys are the keys lists are merged by when patching, keyed by the
+	// field path of the list. See MergePatch.
+	MergeKeys map[string]string
+	// Naming is the strategy composition resource names are generated with.
+	// Defaults to NameStrategy.
+	Naming NamingStrategy
+	// Defaults are merged under the objects that become desired composed
+	// resources. See ApplyDefaults.
+	Defaults []ResourceDefaults
+	// Propagation copies labels and annotations of the observed XR to the
+	// objects that become desired composed resources. See PropagateMetadata.
+	Propagation *PropagationPolicy
+	// KubernetesObjects wraps the objects that become desired composed
+	// resources into provider-kubernetes Objects. See WrapKubernetesObject.
+	KubernetesObjects *KubernetesObjects
 }
 
 // AddResourcesTo adds the given data to any allowed object passed
@@ -255,11 +297,11 @@ func AddResourcesTo(o any, opts *AddResourcesOptions) error {
 	case map[resource.Name]*resource.DesiredComposed:
 		// Resources
 		desired := val
-		checker := newDuplicateChecker()
+		checker := newDuplicateChecker(opts.Naming)
 		for _, d := range opts.Data {
 			cd := resource.NewDesiredComposed()
 			cd.Resource.Unstructured = d
-			name := resource.Name(GetResourceName(cd))
+			name := resource.Name(ResourceName(cd, opts.Naming))
 			if err := checker.CheckDuplicateName(cd, name); err != nil {
 				return err
 			}
@@ -281,16 +323,22 @@ func AddResourcesTo(o any, opts *AddResourcesOptions) error {
 		// Set the Match data on the desired resource stored as keys
 		for obj, matchData := range matches {
 			// There may be multiple data patches to the DesiredComposed object
+			if obj.Resource == nil {
+				return errors.New("cannot set data on a nil DesiredComposed resource")
+			}
 			for _, d := range matchData {
-				if err := SetData(d, "", obj, opts.Overwrite); err != nil {
+				if err := MergePatch(obj.Resource.Object, d, opts.MergeKeys, opts.Overwrite); err != nil {
 					return errors.Wrap(err, "cannot set data existing desired composed object")
 				}
 			}
 		}
 	case *resource.Composite:
 		// XR
+		if val.Resource == nil {
+			return errors.New("cannot set data on a nil XR")
+		}
 		for _, d := range opts.Data {
-			if err := SetData(d.Object, "", o, opts.Overwrite); err != nil {
+			if err := MergePatch(val.Resource.Object, d.Object, opts.MergeKeys, opts.Overwrite); err != nil {
 				return errors.Wrap(err, "cannot set data on xr")
 			}
 		}
@@ -308,6 +356,9 @@ func AddResourcesTo(o any, opts *AddResourcesOptions) error {
 // If the resource to write to 'o' contains a nil .Resource, setData will return an error
 // It is expected that the resource is created via composed.New() or composite.New() prior
 // to calling setData
+//
+// Deprecated: SetData cannot address keys that contain dots, patches lists
+// index by index and cannot delete a field. Use MergePatch.
 func SetData(data any, path string, o any, overwrite bool) error {
 	switch val := data.(type) {
 	case map[string]interface{}:
@@ -395,7 +446,31 @@ func ProcessResources(dxr *resource.Composite, oxr *resource.Composite, desired
 	result := AddResourcesResult{
 		Target: target,
 	}
+	groups, routed, err := routeTargets(opts.Data, target)
+	if err != nil {
+		return result, err
+	}
+	if routed {
+		return processRouted(dxr, oxr, desired, observed, extraResources, requiredResources, conditions, events, contextData, target, resources, opts, groups)
+	}
 	data := opts.Data
+	var xr *unstructured.Unstructured
+	if oxr != nil && oxr.Resource != nil {
+		xr = &oxr.Resource.Unstructured
+	}
+	switch target {
+	case Default, Resources:
+		// Defaults select objects as they were output, so they are merged
+		// before the objects are wrapped into provider-kubernetes Objects.
+		// Metadata is propagated last, to the Objects that become the desired
+		// composed resources rather than the manifests they carry.
+		for _, u := range composedObjects(data, xr) {
+			if err := applyWrappedDefaults(opts.Defaults, opts.KubernetesObjects, u, opts.Naming); err != nil {
+				return result, err
+			}
+			PropagateMetadata(opts.Propagation, xr, u)
+		}
+	}
 	switch target {
 	case XR:
 		if err := AddResourcesTo(dxr, opts); err != nil {
@@ -404,7 +479,7 @@ func ProcessResources(dxr *resource.Composite, oxr *resource.Composite, desired
 		result.Object = dxr
 		result.MsgCount = 1
 	case PatchDesired:
-		desiredMatches, err := MatchResources(desired, data)
+		desiredMatches, err := matchResources(desired, data, opts.Naming)
 		if err != nil {
 			return result, err
 		}
@@ -431,7 +506,7 @@ func ProcessResources(dxr *resource.Composite, oxr *resource.Composite, desired
 			desired[resource.Name(r.Name)] = &resource.DesiredComposed{Resource: &composed.Unstructured{Unstructured: r.Base}}
 		}
 		// Match the data to the desired resources
-		desiredMatches, err := MatchResources(desired, data)
+		desiredMatches, err := matchResources(desired, data, opts.Naming)
 		if err != nil {
 			return result, err
 		}
@@ -439,6 +514,11 @@ func ProcessResources(dxr *resource.Composite, oxr *resource.Composite, desired
 		if err := AddResourcesTo(desiredMatches, opts); err != nil {
 			return result, err
 		}
+		for _, r := range resources {
+			u := &desired[resource.Name(r.Name)].Resource.Unstructured
+			ApplyDefaults(opts.Defaults, u)
+			PropagateMetadata(opts.Propagation, xr, u)
+		}
 		// Process ready annotations
 		for _, cd := range desired {
 			if v, found := cd.Resource.GetAnnotations()[AnnotationKeyReady]; found {
@@ -472,7 +552,11 @@ func ProcessResources(dxr *resource.Composite, oxr *resource.Composite, desired
 		result.Object = data
 		result.MsgCount = len(data)
 	case Default:
-		checker := newDuplicateChecker()
+		checker := newDuplicateChecker(opts.Naming)
+		// Deletions and then patches are applied once every other object is
+		// processed, so that they can act on the resources of the same render.
+		var deletions DeleteSelectors
+		var patches JSONPatches
 		for _, obj := range data {
 			cd := resource.NewDesiredComposed()
 			cd.Resource.Unstructured = obj
@@ -542,8 +626,22 @@ func ProcessResources(dxr *resource.Composite, oxr *resource.Composite, desired
 					if err := cd.Resource.GetValueInto("data", contextData); err != nil {
 						return result, errors.Wrap(err, "cannot get context resource")
 					}
+				case "DeleteResources":
+					// Returns selectors of desired resources to delete
+					var ds DeleteSelectors
+					if err := cd.Resource.GetValueInto("selectors", &ds); err != nil {
+						return result, errors.Wrap(err, "cannot get delete resources resource")
+					}
+					deletions = append(deletions, ds...)
+				case "Patches":
+					// Returns JSON patches to apply to desired resources
+					var ps JSONPatches
+					if err := cd.Resource.GetValueInto("patches", &ps); err != nil {
+						return result, errors.Wrap(err, "cannot get patches resource")
+					}
+					patches = append(patches, ps...)
 				default:
-					return result, errors.Errorf("invalid kind %q for apiVersion %q - must be CompositeConnectionDetails or ExtraResources", obj.GetKind(), MetaApiVersion)
+					return result, errors.Errorf("invalid kind %q for apiVersion %q - must be CompositeConnectionDetails, ExtraResources, RequiredResources, Conditions, Events, Context, DeleteResources or Patches", obj.GetKind(), MetaApiVersion)
 				}
 				continue
 			}
@@ -560,6 +658,12 @@ func ProcessResources(dxr *resource.Composite, oxr *resource.Composite, desired
 				return result, err
 			}
 		}
+		if err := DeleteResources(deletions, desired); err != nil {
+			return result, errors.Wrap(err, "cannot delete resources")
+		}
+		if err := ApplyJSONPatches(patches, dxr, desired); err != nil {
+			return result, errors.Wrap(err, "cannot apply patches")
+		}
 		result.Object = data
 		result.MsgCount = len(data)
 		result.setSuccessMsgs()
@@ -569,15 +673,20 @@ func ProcessResources(dxr *resource.Composite, oxr *resource.Composite, desired
 	return result, nil
 }
 
-type duplicateChecker map[resource.Name]string
+// duplicateChecker reports composed resources that get the same composition
+// resource name, remembering the object each name was first given to.
+type duplicateChecker struct {
+	naming NamingStrategy
+	seen   map[resource.Name]string
+}
 
-func newDuplicateChecker() duplicateChecker {
-	return make(duplicateChecker)
+func newDuplicateChecker(naming NamingStrategy) *duplicateChecker {
+	return &duplicateChecker{naming: naming, seen: make(map[resource.Name]string)}
 }
 
 // CheckAndSetDesired checks for duplicate names and then sets the resource into the desired resource map.
 func (c *duplicateChecker) CheckAndSetDesired(desired map[resource.Name]*resource.DesiredComposed, cd *resource.DesiredComposed) error {
-	name := resource.Name(GetResourceName(cd))
+	name := resource.Name(ResourceName(cd, c.naming))
 	if err := c.CheckDuplicateName(cd, name); err != nil {
 		return err
 	}
@@ -585,20 +694,23 @@ func (c *duplicateChecker) CheckAndSetDesired(desired map[resource.Name]*resourc
 	return nil
 }
 
+// CheckDuplicateName returns an error if name was already given to another
+// object. An object without a name is an error only with a naming strategy
+// other than NameStrategy, which generates names from its kind and name.
 func (c *duplicateChecker) CheckDuplicateName(cd *resource.DesiredComposed, name resource.Name) error {
-	kindAndName := cd.Resource.GetKind() + "/" + cd.Resource.GetName()
-	if _, existed := (*c)[name]; existed {
-		return errors.Errorf("multiple composed resources with name %q returned: %s and %s. Set different metadata.name or metadata.annotations.\"krm.kcl.dev/composition-resource-name\" to distinguish them.", name, (*c)[name], kindAndName)
+	if name == "" && c.naming != "" && c.naming != NameStrategy {
+		return errors.Errorf("composed resource %s has no name with the %s naming strategy. Set metadata.name or metadata.annotations.\"krm.kcl.dev/composition-resource-name\".", describeObject(&cd.Resource.Unstructured), c.naming)
 	}
-	(*c)[name] = kindAndName
+	obj := describeObject(&cd.Resource.Unstructured)
+	if first, existed := c.seen[name]; existed {
+		return errors.Errorf("multiple composed resources with name %q returned: %s and %s. Set different metadata.name or metadata.annotations.\"krm.kcl.dev/composition-resource-name\" to distinguish them.", name, first, obj)
+	}
+	c.seen[name] = obj
 	return nil
 }
 
+// GetResourceName returns the composition resource name of cd with the
+// NameStrategy. See ResourceName.
 func GetResourceName(cd *resource.DesiredComposed) string {
-	name, found := cd.Resource.GetAnnotations()[AnnotationKeyCompositionResourceName]
-	if !found {
-		name = cd.Resource.GetName()
-	}
-	meta.RemoveAnnotations(cd.Resource, AnnotationKeyCompositionResourceName)
-	return name
+	return ResourceName(cd, NameStrategy)
 }
@@ -126,3 +126,44 @@ func TestSetData(t *testing.T) {
 		})
 	}
 }
+
+func TestDataResourcesFromJSON(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected []unstructured.Unstructured
+		wantErr  bool
+	}{
+		{
+			name:  "Objects",
+			input: `[{"apiVersion":"v1","kind":"ConfigMap","data":{"replicas":3,"ratio":0.5}},null]`,
+			expected: []unstructured.Unstructured{{Object: map[string]interface{}{
+				"apiVersion": "v1",
+				"kind":       "ConfigMap",
+				"data":       map[string]interface{}{"replicas": int64(3), "ratio": 0.5},
+			}}},
+		},
+		{
+			name:     "Empty",
+			input:    `[]`,
+			expected: []unstructured.Unstructured{},
+		},
+		{
+			name:    "NotAnObject",
+			input:   `["a"]`,
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := DataResourcesFromJSON([]byte(tt.input))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("DataResourcesFromJSON() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if diff := cmp.Diff(tt.expected, got); !tt.wantErr && diff != "" {
+				t.Errorf("DataResourcesFromJSON() -want, +got:\n%s", diff)
+			}
+		})
+	}
+}
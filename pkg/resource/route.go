@@ -0,0 +1,77 @@
+package resource
+
+import (
+	"sort"
+
+	fnv1 "github.com/crossplane/function-sdk-go/proto/v1"
+	"github.com/crossplane/function-sdk-go/resource"
+	"github.com/pkg/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// AnnotationKeyTarget routes an output object to a target other than the
+// target of the input, so that a single render can both create and patch
+// resources.
+const AnnotationKeyTarget = "krm.kcl.dev/target"
+
+// routeOrder is the order routed objects are processed in: resources are
+// created before they are patched, and the XR is patched last.
+var routeOrder = []Target{Default, Resources, PatchResources, PatchDesired, XR}
+
+// routeTargets groups data by the target each object is routed to: the value
+// of its krm.kcl.dev/target annotation, which is removed, or else target.
+// routed is false when no object is annotated.
+func routeTargets(data []unstructured.Unstructured, target Target) (groups map[Target][]unstructured.Unstructured, routed bool, err error) {
+	groups = make(map[Target][]unstructured.Unstructured)
+	for i := range data {
+		t := target
+		if v, found := data[i].GetAnnotations()[AnnotationKeyTarget]; found {
+			t = Target(v)
+			switch t {
+			case Default, Resources, PatchResources, PatchDesired, XR:
+			default:
+				return nil, false, errors.Errorf("invalid %q annotation value %q on %s %q: must be Default, Resources, PatchResources, PatchDesired or XR", AnnotationKeyTarget, v, data[i].GetKind(), data[i].GetName())
+			}
+			removeAnnotation(&data[i], AnnotationKeyTarget)
+			routed = true
+		}
+		groups[t] = append(groups[t], data[i])
+	}
+	return groups, routed, nil
+}
+
+// removeAnnotation removes the annotation key from u, and its annotations when
+// none are left, so that a patch does not carry an empty annotations object.
+func removeAnnotation(u *unstructured.Unstructured, key string) {
+	a := u.GetAnnotations()
+	delete(a, key)
+	if len(a) == 0 {
+		a = nil
+	}
+	u.SetAnnotations(a)
+}
+
+// processRouted processes each group of routed objects with its own target,
+// and merges their results.
+func processRouted(dxr *resource.Composite, oxr *resource.Composite, desired map[resource.Name]*resource.DesiredComposed, observed map[resource.Name]resource.ObservedComposed, extraResources map[string]*fnv1.ResourceSelector, requiredResources map[string]*fnv1.ResourceSelector, conditions *ConditionResources, events *EventResources, contextData *map[string]interface{}, target Target, resources ResourceList, opts *AddResourcesOptions, groups map[Target][]unstructured.Unstructured) (AddResourcesResult, error) {
+	result := AddResourcesResult{
+		Target: target,
+		Object: opts.Data,
+	}
+	for _, t := range routeOrder {
+		data, ok := groups[t]
+		if !ok {
+			continue
+		}
+		o := *opts
+		o.Data = data
+		r, err := ProcessResources(dxr, oxr, desired, observed, extraResources, requiredResources, conditions, events, contextData, t, resources, &o)
+		if err != nil {
+			return result, errors.Wrapf(err, "cannot process objects routed to the %s target", t)
+		}
+		result.MsgCount += r.MsgCount
+		result.Msgs = append(result.Msgs, r.Msgs...)
+	}
+	sort.Strings(result.Msgs)
+	return result, nil
+}
@@ -0,0 +1,100 @@
+package resource
+
+import (
+	"testing"
+
+	fnv1 "github.com/crossplane/function-sdk-go/proto/v1"
+	res "github.com/crossplane/function-sdk-go/resource"
+	"github.com/crossplane/function-sdk-go/resource/composed"
+	"github.com/crossplane/function-sdk-go/resource/composite"
+	"github.com/google/go-cmp/cmp"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func TestProcessResourcesRouted(t *testing.T) {
+	obj := func(o map[string]interface{}) unstructured.Unstructured {
+		return unstructured.Unstructured{Object: o}
+	}
+	dxr := &res.Composite{Resource: composite.New(), ConnectionDetails: res.ConnectionDetails{}}
+	dxr.Resource.SetAPIVersion("example.org/v1")
+	dxr.Resource.SetKind("XR")
+	oxr := &res.Composite{Resource: composite.New()}
+	oxr.Resource.SetAPIVersion("example.org/v1")
+	oxr.Resource.SetKind("XR")
+	desired := map[res.Name]*res.DesiredComposed{
+		"bucket": {Resource: &composed.Unstructured{Unstructured: obj(map[string]interface{}{
+			"apiVersion": "s3.aws.upbound.io/v1beta1",
+			"kind":       "Bucket",
+			"metadata":   map[string]interface{}{"name": "bucket"},
+		})}},
+	}
+	data := []unstructured.Unstructured{
+		obj(map[string]interface{}{
+			"apiVersion": "s3.aws.upbound.io/v1beta1",
+			"kind":       "BucketPolicy",
+			"metadata":   map[string]interface{}{"name": "policy"},
+		}),
+		obj(map[string]interface{}{
+			"apiVersion": "s3.aws.upbound.io/v1beta1",
+			"kind":       "Bucket",
+			"metadata": map[string]interface{}{
+				"name":        "bucket",
+				"annotations": map[string]interface{}{AnnotationKeyTarget: string(PatchDesired)},
+			},
+			"spec": map[string]interface{}{"region": "eu-west-1"},
+		}),
+		obj(map[string]interface{}{
+			"apiVersion": "example.org/v1",
+			"kind":       "XR",
+			"metadata": map[string]interface{}{
+				"annotations": map[string]interface{}{AnnotationKeyTarget: string(XR)},
+			},
+			"status": map[string]interface{}{"ready": true},
+		}),
+	}
+
+	result, err := ProcessResources(dxr, oxr, desired, nil, map[string]*fnv1.ResourceSelector{}, map[string]*fnv1.ResourceSelector{}, &ConditionResources{}, &EventResources{}, &map[string]interface{}{}, Resources, nil, &AddResourcesOptions{Data: data, Overwrite: true})
+	if err != nil {
+		t.Fatalf("ProcessResources(...): unexpected error %v", err)
+	}
+	if result.MsgCount != 3 {
+		t.Errorf("ProcessResources(...): want 3 messages, got %d: %v", result.MsgCount, result.Msgs)
+	}
+
+	want := map[res.Name]map[string]interface{}{
+		"bucket": {
+			"apiVersion": "s3.aws.upbound.io/v1beta1",
+			"kind":       "Bucket",
+			"metadata":   map[string]interface{}{"name": "bucket"},
+			"spec":       map[string]interface{}{"region": "eu-west-1"},
+		},
+		"policy": {
+			"apiVersion": "s3.aws.upbound.io/v1beta1",
+			"kind":       "BucketPolicy",
+			"metadata":   map[string]interface{}{"name": "policy"},
+		},
+	}
+	got := make(map[res.Name]map[string]interface{}, len(desired))
+	for n, cd := range desired {
+		got[n] = cd.Resource.Object
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("ProcessResources(...): desired -want, +got:\n%s", diff)
+	}
+	if ready, _ := dxr.Resource.GetBool("status.ready"); !ready {
+		t.Errorf("ProcessResources(...): want the XR patched with status.ready, got %v", dxr.Resource.Object)
+	}
+	if _, found := dxr.Resource.GetAnnotations()[AnnotationKeyTarget]; found {
+		t.Errorf("ProcessResources(...): want the %q annotation removed from the XR", AnnotationKeyTarget)
+	}
+}
+
+func TestRouteTargetsInvalid(t *testing.T) {
+	data := []unstructured.Unstructured{{Object: map[string]interface{}{
+		"kind":     "Bucket",
+		"metadata": map[string]interface{}{"name": "a", "annotations": map[string]interface{}{AnnotationKeyTarget: "Nowhere"}},
+	}}}
+	if _, _, err := routeTargets(data, Default); err == nil {
+		t.Errorf("routeTargets(...): want an error for an unknown target")
+	}
+}
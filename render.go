@@ -2,23 +2,28 @@ package main
 
 import (
 	"bytes"
+	"crypto/sha256"
 	"encoding/json"
 	"os"
 	"path/filepath"
+	"slices"
 	"sort"
 	"strings"
 
+	"github.com/crossplane/crossplane-runtime/v2/pkg/errors"
+	getter "github.com/hashicorp/go-getter"
+	"k8s.io/apimachinery/pkg/runtime"
 	"kcl-lang.io/cli/pkg/options"
 	"kcl-lang.io/kcl-go/pkg/kcl"
-	"kcl-lang.io/kpm/pkg/client"
 	"kcl-lang.io/krm-kcl/pkg/edit"
 	"kcl-lang.io/krm-kcl/pkg/source"
 	"sigs.k8s.io/kustomize/kyaml/kio"
+	kyaml "sigs.k8s.io/kustomize/kyaml/yaml"
 
-	fkcl "github.com/crossplane-contrib/function-kcl/input/v1alpha1"
+	fkcl "github.com/crossplane-contrib/function-kcl/input/v1beta1"
 )
 
-// The default render path serializes the whole KCLInput to YAML, hands it to the
+// The krm-kcl render path serializes the whole KCLInput to YAML, hands it to the
 // krm-kcl byte-stream pipeline, which parses it back into kyaml RNodes, and then
 // re-serializes those RNodes to JSON to build the KCL top-level arguments:
 //
@@ -29,32 +34,51 @@ import (
 // a fat status subresource) it dominates the cost of a RunFunction call, along
 // with the GC pressure from the allocation churn it creates.
 //
-// renderInline skips it: it assembles the KCL arguments straight from the bytes
-// we already have and invokes the KCL runtime directly. It handles the inline
+// renderInline skips it: it assembles the KCL arguments straight from the params
+// encodeParams already wrote and invokes the KCL runtime directly. It handles the inline
 // `source` case, which is the common one for Crossplane compositions; anything
-// else (oci://, git, http, a local path) falls back to the krm-kcl pipeline.
+// else (oci://, git, http, a local path) is fetched by renderRemote and then
+// run with the same arguments, so the krm-kcl pipeline is no longer used.
 
-// renderInline runs the KCL program without the YAML round trip. ok is false when
-// the input is not something this path handles, in which case the caller must
-// fall back to the krm-kcl pipeline.
-func renderInline(in *fkcl.KCLInput) (out []byte, ok bool, err error) {
-	if !isInlineSource(in.Spec.Source) {
+// The output takes the same shortcut. The KCL runtime hands back a JSON result
+// next to the YAML one, so the items are sliced out of it and decoded once,
+// without splitting a YAML stream and normalizing its keys. Output is a JSON
+// array of items on every render path.
+
+// renderInline runs the KCL program without the YAML round trip and returns the
+// items it emits as a JSON array. params is option("params") as a JSON object
+// and env is option("env"). Dependencies are fetched with auth, which may be
+// nil, and which is closed before the program runs. ok is false when the input
+// is not something this path handles, in which case the caller must fetch it
+// with renderRemote.
+func renderInline(in *fkcl.KCLInput, params []byte, env map[string]string, auth *registryAuth) (out []byte, ok bool, err error) {
+	if len(in.Spec.Files) == 0 && !isInlineSource(in.Spec.Source) {
 		return nil, false, nil
 	}
 
-	// Resolve dependencies the same way krm-kcl's KCLRun.Transform does.
+	// Resolve dependencies the same way krm-kcl's KCLRun.Transform does. In
+	// vendor mode kpm resolves the kcl.mod written next to the program itself.
+	deps := in.Spec.Dependencies
+	if !in.Spec.Config.Vendor {
+		if deps, err = withModDependencies(deps, in.Spec.KclMod); err != nil {
+			return nil, true, err
+		}
+	}
 	var dependencies []string
-	if in.Spec.Dependencies != "" {
-		cli, err := client.NewKpmClient()
+	if deps != "" {
+		cli, err := auth.client()
 		if err != nil {
 			return nil, true, err
 		}
-		if dependencies, err = edit.LoadDepListFromConfig(cli, in.Spec.Dependencies); err != nil {
+		if dependencies, err = edit.LoadDepListFromConfig(cli, deps); err != nil {
 			return nil, true, err
 		}
 	}
+	if err := auth.Close(); err != nil {
+		return nil, true, errors.Wrap(err, "cannot remove registry credentials")
+	}
 
-	args, err := kclArguments(in)
+	args, err := kclArguments(in, params, env)
 	if err != nil {
 		return nil, true, err
 	}
@@ -62,10 +86,32 @@ func renderInline(in *fkcl.KCLInput) (out []byte, ok bool, err error) {
 	buf := bytes.NewBuffer(nil)
 	if !in.Spec.Config.Vendor {
 		opts := []kcl.Option{
-			kcl.WithCode(in.Spec.Source),
 			kcl.WithOptions(args...),
 			kcl.WithExternalPkgs(dependencies...),
 		}
+		// A single source is compiled from memory. A multi-file module has to
+		// be on disk so that its files can import each other, and so do inline
+		// kcl.mod and settings files.
+		entry, workDir := "prog.k", ""
+		if !needsWorkDir(in) {
+			opts = append(opts, kcl.WithCode(in.Spec.Source))
+		} else {
+			if workDir, err = os.MkdirTemp("", "kcl-sandbox"); err != nil {
+				return nil, true, err
+			}
+			defer os.RemoveAll(workDir)
+			if entry, err = writeModule(workDir, in); err != nil {
+				return nil, true, err
+			}
+			settings, err := writeSettings(workDir, in)
+			if err != nil {
+				return nil, true, err
+			}
+			for _, setting := range settings {
+				opts = append(opts, kcl.WithSettings(setting))
+			}
+			opts = append(opts, kcl.WithWorkDir(workDir))
+		}
 		for _, setting := range in.Spec.Config.Settings {
 			opts = append(opts, kcl.WithSettings(setting))
 		}
@@ -79,9 +125,13 @@ func renderInline(in *fkcl.KCLInput) (out []byte, ok bool, err error) {
 		exec.StrictRangeCheck = in.Spec.Config.StrictRangeCheck
 		opts = append(opts, *exec)
 
-		result, err := kcl.Run("prog.k", opts...)
+		result, err := kcl.Run(entry, opts...)
 		if err != nil {
-			return nil, true, err
+			return nil, true, relativeTo(workDir, err)
+		}
+		items, ok, err := itemsFromJSON(result.GetRawYamlResult(), result.GetRawJsonResult())
+		if err != nil || ok {
+			return items, true, err
 		}
 		buf.WriteString(result.GetRawYamlResult())
 	} else {
@@ -90,23 +140,64 @@ func renderInline(in *fkcl.KCLInput) (out []byte, ok bool, err error) {
 		}
 	}
 
-	// KCL emits every top-level variable; krm-kcl's contract is that the resources
-	// live under `items`. Unwrap exactly as SimpleTransformer.Transform does. This
-	// operates on the output, which is small — the saving is all on the input side.
+	out, err = unwrapItems(buf)
+	return out, true, err
+}
+
+// unwrapItems returns the items of the YAML output of a KCL program as a JSON
+// array. KCL emits every top-level variable; krm-kcl's contract is that the
+// resources live under `items`. Unwrap exactly as SimpleTransformer.Transform
+// does.
+func unwrapItems(buf *bytes.Buffer) ([]byte, error) {
 	nodes, err := (&kio.ByteReader{Reader: buf, OmitReaderAnnotations: true}).Read()
 	if err != nil {
-		return nil, true, err
+		return nil, err
 	}
 	items, _, err := edit.UnwrapResources(nodes)
 	if err != nil {
-		return nil, true, err
+		return nil, err
 	}
+	return itemsJSON(items)
+}
 
-	res := bytes.NewBuffer(nil)
-	if err := (&kio.ByteWriter{Writer: res}).Write(items); err != nil {
-		return nil, true, err
+// itemsFromJSON unwraps the JSON result of a KCL program the way
+// edit.UnwrapResources unwraps its YAML: the elements of a top-level items
+// list, or else the result itself as the only item. ok is false when the YAML
+// result is a stream of several documents, e.g. from manifests.yaml_stream,
+// which the JSON result does not carry.
+func itemsFromJSON(yamlResult, jsonResult string) (items []byte, ok bool, err error) {
+	if jsonResult == "" || strings.HasPrefix(yamlResult, "---") || strings.Contains(yamlResult, "\n---") {
+		return nil, false, nil
+	}
+	var result struct {
+		Items json.RawMessage `json:"items"`
+	}
+	if err := json.Unmarshal([]byte(jsonResult), &result); err != nil {
+		return nil, true, errors.Wrap(err, "cannot parse the KCL result")
+	}
+	if bytes.HasPrefix(result.Items, []byte("[")) {
+		return result.Items, true, nil
+	}
+	items = make([]byte, 0, len(jsonResult)+2)
+	items = append(items, '[')
+	items = append(items, jsonResult...)
+	return append(items, ']'), true, nil
+}
+
+// itemsJSON returns nodes as a JSON array.
+func itemsJSON(nodes []*kyaml.RNode) ([]byte, error) {
+	out := []byte{'['}
+	for i, n := range nodes {
+		if i > 0 {
+			out = append(out, ',')
+		}
+		b, err := n.MarshalJSON()
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, b...)
 	}
-	return res.Bytes(), true, nil
+	return append(out, ']'), nil
 }
 
 func renderInlineVendor(in *fkcl.KCLInput, dependencies, args []string, buf *bytes.Buffer) error {
@@ -116,14 +207,23 @@ func renderInlineVendor(in *fkcl.KCLInput, dependencies, args []string, buf *byt
 	}
 	defer os.RemoveAll(dir)
 
-	prog := filepath.Join(dir, "prog.k")
-	if err := os.WriteFile(prog, []byte(in.Spec.Source), 0o600); err != nil {
+	prog, err := writeModule(dir, in)
+	if err != nil {
+		return err
+	}
+	settings, err := writeSettings(dir, in)
+	if err != nil {
 		return err
 	}
+	return relativeTo(dir, runCLI(in, prog, dependencies, args, settings, buf))
+}
 
+// runCLI runs entry the way the kcl CLI does, writing its YAML output to buf.
+// settings are settings files in addition to those of the config of in.
+func runCLI(in *fkcl.KCLInput, entry string, dependencies, args, settings []string, buf *bytes.Buffer) error {
 	opts := options.NewRunOptions()
 	opts.NoStyle = true
-	opts.Entries = []string{prog}
+	opts.Entries = []string{entry}
 	opts.Arguments = args
 	opts.Writer = buf
 	if len(dependencies) > 0 {
@@ -134,12 +234,11 @@ func renderInlineVendor(in *fkcl.KCLInput, dependencies, args []string, buf *byt
 		opts.DisableNone = c.DisableNone
 		opts.Overrides = c.Overrides
 		opts.PathSelectors = c.PathSelectors
-		opts.Settings = c.Settings
+		opts.Settings = append(slices.Clip(c.Settings), settings...)
 		opts.ShowHidden = c.ShowHidden
 		opts.SortKeys = c.SortKeys
 		opts.StrictRangeCheck = c.StrictRangeCheck
 		opts.Vendor = c.Vendor
-		opts.Arguments = append(opts.Arguments, c.Arguments...)
 	}
 
 	if err := opts.Complete([]string{}); err != nil {
@@ -148,12 +247,141 @@ func renderInlineVendor(in *fkcl.KCLInput, dependencies, args []string, buf *byt
 	if err := opts.Validate(); err != nil {
 		return err
 	}
-	if err := opts.Run(); err != nil {
+	return opts.Run()
+}
+
+// needsWorkDir reports whether the program has to be written to disk to run.
+func needsWorkDir(in *fkcl.KCLInput) bool {
+	return len(in.Spec.Files) > 0 || in.Spec.KclMod != "" || in.Spec.KclModLock != "" || len(in.Spec.Config.InlineSettings) > 0
+}
+
+// writeModule lays out the KCL program under dir and returns the path of the
+// file to run: spec.files as a module with its entry, or spec.source as
+// prog.k, along with spec.kclMod and spec.kclModLock. A kcl.mod is added when
+// the files do not bring their own, so that dir is the module root that
+// imports resolve against.
+func writeModule(dir string, in *fkcl.KCLInput) (string, error) {
+	if err := writeModFiles(dir, in); err != nil {
+		return "", err
+	}
+	if len(in.Spec.Files) == 0 {
+		prog := filepath.Join(dir, "prog.k")
+		return prog, os.WriteFile(prog, []byte(in.Spec.Source), 0o600)
+	}
+	for name, content := range in.Spec.Files {
+		p := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(p), 0o700); err != nil {
+			return "", err
+		}
+		if err := os.WriteFile(p, []byte(content), 0o600); err != nil {
+			return "", err
+		}
+	}
+	if _, ok := in.Spec.Files[kclModFile]; !ok && in.Spec.KclMod == "" {
+		if err := os.WriteFile(filepath.Join(dir, kclModFile), []byte(kclModDefault), 0o600); err != nil {
+			return "", err
+		}
+	}
+	return filepath.Join(dir, filepath.FromSlash(in.EntryFile())), nil
+}
+
+// renderRemote fetches a remote or local source and runs it with the same
+// arguments as renderInline, returning its items as a JSON array. The krm-kcl
+// pipeline used to run such sources, setting option("env") from the whole
+// environment of the function; running them here means KCL only ever sees env.
+// The source and its dependencies, those of its own kcl.mod included, are
+// fetched with auth, which may be nil, and which is closed before the program
+// runs.
+func renderRemote(in *fkcl.KCLInput, params []byte, env map[string]string, auth *registryAuth) ([]byte, error) {
+	dir, err := os.MkdirTemp("", "kcl-source")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	entry, err := fetchSource(in.Spec.Source, filepath.Join(dir, "src"), auth)
+	if err != nil {
+		return nil, err
+	}
+	var dependencies []string
+	if !in.Spec.Config.Vendor {
+		deps, err := remoteDependencies(in, entry)
+		if err != nil {
+			return nil, err
+		}
+		if deps != "" {
+			cli, err := auth.client()
+			if err != nil {
+				return nil, err
+			}
+			if dependencies, err = edit.LoadDepListFromConfig(cli, deps); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if err := auth.Close(); err != nil {
+		return nil, errors.Wrap(err, "cannot remove registry credentials")
+	}
+	settings, err := writeSettings(dir, in)
+	if err != nil {
+		return nil, err
+	}
+	args, err := kclArguments(in, params, env)
+	if err != nil {
+		return nil, err
+	}
+
+	buf := bytes.NewBuffer(nil)
+	if err := runCLI(in, entry, dependencies, args, settings, buf); err != nil {
+		return nil, relativeTo(dir, err)
+	}
+	return unwrapItems(buf)
+}
+
+// fetchSource fetches a remote source into dst and returns the path to run: an
+// oci:// module is pulled with kpm, and git, HTTP and VCS addresses are fetched
+// with go-getter, as krm-kcl fetches them. A local path is returned as it is.
+func fetchSource(src, dst string, auth *registryAuth) (string, error) {
+	switch {
+	case source.IsOCI(src):
+		return auth.pull(src, dst)
+	case source.IsLocal(src):
+		return src, nil
+	}
+	if err := getter.GetAny(dst, src); err != nil {
+		return "", errors.Wrapf(err, "cannot fetch %s", src)
+	}
+	// A single file is fetched into dst under its own name.
+	entries, err := os.ReadDir(dst)
+	if err != nil {
+		return "", err
+	}
+	if len(entries) == 1 && !entries[0].IsDir() && strings.HasSuffix(entries[0].Name(), ".k") {
+		return filepath.Join(dst, entries[0].Name()), nil
+	}
+	return dst, nil
+}
+
+// relativeTo strips dir from the file paths in err, so that KCL diagnostics
+// name files as they appear in spec.files rather than in a temporary directory.
+// The returned error wraps err, so that it is classified like err.
+func relativeTo(dir string, err error) error {
+	if err == nil || dir == "" || !strings.Contains(err.Error(), dir) {
 		return err
 	}
-	return nil
+	return &relativeError{msg: strings.ReplaceAll(err.Error(), dir+string(filepath.Separator), ""), err: err}
 }
 
+// relativeError is an error whose message is rewritten by relativeTo.
+type relativeError struct {
+	msg string
+	err error
+}
+
+func (e *relativeError) Error() string { return e.msg }
+
+func (e *relativeError) Unwrap() error { return e.err }
+
 func boolToInt32(v bool) int32 {
 	if v {
 		return 1
@@ -162,9 +390,54 @@ func boolToInt32(v bool) int32 {
 }
 
 // renderKey returns bytes that uniquely identify a render: source, dependencies,
-// params, config and target all live in the input. It is JSON rather than YAML
-// because the params are already JSON, so this is a single cheap pass.
-func renderKey(in *fkcl.KCLInput) ([]byte, error) { return json.Marshal(in) }
+// config and target live in the input, params are hashed as encoded rather
+// than marshalled again with it, and env is the environment exposed to it.
+func renderKey(in *fkcl.KCLInput, params []byte, env map[string]string) ([]byte, error) {
+	spec, err := marshalWithoutParams(in)
+	if err != nil {
+		return nil, err
+	}
+	e, err := json.Marshal(env)
+	if err != nil {
+		return nil, err
+	}
+	h := sha256.New()
+	h.Write(spec)
+	h.Write([]byte{0})
+	h.Write(e)
+	h.Write([]byte{0})
+	h.Write(params)
+	return h.Sum(nil), nil
+}
+
+// marshalWithoutParams returns in as JSON, leaving out its params.
+func marshalWithoutParams(in *fkcl.KCLInput) ([]byte, error) {
+	cp := *in
+	cp.Spec.Params = nil
+	return json.Marshal(&cp)
+}
+
+// functionConfig returns in as JSON with params spliced into its spec, so that
+// they are copied rather than marshalled again. spec is the last field of a
+// KCLInput, so it closes just before the input does.
+func functionConfig(in *fkcl.KCLInput, params []byte) ([]byte, error) {
+	b, err := marshalWithoutParams(in)
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.HasSuffix(b, []byte("}}")) {
+		return nil, errors.Errorf("cannot find the spec of %s", b)
+	}
+	end := len(b) - 2
+	out := make([]byte, 0, len(b)+len(params)+len(`,"params":`))
+	out = append(out, b[:end]...)
+	if out[len(out)-1] != '{' {
+		out = append(out, ',')
+	}
+	out = append(out, `"params":`...)
+	out = append(out, params...)
+	return append(out, b[end:]...), nil
+}
 
 // isInlineSource mirrors the fallthrough branch of krm-kcl's SourceToTempEntry:
 // anything that is not a recognised remote or local location is inline KCL code.
@@ -176,18 +449,13 @@ func isInlineSource(src string) bool {
 		!source.IsVCSDomain(src)
 }
 
-// kclArguments builds the KCL top-level arguments directly from the input. The
-// params are already JSON (runtime.RawExtension), so the payload is copied rather
-// than re-encoded.
-func kclArguments(in *fkcl.KCLInput) ([]string, error) {
-	// functionConfig is the KCLRun itself. RawExtension marshals as raw JSON, so
-	// this is a single pass over the payload.
-	fc, err := json.Marshal(in)
-	if err != nil {
-		return nil, err
-	}
-
-	params, err := paramsJSON(in)
+// kclArguments builds the KCL top-level arguments directly from the input, its
+// encoded params and the env exposed to it, followed by config.arguments, which
+// include those set by paramsFrom. The params are copied rather than
+// re-encoded.
+func kclArguments(in *fkcl.KCLInput, params []byte, env map[string]string) ([]string, error) {
+	// functionConfig is the KCLRun itself, params included.
+	fc, err := functionConfig(in, params)
 	if err != nil {
 		return nil, err
 	}
@@ -197,57 +465,51 @@ func kclArguments(in *fkcl.KCLInput) ([]string, error) {
 	rl.Write(fc)
 	rl.WriteByte('}')
 
-	env, err := envJSON()
+	envArgs, err := envArguments(env)
+	if err != nil {
+		return nil, err
+	}
+
+	typedArgs, err := typedArguments(in.Spec.Config.TypedArguments)
 	if err != nil {
 		return nil, err
 	}
 
-	return []string{
+	args := append([]string{
 		"resource_list=" + rl.String(),
 		"items=[]",
 		"params=" + string(params),
-		"PATH=" + os.Getenv("PATH"),
-		"env=" + string(env),
-	}, nil
+	}, envArgs...)
+	args = append(args, typedArgs...)
+	return append(args, in.Spec.Config.Arguments...), nil
 }
 
-// paramsJSON assembles {"oxr":<raw>,"dxr":<raw>,...} from the raw JSON we hold.
-// Keys are sorted so the result is deterministic.
-func paramsJSON(in *fkcl.KCLInput) ([]byte, error) {
-	keys := make([]string, 0, len(in.Spec.Params))
-	for k := range in.Spec.Params {
-		keys = append(keys, k)
+// typedArguments returns the arguments for typed, sorted by name. Each value
+// is passed as compact JSON, which option() decodes with its type; a string is
+// passed quoted, so that e.g. "1" stays a string.
+func typedArguments(typed map[string]runtime.RawExtension) ([]string, error) {
+	names := make([]string, 0, len(typed))
+	for name := range typed {
+		names = append(names, name)
 	}
-	sort.Strings(keys)
-
+	sort.Strings(names)
+	args := make([]string, 0, len(names))
 	var b bytes.Buffer
-	b.WriteByte('{')
-	for i, k := range keys {
-		if i > 0 {
-			b.WriteByte(',')
-		}
-		kb, err := json.Marshal(k)
-		if err != nil {
-			return nil, err
-		}
-		b.Write(kb)
-		b.WriteByte(':')
-		if raw := in.Spec.Params[k].Raw; len(raw) > 0 {
-			b.Write(raw)
-		} else {
-			b.WriteString("{}")
+	for _, name := range names {
+		b.Reset()
+		if err := json.Compact(&b, typed[name].Raw); err != nil {
+			return nil, errors.Wrapf(err, "cannot encode argument %q", name)
 		}
+		args = append(args, name+"="+b.String())
 	}
-	b.WriteByte('}')
-	return b.Bytes(), nil
+	return args, nil
 }
 
-func envJSON() ([]byte, error) {
-	m := make(map[string]string, len(os.Environ()))
-	for _, e := range os.Environ() {
-		if kv := strings.SplitN(e, "=", 2); len(kv) == 2 {
-			m[kv[0]] = kv[1]
-		}
+// envArguments returns the PATH and env arguments for env.
+func envArguments(env map[string]string) ([]string, error) {
+	b, err := json.Marshal(env)
+	if err != nil {
+		return nil, err
 	}
-	return json.Marshal(m)
+	return []string{"PATH=" + env["PATH"], "env=" + string(b)}, nil
 }
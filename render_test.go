@@ -4,20 +4,27 @@ import (
 	"bytes"
 	"encoding/json"
 	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
 	"os"
+	"os/exec"
 	"path/filepath"
 	"sort"
 	"strings"
 	"testing"
 
+	fnv1 "github.com/crossplane/function-sdk-go/proto/v1"
+	"github.com/google/go-cmp/cmp"
 	"k8s.io/apimachinery/pkg/runtime"
 	"kcl-lang.io/krm-kcl/pkg/api"
 	"kcl-lang.io/krm-kcl/pkg/api/v1alpha1"
 	krmkio "kcl-lang.io/krm-kcl/pkg/kio"
+	"oras.land/oras-go/v2/registry/remote/errcode"
 	"sigs.k8s.io/kustomize/kyaml/kio"
 	"sigs.k8s.io/yaml"
 
-	fkcl "github.com/crossplane-contrib/function-kcl/input/v1alpha1"
+	fkcl "github.com/crossplane-contrib/function-kcl/input/v1beta1"
 )
 
 // renderViaPipeline is the pre-existing path: marshal the input to YAML and let
@@ -36,19 +43,34 @@ func renderViaPipeline(t testing.TB, in *fkcl.KCLInput) []byte {
 	return out.Bytes()
 }
 
-// canonical renders KRM output into a stable, comparable form.
+// canonical renders KRM output, either a YAML stream or a JSON array of items,
+// into a stable, comparable form.
 func canonical(t testing.TB, b []byte) string {
 	t.Helper()
-	nodes, err := (&kio.ByteReader{Reader: bytes.NewBuffer(b), OmitReaderAnnotations: true}).Read()
-	if err != nil {
-		t.Fatalf("reading KRM output: %v", err)
-	}
-	docs := make([]string, 0, len(nodes))
-	for _, n := range nodes {
-		j, err := n.MarshalJSON()
+	var items [][]byte
+	if bytes.HasPrefix(bytes.TrimSpace(b), []byte("[")) {
+		var raw []json.RawMessage
+		if err := json.Unmarshal(b, &raw); err != nil {
+			t.Fatalf("reading JSON output: %v", err)
+		}
+		for _, r := range raw {
+			items = append(items, r)
+		}
+	} else {
+		nodes, err := (&kio.ByteReader{Reader: bytes.NewBuffer(b), OmitReaderAnnotations: true}).Read()
 		if err != nil {
-			t.Fatalf("marshalling node: %v", err)
+			t.Fatalf("reading KRM output: %v", err)
 		}
+		for _, n := range nodes {
+			j, err := n.MarshalJSON()
+			if err != nil {
+				t.Fatalf("marshalling node: %v", err)
+			}
+			items = append(items, j)
+		}
+	}
+	docs := make([]string, 0, len(items))
+	for _, j := range items {
 		var v any
 		if err := json.Unmarshal(j, &v); err != nil {
 			t.Fatalf("unmarshalling node: %v", err)
@@ -182,7 +204,7 @@ func TestRenderInlineMatchesPipeline(t *testing.T) {
 
 			want := canonical(t, renderViaPipeline(t, in))
 
-			got, ok, err := renderInline(in)
+			got, ok, err := renderInline(in, inlineParams(t, in), nil, nil)
 			if err != nil {
 				t.Fatalf("renderInline: %v", err)
 			}
@@ -197,7 +219,7 @@ func TestRenderInlineMatchesPipeline(t *testing.T) {
 }
 
 // TestRenderInlineDeclinesNonInlineSources: anything that is not inline code must
-// fall back to the krm-kcl pipeline, which knows how to fetch it.
+// fall back to renderRemote, which knows how to fetch it.
 func TestRenderInlineDeclinesNonInlineSources(t *testing.T) {
 	for _, src := range []string{
 		"oci://ghcr.io/kcl-lang/set-annotations",
@@ -208,8 +230,109 @@ func TestRenderInlineDeclinesNonInlineSources(t *testing.T) {
 	} {
 		t.Run(src, func(t *testing.T) {
 			in := testInput(t, src, 0)
-			if _, ok, err := renderInline(in); ok || err != nil {
-				t.Errorf("expected fall back to the pipeline, got ok=%v err=%v", ok, err)
+			if _, ok, err := renderInline(in, inlineParams(t, in), nil, nil); ok || err != nil {
+				t.Errorf("expected fall back to renderRemote, got ok=%v err=%v", ok, err)
+			}
+		})
+	}
+}
+
+// TestRenderRemoteFiltersEnv: a fetched source must see only the env it is
+// given, never a secret from the environment of the function.
+func TestRenderRemoteFiltersEnv(t *testing.T) {
+	t.Setenv(envSourcePassword, "hunter2")
+	prog := filepath.Join(t.TempDir(), "main.k")
+	src := `items = [{apiVersion = "v1", kind = "ConfigMap", metadata.name = "env", data = option("env")}]`
+	if err := os.WriteFile(prog, []byte(src), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	in := testInput(t, prog, 0)
+	env := envPolicy{allow: []string{"PATH"}, deny: []string{envSourcePassword}}.environ(in)
+
+	got, err := renderRemote(in, inlineParams(t, in), env, nil)
+	if err != nil {
+		t.Fatalf("renderRemote: %v", err)
+	}
+	var items []struct {
+		Data map[string]string `json:"data"`
+	}
+	if err := json.Unmarshal(got, &items); err != nil || len(items) != 1 {
+		t.Fatalf("renderRemote: want one item, got %s (%v)", got, err)
+	}
+	if _, ok := items[0].Data[envSourcePassword]; ok {
+		t.Errorf("renderRemote: %s is exposed to KCL", envSourcePassword)
+	}
+	if _, ok := items[0].Data["PATH"]; !ok {
+		t.Errorf("renderRemote: PATH is not exposed to KCL")
+	}
+	if bytes.Contains(got, []byte("hunter2")) {
+		t.Errorf("renderRemote: the secret value is in the output %s", got)
+	}
+}
+
+// TestFetchSource: a source is fetched the way krm-kcl fetches it, a single
+// file as the file to run and anything else as the module directory.
+func TestFetchSource(t *testing.T) {
+	const prog = `items = [{apiVersion = "v1", kind = "ConfigMap", metadata.name = "fetched"}]`
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write([]byte(prog))
+	}))
+	defer srv.Close()
+
+	local := filepath.Join(t.TempDir(), "main.k")
+	if err := os.WriteFile(local, []byte(prog), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	repo := t.TempDir()
+	if err := os.WriteFile(filepath.Join(repo, "main.k"), []byte(prog), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	for _, args := range [][]string{
+		{"init", "-q"},
+		{"add", "main.k"},
+		{"-c", "user.name=test", "-c", "user.email=test@example.com", "commit", "-q", "-m", "init"},
+	} {
+		cmd := exec.Command("git", args...)
+		cmd.Dir = repo
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Skipf("git %v: %v: %s", args, err, out)
+		}
+	}
+
+	cases := map[string]struct {
+		src  string
+		want string
+	}{
+		"Local": {
+			src:  local,
+			want: local,
+		},
+		"HTTP": {
+			src:  srv.URL + "/main.k",
+			want: "main.k",
+		},
+		"Git": {
+			src:  "git::file://" + filepath.ToSlash(repo),
+			want: "",
+		},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			dst := filepath.Join(t.TempDir(), "src")
+			got, err := fetchSource(tc.src, dst, nil)
+			if err != nil {
+				t.Fatalf("fetchSource(%s): %v", tc.src, err)
+			}
+			want := tc.want
+			if !filepath.IsAbs(want) {
+				want = filepath.Join(dst, want)
+			}
+			if got != want {
+				t.Errorf("fetchSource(%s): want %s, got %s", tc.src, want, got)
+			}
+			if _, err := os.Stat(filepath.Join(strings.TrimSuffix(got, "main.k"), "main.k")); err != nil {
+				t.Errorf("fetchSource(%s): the program was not fetched: %v", tc.src, err)
 			}
 		})
 	}
@@ -222,7 +345,8 @@ func TestRenderInlineDoesNotCreateTempFile(t *testing.T) {
 	}
 	t.Setenv("TMPDIR", notDir)
 
-	got, ok, err := renderInline(testInput(t, srcEmit, 0))
+	emit := testInput(t, srcEmit, 0)
+	got, ok, err := renderInline(emit, inlineParams(t, emit), nil, nil)
 	if err != nil {
 		t.Fatalf("renderInline: %v", err)
 	}
@@ -234,6 +358,199 @@ func TestRenderInlineDoesNotCreateTempFile(t *testing.T) {
 	}
 }
 
+// moduleFiles is srcEmit split into a module whose entry imports a helper package.
+var moduleFiles = map[string]string{
+	"main.k": `
+import helpers.thing
+
+items = [thing.make(option("params").oxr)]
+`,
+	"helpers/thing.k": `
+make = lambda oxr {
+    {
+        apiVersion = "example.org/v1"
+        kind = "Thing"
+        metadata.name = str(oxr.spec.name)
+        spec.replicas = int(oxr.spec.replicas)
+    }
+}
+`,
+}
+
+// TestRenderInlineFiles: a multi-file module renders the same as the equivalent
+// single source, in both the direct and the vendor path.
+func TestRenderInlineFiles(t *testing.T) {
+	emit := testInput(t, srcEmit, 0)
+	single, _, err := renderInline(emit, inlineParams(t, emit), nil, nil)
+	if err != nil {
+		t.Fatalf("renderInline: %v", err)
+	}
+	want := canonical(t, single)
+
+	for _, vendor := range []bool{false, true} {
+		t.Run(fmt.Sprintf("vendor=%v", vendor), func(t *testing.T) {
+			in := testInput(t, "", 0)
+			in.Spec.Files = moduleFiles
+			in.Spec.Config.Vendor = vendor
+
+			got, ok, err := renderInline(in, inlineParams(t, in), nil, nil)
+			if err != nil {
+				t.Fatalf("renderInline: %v", err)
+			}
+			if !ok {
+				t.Fatal("renderInline declined inline files")
+			}
+			if g := canonical(t, got); g != want {
+				t.Errorf("output differs\n--- source ---\n%s\n\n--- files ---\n%s", want, g)
+			}
+		})
+	}
+
+	t.Run("diagnostics name module files", func(t *testing.T) {
+		in := testInput(t, "", 0)
+		in.Spec.Files = map[string]string{"main.k": moduleFiles["main.k"], "helpers/thing.k": "make = 1 +"}
+		_, _, err := renderInline(in, inlineParams(t, in), nil, nil)
+		if err == nil {
+			t.Fatal("renderInline: want error for invalid helper")
+		}
+		if !strings.Contains(err.Error(), "helpers/thing.k") || strings.Contains(err.Error(), "kcl-sandbox") {
+			t.Errorf("renderInline: want error relative to the module root, got %v", err)
+		}
+	})
+}
+
+// TestRenderInlineSettings: inline kcl.yaml settings reach the program the same
+// way settings files do, in both the direct and the vendor path.
+func TestRenderInlineSettings(t *testing.T) {
+	const src = `
+items = [{
+    apiVersion = "example.org/v1"
+    kind = "Thing"
+    metadata.name = "thing"
+    metadata.labels = {"env" = option("env_name")}
+}]
+`
+	for _, vendor := range []bool{false, true} {
+		t.Run(fmt.Sprintf("vendor=%v", vendor), func(t *testing.T) {
+			in := testInput(t, src, 0)
+			in.Spec.KclMod = "[package]\nname = \"app\"\n"
+			in.Spec.Config.InlineSettings = []string{"kcl_options:\n  - key: env_name\n    value: prod\n"}
+			in.Spec.Config.Vendor = vendor
+
+			got, _, err := renderInline(in, inlineParams(t, in), nil, nil)
+			if err != nil {
+				t.Fatalf("renderInline: %v", err)
+			}
+			if c := canonical(t, got); !strings.Contains(c, `"env":"prod"`) {
+				t.Errorf("renderInline: want the env_name option from the inline settings, got %s", c)
+			}
+		})
+	}
+}
+
+// TestRenderInlineTypedArguments: typed arguments reach option() with their
+// types, in both the direct and the vendor path.
+func TestRenderInlineTypedArguments(t *testing.T) {
+	const src = `
+items = [{
+    apiVersion = "example.org/v1"
+    kind = "Thing"
+    metadata.name = option("name")
+    spec.replicas = option("replicas") + 1
+    spec.tags = option("tags")
+    spec.port = option("ports").http
+}]
+`
+	for _, vendor := range []bool{false, true} {
+		t.Run(fmt.Sprintf("vendor=%v", vendor), func(t *testing.T) {
+			in := testInput(t, src, 0)
+			in.Spec.Config.TypedArguments = map[string]runtime.RawExtension{
+				"name":     {Raw: []byte(`"123"`)},
+				"replicas": {Raw: []byte(`2`)},
+				"tags":     {Raw: []byte(`["a", "b"]`)},
+				"ports":    {Raw: []byte(`{"http": 80}`)},
+			}
+			in.Spec.Config.Vendor = vendor
+
+			got, _, err := renderInline(in, inlineParams(t, in), nil, nil)
+			if err != nil {
+				t.Fatalf("renderInline: %v", err)
+			}
+			c := canonical(t, got)
+			for _, want := range []string{`"name":"123"`, `"replicas":3`, `"tags":["a","b"]`, `"port":80`} {
+				if !strings.Contains(c, want) {
+					t.Errorf("renderInline: want %s, got %s", want, c)
+				}
+			}
+		})
+	}
+}
+
+func TestTypedArguments(t *testing.T) {
+	got, err := typedArguments(map[string]runtime.RawExtension{
+		"tags":  {Raw: []byte("[\n  \"a\",\n  \"b\"\n]")},
+		"count": {Raw: []byte(`1`)},
+		"name":  {Raw: []byte(`"1"`)},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{`count=1`, `name="1"`, `tags=["a","b"]`}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("typedArguments(...): -want, +got:\n%s", diff)
+	}
+}
+
+// TestItemsFromJSON: the JSON result is unwrapped like krm-kcl unwraps YAML,
+// and a YAML stream falls back to it.
+func TestItemsFromJSON(t *testing.T) {
+	cases := map[string]struct {
+		yaml, json string
+		want       string
+		ok         bool
+	}{
+		"Items": {
+			yaml: "items:\n- a: 1\n",
+			json: `{"items":[{"a":1}],"other":2}`,
+			want: `[{"a":1}]`,
+			ok:   true,
+		},
+		"NoItems": {
+			yaml: "a: 1\n",
+			json: `{"a":1}`,
+			want: `[{"a":1}]`,
+			ok:   true,
+		},
+		"Stream": {
+			yaml: "a: 1\n---\nb: 2\n",
+			json: `{"a":1}`,
+		},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			got, ok, err := itemsFromJSON(tc.yaml, tc.json)
+			if err != nil {
+				t.Fatalf("itemsFromJSON: %v", err)
+			}
+			if ok != tc.ok || string(got) != tc.want {
+				t.Errorf("itemsFromJSON: want %s (ok=%v), got %s (ok=%v)", tc.want, tc.ok, got, ok)
+			}
+		})
+	}
+}
+
+func TestRelativeToKeepsCause(t *testing.T) {
+	dir := filepath.Join(os.TempDir(), "kcl-render")
+	cause := &errcode.ErrorResponse{StatusCode: http.StatusServiceUnavailable, URL: &url.URL{Path: filepath.Join(dir, "kcl.mod")}}
+	err := relativeTo(dir, fmt.Errorf("cannot resolve dependencies: %w", cause))
+	if strings.Contains(err.Error(), dir) {
+		t.Errorf("relativeTo(...): want %q stripped from %q", dir, err)
+	}
+	if !isTransient(err) {
+		t.Errorf("relativeTo(...): want the registry 503 it wraps to stay transient, got %v", err)
+	}
+}
+
 // BenchmarkRender shows the cost is dominated by the payload, not by the KCL.
 func BenchmarkRender(b *testing.B) {
 	for _, pad := range []int{0, 50_000, 200_000} {
@@ -247,9 +564,10 @@ func BenchmarkRender(b *testing.B) {
 			}
 		})
 		b.Run(fmt.Sprintf("inline/input=%dKB", size), func(b *testing.B) {
+			params := inlineParams(b, in)
 			b.ReportAllocs()
 			for i := 0; i < b.N; i++ {
-				if _, _, err := renderInline(in); err != nil {
+				if _, _, err := renderInline(in, params, nil, nil); err != nil {
 					b.Fatal(err)
 				}
 			}
@@ -257,6 +575,21 @@ func BenchmarkRender(b *testing.B) {
 	}
 }
 
+// inlineParams encodes the params of in as RunFunction does, for a request
+// without any built-in params.
+func inlineParams(t testing.TB, in *fkcl.KCLInput) []byte {
+	t.Helper()
+	p, err := newBuiltinParams(&fkcl.BuiltinParams{Include: []string{}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := p.encode(nil, &fnv1.RunFunctionRequest{}, in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
 func mustMarshal(t testing.TB, in *fkcl.KCLInput) []byte {
 	t.Helper()
 	b, err := yaml.Marshal(in)
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+
+	"github.com/crossplane/crossplane-runtime/v2/pkg/errors"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	openapierrors "k8s.io/kube-openapi/pkg/validation/errors"
+	"k8s.io/kube-openapi/pkg/validation/strfmt"
+	openapivalidate "k8s.io/kube-openapi/pkg/validation/validate"
+	"kcl-lang.io/kcl-go/pkg/kcl"
+	kclvalidate "kcl-lang.io/kcl-go/pkg/tools/validate"
+
+	fkcl "github.com/crossplane-contrib/function-kcl/input/v1beta1"
+)
+
+// KCL code that reads a param the claim did not set fails deep inside the
+// program, with an error that names a KCL line rather than the field. Params
+// with a schema are validated before the program runs so that violations are
+// reported as paths into the param instead, e.g.
+// oxr.spec.parameters.size: Required value.
+
+// validateParams validates each param of in that has a schema, reusing the
+// schemas cached in c. It returns the violations, with paths rooted at the
+// name of the param.
+func validateParams(c *schemaCache, in *fkcl.KCLInput) (field.ErrorList, error) {
+	names := make([]string, 0, len(in.Spec.Schemas))
+	for name := range in.Spec.Schemas {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var errs field.ErrorList
+	for _, name := range names {
+		path := field.NewPath(name)
+		raw, ok := in.Spec.Params[name]
+		if !ok || len(raw.Raw) == 0 {
+			errs = append(errs, field.Required(path, "param has a schema but is not set"))
+			continue
+		}
+		var value any
+		if err := json.Unmarshal(raw.Raw, &value); err != nil {
+			return nil, errors.Wrapf(err, "cannot parse param %q", name)
+		}
+		s := in.Spec.Schemas[name]
+		if s.KCL != nil {
+			verrs, err := c.validateKCLSchema(path, raw.Raw, value, s.KCL)
+			if err != nil {
+				return nil, errors.Wrapf(err, "cannot compile the KCL schema of param %q", name)
+			}
+			errs = append(errs, verrs...)
+			continue
+		}
+		verrs, err := c.validateOpenAPISchema(path, value, s.OpenAPIV3Schema.Raw)
+		if err != nil {
+			return nil, errors.Wrapf(err, "cannot parse the schema of param %q", name)
+		}
+		errs = append(errs, verrs...)
+	}
+	return errs, nil
+}
+
+// validateOpenAPISchema validates value against an OpenAPI v3 schema.
+func (c *schemaCache) validateOpenAPISchema(path *field.Path, value any, schema []byte) (field.ErrorList, error) {
+	s, err := c.openAPISchema(schema)
+	if err != nil {
+		return nil, err
+	}
+	result := openapivalidate.NewSchemaValidator(s, nil, "", strfmt.Default).Validate(value)
+
+	var errs field.ErrorList
+	for _, err := range result.Errors {
+		verr, ok := err.(*openapierrors.Validation)
+		if !ok {
+			errs = append(errs, field.Invalid(path, field.OmitValueType{}, err.Error()))
+			continue
+		}
+		p := path
+		if name := strings.TrimPrefix(verr.Name, "."); name != "" {
+			p = path.Child(name)
+		}
+		switch verr.Code() {
+		case openapierrors.RequiredFailCode:
+			errs = append(errs, field.Required(p, ""))
+		case openapierrors.EnumFailCode:
+			values := make([]string, 0, len(verr.Values))
+			for _, v := range verr.Values {
+				b, _ := json.Marshal(v)
+				values = append(values, strings.Trim(string(b), `"`))
+			}
+			errs = append(errs, field.NotSupported(p, verr.Value, values))
+		case openapierrors.InvalidTypeCode:
+			errs = append(errs, field.TypeInvalid(p, verr.Value, verr.Error()))
+		default:
+			errs = append(errs, field.Invalid(p, verr.Value, verr.Error()))
+		}
+	}
+	return errs, nil
+}
+
+// validateKCLSchema validates value, whose JSON is raw, against the named KCL
+// schema. The attributes and types of the schema are checked first, so that
+// violations are reported by path, as they are for OpenAPI schemas. KCL does
+// not report which attribute a failed check block is about, so a value that
+// passes those but not the schema as a whole is reported at path, with the
+// KCL error. The outcome is cached, since the schema compiled: whatever fails
+// from then on is the value.
+func (c *schemaCache) validateKCLSchema(path *field.Path, raw []byte, value any, s *fkcl.KCLSchema) (field.ErrorList, error) {
+	t, err := c.kclSchemaType(s.Name, s.Source)
+	if err != nil {
+		return nil, err
+	}
+	k := c.key("kcl", []byte(s.Name), []byte(s.Source), raw)
+	if v, cached := c.lookup(k); cached {
+		return v.(field.ErrorList), nil
+	}
+	errs := kclTypeErrors(path, value, t)
+	if len(errs) == 0 {
+		ok, err := kclvalidate.ValidateCode(string(raw), s.Source, &kclvalidate.ValidateOptions{
+			Schema: s.Name,
+			Format: "json",
+		})
+		if !ok || err != nil {
+			msg := fmt.Sprintf("does not match schema %s", s.Name)
+			if err != nil {
+				msg = fmt.Sprintf("%s: %s", msg, strings.TrimSpace(err.Error()))
+			}
+			errs = field.ErrorList{field.Invalid(path, field.OmitValueType{}, msg)}
+		}
+	}
+	c.store(k, errs)
+	return errs, nil
+}
+
+// kclTypeErrors returns the attributes of value that are missing or of the
+// wrong type for t. Types it does not know, such as unions and literal types,
+// are left to KCL.
+func kclTypeErrors(path *field.Path, value any, t *kcl.KclType) field.ErrorList {
+	var errs field.ErrorList
+	switch t.GetType() {
+	case "schema":
+		m, ok := value.(map[string]any)
+		if !ok {
+			return field.ErrorList{field.TypeInvalid(path, value, "must be an object")}
+		}
+		for _, name := range t.GetRequired() {
+			if v, found := m[name]; !found || v == nil {
+				errs = append(errs, field.Required(path.Child(name), ""))
+			}
+		}
+		names := make([]string, 0, len(t.GetProperties()))
+		for name := range t.GetProperties() {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			if v, found := m[name]; found && v != nil {
+				errs = append(errs, kclTypeErrors(path.Child(name), v, t.GetProperties()[name])...)
+			}
+		}
+	case "dict":
+		m, ok := value.(map[string]any)
+		if !ok {
+			return field.ErrorList{field.TypeInvalid(path, value, "must be an object")}
+		}
+		keys := make([]string, 0, len(m))
+		for k := range m {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			if m[k] != nil {
+				errs = append(errs, kclTypeErrors(path.Key(k), m[k], t.GetItem())...)
+			}
+		}
+	case "list":
+		l, ok := value.([]any)
+		if !ok {
+			return field.ErrorList{field.TypeInvalid(path, value, "must be a list")}
+		}
+		for i, v := range l {
+			if v != nil {
+				errs = append(errs, kclTypeErrors(path.Index(i), v, t.GetItem())...)
+			}
+		}
+	case "str":
+		if _, ok := value.(string); !ok {
+			errs = append(errs, field.TypeInvalid(path, value, "must be a string"))
+		}
+	case "bool":
+		if _, ok := value.(bool); !ok {
+			errs = append(errs, field.TypeInvalid(path, value, "must be a boolean"))
+		}
+	case "int":
+		if f, ok := value.(float64); !ok || f != math.Trunc(f) {
+			errs = append(errs, field.TypeInvalid(path, value, "must be an integer"))
+		}
+	case "float":
+		if _, ok := value.(float64); !ok {
+			errs = append(errs, field.TypeInvalid(path, value, "must be a number"))
+		}
+	}
+	return errs
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	"kcl-lang.io/kcl-go/pkg/kcl"
+
+	fkcl "github.com/crossplane-contrib/function-kcl/input/v1beta1"
+)
+
+const claimSchema = `{
+	"type": "object",
+	"properties": {
+		"spec": {
+			"type": "object",
+			"required": ["parameters"],
+			"properties": {
+				"parameters": {
+					"type": "object",
+					"required": ["size"],
+					"properties": {
+						"size": {"type": "string", "enum": ["small", "large"]},
+						"replicas": {"type": "integer", "minimum": 1}
+					}
+				}
+			}
+		}
+	}
+}`
+
+func TestValidateParams(t *testing.T) {
+	cases := map[string]struct {
+		reason string
+		params map[string]string
+		want   []string
+	}{
+		"Valid": {
+			reason: "Params that match their schema should not be reported.",
+			params: map[string]string{"oxr": `{"spec":{"parameters":{"size":"small","replicas":2}}}`},
+		},
+		"Required": {
+			reason: "A missing field should be reported by its path.",
+			params: map[string]string{"oxr": `{"spec":{"parameters":{}}}`},
+			want:   []string{"oxr.spec.parameters.size: Required value"},
+		},
+		"Enum": {
+			reason: "A value outside of an enum should be reported with the supported values.",
+			params: map[string]string{"oxr": `{"spec":{"parameters":{"size":"medium"}}}`},
+			want:   []string{`oxr.spec.parameters.size: Unsupported value: "medium": supported values: "small", "large"`},
+		},
+		"Multiple": {
+			reason: "Every violation should be reported.",
+			params: map[string]string{"oxr": `{"spec":{"parameters":{"replicas":0}}}`},
+			want: []string{
+				"oxr.spec.parameters.replicas: Invalid value: 0: spec.parameters.replicas in body should be greater than or equal to 1",
+				"oxr.spec.parameters.size: Required value",
+			},
+		},
+		"Missing": {
+			reason: "A param with a schema should be required.",
+			params: map[string]string{},
+			want:   []string{"oxr: Required value: param has a schema but is not set"},
+		},
+	}
+	// The cache is shared across cases, as it is across reconciles.
+	c := newSchemaCache(8)
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			in := &fkcl.KCLInput{Spec: fkcl.RunSpec{
+				Params: map[string]runtime.RawExtension{},
+				Schemas: map[string]fkcl.ParamsSchema{
+					"oxr": {OpenAPIV3Schema: &runtime.RawExtension{Raw: []byte(claimSchema)}},
+				},
+			}}
+			for k, v := range tc.params {
+				in.Spec.Params[k] = runtime.RawExtension{Raw: []byte(v)}
+			}
+			errs, err := validateParams(c, in)
+			if err != nil {
+				t.Fatalf("%s\nvalidateParams(...): unexpected error %v", tc.reason, err)
+			}
+			var got []string
+			for _, e := range errs {
+				got = append(got, e.Error())
+			}
+			if diff := cmp.Diff(tc.want, got); diff != "" {
+				t.Errorf("%s\nvalidateParams(...): -want, +got:\n%s", tc.reason, diff)
+			}
+		})
+	}
+}
+
+func TestKCLTypeErrors(t *testing.T) {
+	// schema Claim:
+	//     spec: Spec
+	// schema Spec:
+	//     size: str
+	//     replicas?: int
+	//     zones?: [str]
+	//     tags?: {str:str}
+	claim := &kcl.KclType{
+		Type:     "schema",
+		Required: []string{"spec"},
+		Properties: map[string]*kcl.KclType{
+			"spec": {
+				Type:     "schema",
+				Required: []string{"size"},
+				Properties: map[string]*kcl.KclType{
+					"size":     {Type: "str"},
+					"replicas": {Type: "int"},
+					"zones":    {Type: "list", Item: &kcl.KclType{Type: "str"}},
+					"tags":     {Type: "dict", Key: &kcl.KclType{Type: "str"}, Item: &kcl.KclType{Type: "str"}},
+				},
+			},
+		},
+	}
+	cases := map[string]struct {
+		reason string
+		value  any
+		want   []string
+	}{
+		"Valid": {
+			reason: "A value that matches the schema should not be reported.",
+			value:  map[string]any{"spec": map[string]any{"size": "small", "replicas": float64(2), "zones": []any{"a"}}},
+		},
+		"Required": {
+			reason: "A missing attribute should be reported by its path.",
+			value:  map[string]any{"spec": map[string]any{}},
+			want:   []string{"oxr.spec.size: Required value"},
+		},
+		"Types": {
+			reason: "Attributes of the wrong type should be reported by their path.",
+			value:  map[string]any{"spec": map[string]any{"size": "small", "replicas": 1.5, "zones": []any{"a", float64(1)}, "tags": map[string]any{"team": true}}},
+			want: []string{
+				"oxr.spec.replicas: Invalid value: 1.5: must be an integer",
+				"oxr.spec.tags[team]: Invalid value: true: must be a string",
+				"oxr.spec.zones[1]: Invalid value: 1: must be a string",
+			},
+		},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			var got []string
+			for _, e := range kclTypeErrors(field.NewPath("oxr"), tc.value, claim) {
+				got = append(got, e.Error())
+			}
+			if diff := cmp.Diff(tc.want, got); diff != "" {
+				t.Errorf("%s\nkclTypeErrors(...): -want, +got:\n%s", tc.reason, diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"sync"
+
+	"github.com/crossplane/crossplane-runtime/v2/pkg/errors"
+	"k8s.io/kube-openapi/pkg/validation/spec"
+	"kcl-lang.io/kcl-go/pkg/kcl"
+)
+
+// Params are validated against their schemas on every reconcile, while the
+// schemas themselves only change when the Composition does. The schema cache
+// keeps the parsed OpenAPI schemas keyed on the sha256 of their bytes, so that
+// a schema is parsed once rather than on every RunFunction.
+//
+// KCL schemas are compiled once into their types, keyed on the schema name and
+// its code, and the outcome of validating a value against them, matching or
+// not, is cached too, keyed on the schema and the value: kcl-go validates the
+// value and the schema code together, so the outcome is all there is to reuse
+// of a check block. Like the render cache this is sound because compiling and
+// validating are deterministic over those bytes.
+//
+// The cache is bounded to FUNCTION_KCL_SCHEMA_CACHE_SIZE entries (default
+// 256; 0 = disabled), evicting the least recently used.
+
+type schemaCache struct {
+	mu    sync.Mutex
+	max   int
+	ll    *list.List // front = most recently used
+	items map[string]*list.Element
+}
+
+type schemaCacheEntry struct {
+	key   string
+	value any
+}
+
+const (
+	envSchemaCacheSize     = "FUNCTION_KCL_SCHEMA_CACHE_SIZE"
+	defaultSchemaCacheSize = 256
+)
+
+// newSchemaCacheFromEnv returns a cache configured from the environment, or
+// nil when disabled. A nil *schemaCache is a safe no-op.
+func newSchemaCacheFromEnv() *schemaCache {
+	return newSchemaCache(int(envUint(envSchemaCacheSize, defaultSchemaCacheSize)))
+}
+
+// newSchemaCache returns a cache holding up to max entries, or nil when
+// max <= 0 (disabled).
+func newSchemaCache(max int) *schemaCache {
+	if max <= 0 {
+		return nil
+	}
+	return &schemaCache{
+		max:   max,
+		ll:    list.New(),
+		items: make(map[string]*list.Element, max),
+	}
+}
+
+// key derives the cache key from a kind, which keeps OpenAPI and KCL entries
+// apart, and the content it describes.
+func (c *schemaCache) key(kind string, content ...[]byte) string {
+	h := sha256.New()
+	h.Write([]byte(kind))
+	for _, b := range content {
+		// Length-prefix each part so that different splits never collide.
+		h.Write(binary.LittleEndian.AppendUint64(nil, uint64(len(b))))
+		h.Write(b)
+	}
+	return string(h.Sum(nil))
+}
+
+func (c *schemaCache) lookup(k string) (any, bool) {
+	if c == nil {
+		return nil, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, ok := c.items[k]
+	if !ok {
+		return nil, false
+	}
+	c.ll.MoveToFront(el)
+	return el.Value.(*schemaCacheEntry).value, true
+}
+
+func (c *schemaCache) store(k string, v any) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.items[k]; ok {
+		el.Value.(*schemaCacheEntry).value = v
+		c.ll.MoveToFront(el)
+		return
+	}
+	c.items[k] = c.ll.PushFront(&schemaCacheEntry{key: k, value: v})
+	for c.ll.Len() > c.max {
+		oldest := c.ll.Back()
+		c.ll.Remove(oldest)
+		delete(c.items, oldest.Value.(*schemaCacheEntry).key)
+	}
+}
+
+// openAPISchema returns the parsed OpenAPI v3 schema. The returned schema is
+// shared and must not be modified.
+func (c *schemaCache) openAPISchema(schema []byte) (*spec.Schema, error) {
+	k := c.key("openapi", schema)
+	if v, ok := c.lookup(k); ok {
+		return v.(*spec.Schema), nil
+	}
+	s := &spec.Schema{}
+	if err := json.Unmarshal(schema, s); err != nil {
+		return nil, err
+	}
+	c.store(k, s)
+	return s, nil
+}
+
+// kclSchemaType returns the type of the named schema defined by source. The
+// returned type is shared and must not be modified. Errors are not cached,
+// since kcl-go may fail for reasons other than the schema.
+func (c *schemaCache) kclSchemaType(name, source string) (*kcl.KclType, error) {
+	k := c.key("kcl-type", []byte(name), []byte(source))
+	if v, ok := c.lookup(k); ok {
+		return v.(*kcl.KclType), nil
+	}
+	types, err := kcl.GetSchemaTypeMapping("schema.k", source, name)
+	if err != nil {
+		return nil, err
+	}
+	t, ok := types[name]
+	if !ok {
+		return nil, errors.Errorf("schema %s is not defined", name)
+	}
+	c.store(k, t)
+	return t, nil
+}
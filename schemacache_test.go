@@ -0,0 +1,39 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestSchemaCacheOpenAPISchema(t *testing.T) {
+	c := newSchemaCache(1)
+	a := []byte(`{"type":"object"}`)
+	b := []byte(`{"type":"string"}`)
+
+	first, err := c.openAPISchema(a)
+	if err != nil {
+		t.Fatalf("openAPISchema(a): %v", err)
+	}
+	if again, _ := c.openAPISchema(a); again != first {
+		t.Error("openAPISchema(a): want the cached schema for identical bytes")
+	}
+	if _, err := c.openAPISchema(b); err != nil {
+		t.Fatalf("openAPISchema(b): %v", err)
+	}
+	if again, _ := c.openAPISchema(a); again == first {
+		t.Error("openAPISchema(a): want a re-parsed schema after eviction")
+	}
+	if _, err := c.openAPISchema([]byte(`{`)); err == nil {
+		t.Error("openAPISchema(invalid): want error")
+	}
+}
+
+func TestNilSchemaCacheIsNoOp(t *testing.T) {
+	var c *schemaCache
+	c.store("k", true) // must not panic
+	if _, ok := c.lookup("k"); ok {
+		t.Fatal("nil cache must miss")
+	}
+	if _, err := c.openAPISchema([]byte(`{"type":"object"}`)); err != nil {
+		t.Fatalf("openAPISchema(...): %v", err)
+	}
+}